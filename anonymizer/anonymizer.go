@@ -0,0 +1,190 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package anonymizer replaces hostnames, IP addresses, replica set, database and collection
+// names with stable tokens, so a bundle can be shared without revealing infrastructure names.
+// The same name always maps to the same token within a run; the mapping is written to a
+// local file that stays outside the outputs directory.
+package anonymizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kind is the type of name a token stands for.
+type Kind string
+
+const (
+	KindCluster    Kind = "cluster"
+	KindHost       Kind = "host"
+	KindIP         Kind = "ip"
+	KindReplicaSet Kind = "rs"
+	KindDatabase   Kind = "db"
+	KindCollection Kind = "coll"
+	kindNamespace  Kind = "ns"
+)
+
+// systemDatabases hold no customer names and stay readable.
+var systemDatabases = map[string]bool{"admin": true, "config": true, "local": true}
+
+// keptIPs reveal nothing about the infrastructure.
+var keptIPs = map[string]bool{"127.0.0.1": true, "0.0.0.0": true, "255.255.255.255": true}
+
+// Anonymizer assigns and applies tokens. It is safe for concurrent use.
+type Anonymizer struct {
+	mu      sync.Mutex
+	tokens  map[Kind]map[string]string
+	next    map[Kind]int
+	matcher *nameMatcher // rebuilt when names are added
+}
+
+// New returns an Anonymizer without any names.
+func New() *Anonymizer {
+	return &Anonymizer{
+		tokens: make(map[Kind]map[string]string),
+		next:   make(map[Kind]int),
+	}
+}
+
+// Token returns the token for name, assigning the next free one on first use.
+// Empty names and system databases are returned unchanged.
+func (a *Anonymizer) Token(kind Kind, name string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.token(kind, name)
+}
+
+func (a *Anonymizer) token(kind Kind, name string) string {
+	if name == "" || (kind == KindDatabase && systemDatabases[name]) || (kind == KindIP && keptIPs[name]) {
+		return name
+	}
+	if a.tokens[kind] == nil {
+		a.tokens[kind] = make(map[string]string)
+	}
+	if t, ok := a.tokens[kind][name]; ok {
+		return t
+	}
+	a.next[kind]++
+	t := fmt.Sprintf("%s-%d", kind, a.next[kind])
+	a.tokens[kind][name] = t
+	a.matcher = nil
+	return t
+}
+
+// Host returns the token for a hostname. A trailing ":port" is kept.
+func (a *Anonymizer) Host(hostport string) string {
+	host, port, found := strings.Cut(hostport, ":")
+	kind := KindHost
+	if ipv4Exact.MatchString(host) {
+		kind = KindIP
+	}
+	t := a.Token(kind, host)
+	if found {
+		return t + ":" + port
+	}
+	return t
+}
+
+// Namespace registers a "db.collection" namespace and returns its token.
+func (a *Anonymizer) Namespace(ns string) string {
+	db, coll, found := strings.Cut(ns, ".")
+	if !found || db == "" || coll == "" {
+		return a.Token(KindDatabase, ns)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if systemDatabases[db] {
+		return ns
+	}
+	t := a.token(KindDatabase, db) + "." + a.token(KindCollection, coll)
+	if a.tokens[kindNamespace] == nil {
+		a.tokens[kindNamespace] = make(map[string]string)
+	}
+	if _, ok := a.tokens[kindNamespace][ns]; !ok {
+		a.tokens[kindNamespace][ns] = t
+		a.matcher = nil
+	}
+	return t
+}
+
+// ipv4Pattern finds IPv4 addresses; boundaries are checked by replaceIPs.
+var (
+	ipv4Pattern = regexp.MustCompile(`(?:\d{1,3}\.){3}\d{1,3}`)
+	ipv4Exact   = regexp.MustCompile(`^(?:\d{1,3}\.){3}\d{1,3}$`)
+)
+
+// mapping is the layout of the mapping file, real name to token per kind.
+type mapping struct {
+	Warning string                     `json:"warning"`
+	Created string                     `json:"created"`
+	Tokens  map[Kind]map[string]string `json:"tokens"`
+}
+
+// WriteMapping writes the real name to token mapping to path, readable by the owner only.
+// The file must never be shared together with the bundle.
+func (a *Anonymizer) WriteMapping(path string) error {
+	a.mu.Lock()
+	m := mapping{
+		Warning: "This file maps anonymized tokens back to real names. Do not share it with the diagnostic bundle.",
+		Created: time.Now().UTC().Format(time.RFC3339),
+		Tokens:  make(map[Kind]map[string]string),
+	}
+	for kind, names := range a.tokens {
+		if kind == kindNamespace {
+			continue
+		}
+		m.Tokens[kind] = make(map[string]string, len(names))
+		for name, t := range names {
+			m.Tokens[kind][name] = t
+		}
+	}
+	a.mu.Unlock()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// names returns every registered name with its kind and token, longest first so the
+// matcher prefers "shop.users" over "shop".
+func (a *Anonymizer) names() []nameEntry {
+	entries := make([]nameEntry, 0)
+	for kind, names := range a.tokens {
+		if kind == KindIP || kind == KindCluster {
+			continue
+		}
+		for name, t := range names {
+			entries = append(entries, nameEntry{Name: name, Kind: kind, Token: t})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if len(entries[i].Name) != len(entries[j].Name) {
+			return len(entries[i].Name) > len(entries[j].Name)
+		}
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Kind < entries[j].Kind
+	})
+	return entries
+}
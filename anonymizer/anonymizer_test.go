@@ -0,0 +1,180 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package anonymizer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const sampleGetMongoData = `[
+    {"host": "db1.corp.example.com", "section": "server_info", "subsection": "shell_hostname", "output": "laptop-7"},
+    {"section": "replicaset_info", "subsection": "replica_set_config",
+     "output": {"_id": "payments-rs", "members": [{"host": "db1.corp.example.com:27017"}, {"host": "10.20.30.40:27017"}]}},
+    {"section": "data_info", "subsection": "list_of_databases",
+     "output": {"databases": [{"name": "admin"}, {"name": "shop"}]}},
+    {"section": "data_info", "subsection": "list_of_collections_for_database_'shop'", "output": ["users", "orders"]}
+]`
+
+func learnedAnonymizer(t *testing.T) (*Anonymizer, string) {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "getMongoData.json")
+	if err := os.WriteFile(path, []byte(sampleGetMongoData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	a := New()
+	if err := a.LearnDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return a, dir
+}
+
+func TestReplaceIsStableAcrossArtifacts(t *testing.T) {
+	a, _ := learnedAnonymizer(t)
+
+	logLine := `{"msg":"Slow query","attr":{"ns":"shop.users","command":{"find":"users","$db":"shop"},` +
+		`"remote":"10.20.30.40:51000","host":"db1.corp.example.com:27017","replSet":"payments-rs"}}`
+	got := string(a.Replace([]byte(logLine)))
+	for _, secret := range []string{"shop", "users", "10.20.30.40", "db1.corp.example.com", "payments-rs"} {
+		if strings.Contains(got, secret) {
+			t.Fatalf("%q left in %s", secret, got)
+		}
+	}
+
+	host := a.Host("db1.corp.example.com:27017")
+	if !strings.HasPrefix(host, "host-") || !strings.Contains(got, host) {
+		t.Fatalf("token %s not used consistently in %s", host, got)
+	}
+	if a.Namespace("shop.users") != a.Token(KindDatabase, "shop")+"."+a.Token(KindCollection, "users") {
+		t.Fatal("namespace token does not combine database and collection tokens")
+	}
+}
+
+func TestReplaceLeavesWordsAndSystemNames(t *testing.T) {
+	a, _ := learnedAnonymizer(t)
+
+	text := "users of the shop connected to admin.system.users on 127.0.0.1 version 4.4.1"
+	if got := string(a.Replace([]byte(text))); got != text {
+		t.Fatalf("plain words changed: %s", got)
+	}
+
+	// a longer hostname that merely starts with a known one is a different host
+	got := string(a.Replace([]byte("db1.corp.example.com.evil.net")))
+	if got != "db1.corp.example.com.evil.net" {
+		t.Fatalf("prefix of longer hostname replaced: %s", got)
+	}
+}
+
+func TestAnonymizeDirRewritesArchivesAndJSON(t *testing.T) {
+	a, dir := learnedAnonymizer(t)
+
+	var archive bytes.Buffer
+	gzw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(gzw)
+	content := []byte(`{"attr":{"remote":"10.20.30.40:1234","ns":"shop.orders"}}` + "\n")
+	if err := tw.WriteHeader(&tar.Header{Name: "mongod.log", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(content)
+	tw.Close()
+	gzw.Close()
+	if err := os.WriteFile(filepath.Join(dir, "logarchive.tar.gz"), archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ftdcarchive.tar.gz"), []byte("binary"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	skipped, err := a.AnonymizeDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 1 || filepath.Base(skipped[0]) != "ftdcarchive.tar.gz" {
+		t.Fatalf("unexpected skipped files %v", skipped)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "logarchive.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	if _, err := tr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(tr)
+	if bytes.Contains(data, []byte("10.20.30.40")) || bytes.Contains(data, []byte("shop.orders")) {
+		t.Fatalf("log entry not anonymized: %s", data)
+	}
+
+	gmd, _ := os.ReadFile(filepath.Join(dir, "getMongoData.json"))
+	if bytes.Contains(gmd, []byte("payments-rs")) || bytes.Contains(gmd, []byte("db1.corp")) {
+		t.Fatalf("getMongoData not anonymized: %s", gmd)
+	}
+
+	mapPath := filepath.Join(t.TempDir(), "map.json")
+	if err := a.WriteMapping(mapPath); err != nil {
+		t.Fatal(err)
+	}
+	var m mapping
+	data, _ = os.ReadFile(mapPath)
+	if err := json.Unmarshal(data, &m); err != nil || m.Tokens[KindReplicaSet]["payments-rs"] == "" {
+		t.Fatalf("mapping missing replica set: %s (%v)", data, err)
+	}
+}
+
+func TestWithholdBinaryArchives(t *testing.T) {
+	dir := t.TempDir()
+	node := filepath.Join(dir, "cluster-1", "host-1_27017")
+	if err := os.MkdirAll(node, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ftdcarchive.tar.gz", "logarchive.tar.gz"} {
+		if err := os.WriteFile(filepath.Join(node, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dest := filepath.Join(t.TempDir(), "withheld")
+	moved, err := WithholdBinaryArchives(dir, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(dest, "cluster-1", "host-1_27017", "ftdcarchive.tar.gz")
+	if len(moved) != 1 || moved[0] != want {
+		t.Fatalf("moved %v, want [%s]", moved, want)
+	}
+	if _, err := os.Stat(filepath.Join(node, "ftdcarchive.tar.gz")); err == nil {
+		t.Error("the FTDC archive should be gone from the outputs")
+	}
+	if _, err := os.Stat(filepath.Join(node, "logarchive.tar.gz")); err != nil {
+		t.Errorf("the log archive should stay: %v", err)
+	}
+	if fi, err := os.Stat(dest); err != nil || fi.Mode().Perm() != 0700 {
+		t.Errorf("withheld dir should be private: %v %v", fi.Mode(), err)
+	}
+}
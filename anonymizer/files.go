@@ -0,0 +1,260 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package anonymizer
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// binaryArchives hold data that cannot be rewritten as text and are left as collected.
+var binaryArchives = map[string]bool{"ftdcarchive.tar.gz": true}
+
+// opaqueExtensions are compressed or BSON entries that cannot be rewritten and are copied unchanged.
+var opaqueExtensions = map[string]bool{
+	".bz2": true, ".xz": true, ".zst": true, ".lz4": true, ".zip": true, ".bson": true,
+}
+
+// textExtensions are rewritten line by line.
+var textExtensions = map[string]bool{".json": true, ".log": true, ".txt": true, ".md": true, ".csv": true}
+
+// ReplaceLines copies in to out replacing names line by line.
+func (a *Anonymizer) ReplaceLines(in io.Reader, out io.Writer) error {
+	reader := bufio.NewReaderSize(in, 1024*1024)
+	writer := bufio.NewWriter(out)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if _, werr := writer.Write(a.Replace(line)); werr != nil {
+				return werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// replaceFile rewrites path through a temporary file in the same directory.
+func replaceFile(path string, rewrite func(in io.Reader, out io.Writer) error) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(path), ".anonymize-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	if err := rewrite(in, out); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), path)
+}
+
+// rewriteEntry writes the anonymized content of one archive entry to a temporary file.
+// Compressed entries, such as rotated mongod.log.*.gz files, stay compressed.
+func (a *Anonymizer) rewriteEntry(name string, in io.Reader) (*os.File, error) {
+	tmp, err := os.CreateTemp("", "dcrcli-anonymize-*")
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*os.File, error) {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	if strings.HasSuffix(name, ".gz") {
+		gzr, err := gzip.NewReader(in)
+		if err != nil {
+			return fail(err)
+		}
+		gzw := gzip.NewWriter(tmp)
+		if err := a.ReplaceLines(gzr, gzw); err != nil {
+			return fail(err)
+		}
+		if err := gzw.Close(); err != nil {
+			return fail(err)
+		}
+	} else if err := a.ReplaceLines(in, tmp); err != nil {
+		return fail(err)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	return tmp, nil
+}
+
+// rewriteTarGz anonymizes the names and content of every file in a .tar.gz archive.
+// It returns the entries whose content was copied unchanged.
+func (a *Anonymizer) rewriteTarGz(in io.Reader, out io.Writer) ([]string, error) {
+	var kept []string
+	gzr, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+	tr := tar.NewReader(gzr)
+
+	gzw := gzip.NewWriter(out)
+	tw := tar.NewWriter(gzw)
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return kept, err
+		}
+		header.Name = string(a.Replace([]byte(header.Name)))
+		if header.Typeflag != tar.TypeReg {
+			if err := tw.WriteHeader(header); err != nil {
+				return kept, err
+			}
+			continue
+		}
+		if opaqueExtensions[filepath.Ext(header.Name)] {
+			kept = append(kept, header.Name)
+			if err := tw.WriteHeader(header); err != nil {
+				return kept, err
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				return kept, err
+			}
+			continue
+		}
+
+		tmp, err := a.rewriteEntry(header.Name, tr)
+		if err != nil {
+			return kept, fmt.Errorf("error anonymizing %s: %w", header.Name, err)
+		}
+		err = writeTarEntry(tw, header, tmp)
+		tmp.Close()
+		os.Remove(tmp.Name())
+		if err != nil {
+			return kept, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return kept, err
+	}
+	return kept, gzw.Close()
+}
+
+func writeTarEntry(tw *tar.Writer, header *tar.Header, content *os.File) error {
+	fi, err := content.Stat()
+	if err != nil {
+		return err
+	}
+	header.Size = fi.Size()
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, content, header.Size)
+	return err
+}
+
+// WithholdBinaryArchives moves the archives below dir that cannot be anonymized, such as
+// FTDC with its hostnames and replica set names, to the same relative path below dest,
+// which only the current user can read. It returns the moved archives' new paths.
+func WithholdBinaryArchives(dir string, dest string) ([]string, error) {
+	var moved []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !binaryArchives[d.Name()] {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+			return err
+		}
+		if err := os.Rename(path, target); err != nil {
+			return err
+		}
+		moved = append(moved, target)
+		return nil
+	})
+	return moved, err
+}
+
+// AnonymizeDir rewrites every text file and .tar.gz archive below dir. FTDC archives are
+// binary and are left unchanged. It returns the paths that were not anonymized.
+func (a *Anonymizer) AnonymizeDir(dir string) ([]string, error) {
+	var skipped []string
+	var errs []error
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		name := d.Name()
+		switch {
+		case binaryArchives[name]:
+			skipped = append(skipped, path)
+		case strings.HasSuffix(name, ".tar.gz"):
+			err := replaceFile(path, func(in io.Reader, out io.Writer) error {
+				kept, err := a.rewriteTarGz(in, out)
+				for _, entry := range kept {
+					skipped = append(skipped, path+":"+entry)
+				}
+				return err
+			})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			}
+		case textExtensions[filepath.Ext(name)]:
+			if err := replaceFile(path, a.ReplaceLines); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			}
+		default:
+			skipped = append(skipped, path)
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return skipped, errors.Join(errs...)
+}
@@ -0,0 +1,170 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package anonymizer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hostKeys hold a hostname or "host:port" in getMongoData output.
+var hostKeys = map[string]bool{
+	"host":           true,
+	"hostname":       true,
+	"me":             true,
+	"primary":        true,
+	"syncSourceHost": true,
+	"syncingTo":      true,
+}
+
+// hostListKeys hold arrays of "host:port".
+var hostListKeys = map[string]bool{"hosts": true, "passives": true, "arbiters": true}
+
+// replicaSetKeys hold a replica set name.
+var replicaSetKeys = map[string]bool{"setName": true, "set": true}
+
+// LearnGetMongoData registers the hostnames, replica set, database and collection names
+// found in a getMongoData.json file, so they are replaced wherever they appear.
+func (a *Anonymizer) LearnGetMongoData(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var sections []map[string]any
+	if err := json.Unmarshal(data, &sections); err != nil {
+		return fmt.Errorf("error parsing %s: %w", path, err)
+	}
+
+	for _, section := range sections {
+		subsection, _ := section["subsection"].(string)
+		output := section["output"]
+
+		switch {
+		case subsection == "shell_hostname":
+			if s, ok := output.(string); ok {
+				a.Host(s)
+			}
+		case subsection == "list_of_databases":
+			a.learnDatabaseList(output)
+		case subsection == "replica_set_config":
+			if obj, ok := output.(map[string]any); ok {
+				if s, ok := obj["_id"].(string); ok {
+					a.Token(KindReplicaSet, s)
+				}
+			}
+		case strings.HasPrefix(subsection, "list_of_collections_for_database_"):
+			db := strings.Trim(strings.TrimPrefix(subsection, "list_of_collections_for_database_"), "'")
+			if list, ok := output.([]any); ok {
+				for _, c := range list {
+					if s, ok := c.(string); ok {
+						a.Namespace(db + "." + s)
+					}
+				}
+			}
+		}
+		a.learnValue(section)
+	}
+	return nil
+}
+
+func (a *Anonymizer) learnDatabaseList(output any) {
+	obj, ok := output.(map[string]any)
+	if !ok {
+		return
+	}
+	dbs, _ := obj["databases"].([]any)
+	for _, d := range dbs {
+		if db, ok := d.(map[string]any); ok {
+			if name, ok := db["name"].(string); ok {
+				a.Token(KindDatabase, name)
+			}
+		}
+	}
+}
+
+// learnValue walks any document for fields that are known to hold names.
+func (a *Anonymizer) learnValue(v any) {
+	switch val := v.(type) {
+	case map[string]any:
+		for key, child := range val {
+			s, isString := child.(string)
+			switch {
+			case isString && hostKeys[key]:
+				a.learnHostString(s)
+			case isString && replicaSetKeys[key]:
+				a.Token(KindReplicaSet, s)
+			case isString && key == "ns":
+				a.Namespace(s)
+			case isString && key == "db":
+				a.Token(KindDatabase, s)
+			case hostListKeys[key]:
+				if list, ok := child.([]any); ok {
+					for _, h := range list {
+						if s, ok := h.(string); ok {
+							a.learnHostString(s)
+						}
+					}
+				}
+			}
+			a.learnValue(child)
+		}
+	case []any:
+		for _, child := range val {
+			a.learnValue(child)
+		}
+	}
+}
+
+// learnHostString registers "host:port", a bare hostname, or a shard connection string
+// "rs0/host1:27017,host2:27017".
+func (a *Anonymizer) learnHostString(s string) {
+	if s == "" || strings.ContainsAny(s, " \t") {
+		return
+	}
+	if rs, hosts, found := strings.Cut(s, "/"); found {
+		a.Token(KindReplicaSet, rs)
+		s = hosts
+	}
+	for _, h := range strings.Split(s, ",") {
+		if h != "" {
+			a.Host(h)
+		}
+	}
+}
+
+// LearnDir registers the names in every getMongoData.json below dir. Files that cannot
+// be parsed are reported but do not stop the others from being read.
+func (a *Anonymizer) LearnDir(dir string) error {
+	var errs []error
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && d.Name() == "getMongoData.json" {
+			if err := a.LearnGetMongoData(path); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
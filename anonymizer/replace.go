@@ -0,0 +1,208 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package anonymizer
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+type nameEntry struct {
+	Name  string
+	Kind  Kind
+	Token string
+}
+
+// nameMatcher finds registered names in text. The regexp only locates candidates;
+// whether a candidate is a whole name is decided by the boundary rules of its kind.
+type nameMatcher struct {
+	re      *regexp.Regexp
+	byName  map[string][]nameEntry
+	lengths []int // distinct name lengths, longest first
+}
+
+func newNameMatcher(entries []nameEntry) *nameMatcher {
+	m := &nameMatcher{byName: make(map[string][]nameEntry)}
+	if len(entries) == 0 {
+		return m
+	}
+	quoted := make([]string, 0, len(entries))
+	seenLen := make(map[int]bool)
+	for _, e := range entries {
+		if _, ok := m.byName[e.Name]; !ok {
+			quoted = append(quoted, regexp.QuoteMeta(e.Name))
+		}
+		m.byName[e.Name] = append(m.byName[e.Name], e)
+		if !seenLen[len(e.Name)] {
+			seenLen[len(e.Name)] = true
+			m.lengths = append(m.lengths, len(e.Name))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(m.lengths)))
+	m.re = regexp.MustCompile(joinAlternation(quoted))
+	return m
+}
+
+func joinAlternation(parts []string) string {
+	var b bytes.Buffer
+	for i, p := range parts {
+		if i > 0 {
+			b.WriteByte('|')
+		}
+		b.WriteString(p)
+	}
+	return b.String()
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '-' || c == '$' ||
+		(c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// wholeName reports whether text[start:end] is a complete name of kind, not part of a
+// longer word. Databases must be quoted or followed by ".", collections must be quoted,
+// so common words that happen to be database or collection names are left alone.
+func wholeName(text []byte, start int, end int, kind Kind) bool {
+	var before, after, afterNext byte
+	if start > 0 {
+		before = text[start-1]
+	}
+	if end < len(text) {
+		after = text[end]
+	}
+	if end+1 < len(text) {
+		afterNext = text[end+1]
+	}
+	quote := func(c byte) bool { return c == '"' || c == '\'' }
+
+	switch kind {
+	case KindDatabase:
+		return !isWordByte(before) && before != '.' && (quote(after) || after == '.')
+	case KindCollection:
+		return quote(before) && quote(after)
+	default:
+		if isWordByte(before) || before == '.' || isWordByte(after) {
+			return false
+		}
+		// "host1.example.com" must not match a name registered as "host1"
+		return !(after == '.' && isWordByte(afterNext))
+	}
+}
+
+// replaceNames replaces registered names in text.
+func (m *nameMatcher) replaceNames(text []byte) []byte {
+	if m.re == nil {
+		return text
+	}
+	var out bytes.Buffer
+	off := 0
+	for off < len(text) {
+		loc := m.re.FindIndex(text[off:])
+		if loc == nil {
+			break
+		}
+		start := off + loc[0]
+		token, end := m.longestWholeName(text, start)
+		if end < 0 {
+			out.Write(text[off : start+1])
+			off = start + 1
+			continue
+		}
+		out.Write(text[off:start])
+		out.WriteString(token)
+		off = end
+	}
+	if off == 0 {
+		return text
+	}
+	out.Write(text[off:])
+	return out.Bytes()
+}
+
+// longestWholeName tries the names that start at text[start], longest first, and returns
+// the token and end of the first one that is a whole name, or -1.
+func (m *nameMatcher) longestWholeName(text []byte, start int) (string, int) {
+	for _, l := range m.lengths {
+		end := start + l
+		if end > len(text) {
+			continue
+		}
+		for _, e := range m.byName[string(text[start:end])] {
+			if wholeName(text, start, end, e.Kind) {
+				return e.Token, end
+			}
+		}
+	}
+	return "", -1
+}
+
+// validIPv4 checks each octet of a matched address.
+func validIPv4(s []byte) bool {
+	for _, part := range bytes.Split(s, []byte(".")) {
+		n, err := strconv.Atoi(string(part))
+		if err != nil || n > 255 {
+			return false
+		}
+	}
+	return true
+}
+
+// replaceIPs replaces IPv4 addresses, registering new ones as they are found.
+func (a *Anonymizer) replaceIPs(text []byte) []byte {
+	locs := ipv4Pattern.FindAllIndex(text, -1)
+	if locs == nil {
+		return text
+	}
+	var out bytes.Buffer
+	off := 0
+	for _, loc := range locs {
+		start, end := loc[0], loc[1]
+		if start > 0 && (text[start-1] == '.' || (text[start-1] >= '0' && text[start-1] <= '9')) {
+			continue
+		}
+		if end < len(text) && (text[end] >= '0' && text[end] <= '9') {
+			continue
+		}
+		if end+1 < len(text) && text[end] == '.' && text[end+1] >= '0' && text[end+1] <= '9' {
+			continue
+		}
+		if !validIPv4(text[start:end]) {
+			continue
+		}
+		out.Write(text[off:start])
+		out.WriteString(a.Token(KindIP, string(text[start:end])))
+		off = end
+	}
+	if off == 0 {
+		return text
+	}
+	out.Write(text[off:])
+	return out.Bytes()
+}
+
+// Replace returns text with every registered name and every IPv4 address replaced by
+// its token.
+func (a *Anonymizer) Replace(text []byte) []byte {
+	a.mu.Lock()
+	if a.matcher == nil {
+		a.matcher = newNameMatcher(a.names())
+	}
+	matcher := a.matcher
+	a.mu.Unlock()
+
+	return a.replaceIPs(matcher.replaceNames(text))
+}
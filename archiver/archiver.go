@@ -16,91 +16,206 @@ package archiver
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
-	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 	"os"
-	"path/filepath"
-	"regexp"
-	"strings"
+	"time"
 )
 
-func TarWithPatternMatch(src string, filepattern string, writers ...io.Writer) error {
-	if _, err := os.Stat(src); err != nil {
-		return fmt.Errorf("Unable to tar files - %v", err.Error())
+// BufferEntry is an in-memory file to be written into a tar archive.
+type BufferEntry struct {
+	Name string
+	Data []byte
+}
+
+// TarBuffers writes the given in-memory entries into a gzip compressed tar stream.
+func TarBuffers(entries []BufferEntry, writers ...io.Writer) error {
+	ts := NewTarStream(writers...)
+	now := time.Now()
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:    entry.Name,
+			Mode:    0644,
+			Size:    int64(len(entry.Data)),
+			ModTime: now,
+		}
+		if err := ts.Add(header, bytes.NewReader(entry.Data), false); err != nil {
+			ts.Close()
+			return fmt.Errorf("TarBuffers: %w", err)
+		}
 	}
+	// closing writes the tar trailer and the checksum of the gzip stream
+	if err := ts.Close(); err != nil {
+		return fmt.Errorf("TarBuffers: %w", err)
+	}
+	return nil
+}
 
-	mw := io.MultiWriter(writers...)
+// FileEntry is a file on disk to be written into a tar archive under Name.
+// Stored entries are already compressed, e.g. rotated logs ending in .gz, and are
+// written without being compressed a second time.
+type FileEntry struct {
+	Name   string
+	Path   string
+	Stored bool
+}
 
-	gzw := gzip.NewWriter(mw)
-	defer gzw.Close()
+// gzipMembers writes a gzip stream as consecutive members so the compression level can
+// change between tar entries. gzip -d, tar -z and gzip.Reader read consecutive members
+// as a single stream.
+type gzipMembers struct {
+	out   io.Writer
+	gzw   *gzip.Writer
+	level int
+}
 
-	tw := tar.NewWriter(gzw)
-	defer tw.Close()
+func newGzipMembers(out io.Writer) *gzipMembers {
+	return &gzipMembers{out: out, gzw: gzip.NewWriter(out), level: gzip.DefaultCompression}
+}
 
-	return filepath.Walk(src, func(file string, fi os.FileInfo, err error) error {
-		if err != nil {
-			// diagnostic.data/metrics.interim is recreated/removed by mongod while we walk; treat as non-fatal.
-			if errors.Is(err, fs.ErrNotExist) {
-				fmt.Println("WARNING: In archiving process skipping path removed during walk (e.g. metrics.interim):", err)
-				return nil
-			}
-			fmt.Println("ERROR: In archiving process", err)
-			return err
-		}
+func (gm *gzipMembers) Write(p []byte) (int, error) {
+	return gm.gzw.Write(p)
+}
 
-		if !fi.Mode().IsRegular() {
-			return nil
-		}
+// setLevel ends the current member and starts a new one when the level changes.
+func (gm *gzipMembers) setLevel(level int) error {
+	if level == gm.level {
+		return nil
+	}
+	if err := gm.gzw.Close(); err != nil {
+		return err
+	}
+	gzw, err := gzip.NewWriterLevel(gm.out, level)
+	if err != nil {
+		return err
+	}
+	gm.gzw, gm.level = gzw, level
+	return nil
+}
 
-		regexFileName, err := regexp.Compile(filepattern)
-		if err != nil {
-			fmt.Println("ERROR: processing ", filepattern, " error ", err)
-		}
+func (gm *gzipMembers) Close() error {
+	return gm.gzw.Close()
+}
 
-		matched := regexFileName.MatchString(fi.Name())
-		if err != nil {
-			fmt.Println("ERROR: regex matching filename", err)
-		}
-		if !matched {
-			return nil
-		}
+// TarStream writes entries into a gzip compressed tar stream one at a time, for files
+// that are read from a stream instead of from disk.
+type TarStream struct {
+	gzw *gzipMembers
+	tw  *tar.Writer
+}
 
-		header, err := tar.FileInfoHeader(fi, fi.Name())
-		if err != nil {
-			fmt.Println("ERROR: In archiving process tar.FileInfoHeader: ", err)
+// NewTarStream starts a tar stream written to writers. Close finishes it.
+func NewTarStream(writers ...io.Writer) *TarStream {
+	gzw := newGzipMembers(io.MultiWriter(writers...))
+	return &TarStream{gzw: gzw, tw: tar.NewWriter(gzw)}
+}
+
+// Add writes an entry with hdr.Size bytes read from r. Stored entries are already
+// compressed and are written without being compressed a second time.
+func (ts *TarStream) Add(hdr *tar.Header, r io.Reader, stored bool) error {
+	level := gzip.DefaultCompression
+	if stored {
+		level = gzip.NoCompression
+	}
+	if level != ts.gzw.level {
+		// finish the previous entry before its member is closed
+		if err := ts.tw.Flush(); err != nil {
 			return err
 		}
-
-		header.Name = strings.TrimPrefix(
-			strings.Replace(file, src, "", -1),
-			string(filepath.Separator),
-		)
-
-		if err := tw.WriteHeader(header); err != nil {
-			fmt.Println("ERROR: In archiving process tw.WriteHeader: ", err)
+		if err := ts.gzw.setLevel(level); err != nil {
 			return err
 		}
+	}
+	if err := ts.tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("writing header for %s: %w", hdr.Name, err)
+	}
+	if _, err := io.CopyN(ts.tw, r, hdr.Size); err != nil {
+		return fmt.Errorf("writing %s: %w", hdr.Name, err)
+	}
+	return nil
+}
 
-		f, err := os.Open(file)
+// Close ends the tar stream and its last gzip member.
+func (ts *TarStream) Close() error {
+	if err := ts.tw.Close(); err != nil {
+		return err
+	}
+	return ts.gzw.Close()
+}
+
+// Verify reads the gzip compressed tar archive at path to its end, checking every entry
+// and the gzip checksums, and returns the number of entries.
+func Verify(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, fmt.Errorf("Verify %s: %w", path, err)
+	}
+	tr := tar.NewReader(gzr)
+	entries := 0
+	for {
+		_, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			if errors.Is(err, fs.ErrNotExist) {
-				fmt.Println("WARNING: In archiving process file disappeared before copy:", file)
-				return nil
-			}
-			fmt.Println("ERROR: In archiving process os.Open: ", err)
-			return err
+			return entries, fmt.Errorf("Verify %s: %w", path, err)
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return entries, fmt.Errorf("Verify %s: %w", path, err)
 		}
+		entries++
+	}
+	// the end of the last gzip member holds its checksum
+	if _, err := io.Copy(io.Discard, gzr); err != nil {
+		return entries, fmt.Errorf("Verify %s: %w", path, err)
+	}
+	return entries, nil
+}
 
-		if _, err := io.Copy(tw, f); err != nil {
-			fmt.Println("WARNING: In archiving process of file", fi.Name(), " io.Copy: ", err)
-			return nil
+// TarFiles writes the given files into a gzip compressed tar stream in order.
+func TarFiles(entries []FileEntry, writers ...io.Writer) error {
+	ts := NewTarStream(writers...)
+	for _, entry := range entries {
+		if err := tarFile(ts, entry); err != nil {
+			ts.Close()
+			return err
 		}
+	}
+	// closing writes the tar trailer and the checksum of the last gzip member
+	if err := ts.Close(); err != nil {
+		return fmt.Errorf("TarFiles: %w", err)
+	}
+	return nil
+}
 
-		f.Close()
+func tarFile(ts *TarStream, entry FileEntry) error {
+	f, err := os.Open(entry.Path)
+	if err != nil {
+		return fmt.Errorf("TarFiles: %w", err)
+	}
+	defer f.Close()
 
-		return nil
-	})
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("TarFiles: %w", err)
+	}
+
+	header, err := tar.FileInfoHeader(fi, fi.Name())
+	if err != nil {
+		return fmt.Errorf("TarFiles: %w", err)
+	}
+	header.Name = entry.Name
+
+	if err := ts.Add(header, f, entry.Stored); err != nil {
+		return fmt.Errorf("TarFiles: %w", err)
+	}
+	return nil
 }
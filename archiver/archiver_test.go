@@ -15,15 +15,167 @@
 package archiver
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
-// Test TarWithPatternMatch - for handling non-existent directory source
-func TestTarWithPatternMatchWithNonExistentSource(t *testing.T) {
-	got := "/dummy/src/folder"
+// Test TarBuffers - entries can be read back with their content
+func TestTarBuffersRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	err := TarBuffers([]BufferEntry{
+		{Name: "a.log", Data: []byte("line one\n")},
+		{Name: "README.txt", Data: []byte("partial")},
+	}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gzr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	got := map[string]string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[hdr.Name] = string(data)
+	}
+	if got["a.log"] != "line one\n" || got["README.txt"] != "partial" {
+		t.Fatalf("unexpected archive contents: %v", got)
+	}
+}
+
+func TestTarFilesWithStoredEntry(t *testing.T) {
+	dir := t.TempDir()
+	var gz bytes.Buffer
+	gzw := gzip.NewWriter(&gz)
+	gzw.Write([]byte("rotated\n"))
+	gzw.Close()
+
+	files := map[string][]byte{
+		"mongod.log":      []byte("current\n"),
+		"mongod.log.1.gz": gz.Bytes(),
+		"mongod.log.2":    []byte("older\n"),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := TarFiles([]FileEntry{
+		{Name: "mongod.log.2", Path: filepath.Join(dir, "mongod.log.2")},
+		{Name: "archive/mongod.log.1.gz", Path: filepath.Join(dir, "mongod.log.1.gz"), Stored: true},
+		{Name: "mongod.log", Path: filepath.Join(dir, "mongod.log")},
+	}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gzr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	got := map[string][]byte{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[hdr.Name], _ = io.ReadAll(tr)
+	}
+	if string(got["mongod.log"]) != "current\n" || string(got["mongod.log.2"]) != "older\n" ||
+		!bytes.Equal(got["archive/mongod.log.1.gz"], gz.Bytes()) {
+		t.Fatalf("unexpected archive contents: %q", got)
+	}
+}
+
+// shortWriter fails every write once n bytes were written, like a full disk.
+type shortWriter struct{ n int }
+
+func (w *shortWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		return 0, io.ErrShortWrite
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+// Test TarFiles - the compressed data written when the stream is closed is not lost
+func TestTarFilesReportsCloseError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mongod.log")
+	if err := os.WriteFile(path, []byte("current\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// room for the gzip header only, the small entry stays buffered until Close
+	err := TarFiles([]FileEntry{{Name: "mongod.log", Path: path}}, &shortWriter{n: 10})
+	if err == nil {
+		t.Fatal("a failed write of the end of the stream should be an error")
+	}
+}
 
-	err := TarWithPatternMatch(got, "metrics.*")
+// Test TarBuffers - the compressed data written when the stream is closed is not lost
+func TestTarBuffersReportsCloseError(t *testing.T) {
+	err := TarBuffers([]BufferEntry{{Name: "getLog.json", Data: []byte("{}")}}, &shortWriter{n: 10})
 	if err == nil {
-		t.Fatalf("Should error out on non-existent source folder")
+		t.Fatal("a failed write of the end of the stream should be an error")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.log"), []byte("line one\nline two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err := TarFiles([]FileEntry{
+		{Name: "a.log", Path: filepath.Join(dir, "a.log")},
+		{Name: "b.log", Path: filepath.Join(dir, "a.log")},
+	}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	good := filepath.Join(dir, "good.tar.gz")
+	if err := os.WriteFile(good, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := Verify(good); err != nil || n != 2 {
+		t.Fatalf("Verify = %d, %v; want 2 entries", n, err)
+	}
+
+	truncated := filepath.Join(dir, "truncated.tar.gz")
+	if err := os.WriteFile(truncated, buf.Bytes()[:buf.Len()-10], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(truncated); err == nil {
+		t.Error("a truncated archive should not verify")
+	}
+	empty := filepath.Join(dir, "empty.tar.gz")
+	if err := os.WriteFile(empty, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(empty); err == nil {
+		t.Error("an empty file should not verify")
 	}
 }
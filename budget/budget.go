@@ -0,0 +1,216 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package budget caps how much log and FTDC data is archived. Files are taken newest
+// first until a budget is used up; the files left out are recorded.
+package budget
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RecordFileName is written to a node output dir when files were left out.
+const RecordFileName = "size_budget.json"
+
+// Budget is a number of bytes shared by everything charged to it. A zero Limit is
+// unlimited.
+type Budget struct {
+	Name  string
+	Limit int64
+	Used  int64
+}
+
+func (b *Budget) fits(size int64) bool {
+	return b == nil || b.Limit <= 0 || b.Used+size <= b.Limit
+}
+
+// File is a candidate file. Time orders files; the newest are kept first.
+type File struct {
+	Name string
+	Size int64
+	Time time.Time
+}
+
+// Dropped is a file left out because a budget was used up.
+type Dropped struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Time   string `json:"time,omitempty"`
+	Budget string `json:"budget"`
+}
+
+// Record collects the files left out on a node.
+type Record struct {
+	Dropped []Dropped `json:"dropped"`
+}
+
+// Set is the budgets one kind of file of one node is charged to, usually the node budget
+// and the bundle budget. A nil Set takes every file.
+type Set struct {
+	Kind    string // "logs", "audit" or "ftdc"
+	Budgets []*Budget
+	Record  *Record
+	full    *Budget // first budget that refused a file
+}
+
+// Take charges size to every budget and reports true when it fits in all of them. Once a
+// file was refused, later files are refused too, so the files kept are always the newest.
+func (s *Set) Take(size int64) bool {
+	if s == nil {
+		return true
+	}
+	if s.full != nil {
+		return false
+	}
+	for _, b := range s.Budgets {
+		if !b.fits(size) {
+			s.full = b
+			return false
+		}
+	}
+	for _, b := range s.Budgets {
+		if b != nil {
+			b.Used += size
+		}
+	}
+	return true
+}
+
+// Full reports whether a file was refused, so every older file will be refused too.
+func (s *Set) Full() bool {
+	return s != nil && s.full != nil
+}
+
+// Drop records f as left out because of the budget that refused it.
+func (s *Set) Drop(f File) {
+	if s == nil || s.Record == nil {
+		return
+	}
+	d := Dropped{Kind: s.Kind, Name: f.Name, Size: f.Size}
+	if !f.Time.IsZero() {
+		d.Time = f.Time.UTC().Format(time.RFC3339)
+	}
+	if s.full != nil {
+		d.Budget = s.full.Name
+	}
+	s.Record.Dropped = append(s.Record.Dropped, d)
+}
+
+// Reason describes why files are being dropped, for manifests and logs.
+func (s *Set) Reason() string {
+	if s == nil || s.full == nil {
+		return ""
+	}
+	return fmt.Sprintf("over %s size budget (%s)", s.full.Name, FormatSize(s.full.Limit))
+}
+
+// Select keeps the newest files that fit, records the others and returns the kept files
+// in their original order.
+func (s *Set) Select(files []File) []File {
+	order := make([]int, len(files))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return files[order[a]].Time.After(files[order[b]].Time)
+	})
+
+	keep := make([]bool, len(files))
+	for _, i := range order {
+		if s.Take(files[i].Size) {
+			keep[i] = true
+		} else {
+			s.Drop(files[i])
+		}
+	}
+
+	kept := make([]File, 0, len(files))
+	for i, f := range files {
+		if keep[i] {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// Write stores the record in dir when files were dropped, then clears it for the next
+// node.
+func (r *Record) Write(dir string) error {
+	if r == nil || len(r.Dropped) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(filepath.Join(dir, RecordFileName), append(data, '\n'), 0644)
+	r.Dropped = nil
+	return err
+}
+
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+	{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+	{"T", 1e12}, {"G", 1e9}, {"M", 1e6}, {"K", 1e3}, {"B", 1},
+}
+
+// ParseSize parses a size such as "500MB", "1.5GB" or "2GiB". KB, MB, GB and TB are
+// powers of 1000, KiB, MiB, GiB and TiB powers of 1024; a plain number is bytes. An empty
+// value is zero, which means unlimited.
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	upper := strings.ToUpper(s)
+	multiplier := int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(upper, u.suffix) {
+			multiplier = u.bytes
+			upper = strings.TrimSpace(strings.TrimSuffix(upper, u.suffix))
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(upper, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: use a number with an optional unit such as 500MB or 2GiB", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// FormatSize prints a byte count with a decimal unit.
+func FormatSize(n int64) string {
+	switch {
+	case n >= 1e12:
+		return fmt.Sprintf("%.1fTB", float64(n)/1e12)
+	case n >= 1e9:
+		return fmt.Sprintf("%.1fGB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1fMB", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1fKB", float64(n)/1e3)
+	}
+	return fmt.Sprintf("%dB", n)
+}
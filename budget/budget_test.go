@@ -0,0 +1,91 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package budget
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	for in, want := range map[string]int64{
+		"":       0,
+		"1024":   1024,
+		"500MB":  500_000_000,
+		"1.5gb":  1_500_000_000,
+		"2GiB":   2 << 30,
+		"10 KiB": 10 << 10,
+	} {
+		got, err := ParseSize(in)
+		if err != nil || got != want {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"lots", "-1MB", "5PB"} {
+		if _, err := ParseSize(in); err == nil {
+			t.Errorf("ParseSize(%q) should fail", in)
+		}
+	}
+}
+
+func TestSelectKeepsNewestAndSharesBundleBudget(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	bundle := &Budget{Name: "ftdc_per_bundle", Limit: 200}
+	record := &Record{}
+
+	first := &Set{Kind: "ftdc", Budgets: []*Budget{{Name: "ftdc_per_node", Limit: 150}, bundle}, Record: record}
+	kept := first.Select([]File{
+		{Name: "metrics.1", Size: 100, Time: day(1)},
+		{Name: "metrics.2", Size: 100, Time: day(2)},
+		{Name: "metrics.interim", Size: 50, Time: day(3)},
+	})
+	if len(kept) != 2 || kept[0].Name != "metrics.2" || kept[1].Name != "metrics.interim" {
+		t.Fatalf("unexpected files kept on first node: %+v", kept)
+	}
+
+	// the second node only gets what is left of the bundle budget
+	second := &Set{Kind: "ftdc", Budgets: []*Budget{{Name: "ftdc_per_node", Limit: 150}, bundle}, Record: record}
+	kept = second.Select([]File{{Name: "metrics.3", Size: 100, Time: day(3)}})
+	if len(kept) != 0 || second.Reason() != "over ftdc_per_bundle size budget (200B)" {
+		t.Fatalf("expected the bundle budget to be used up, kept %+v (%s)", kept, second.Reason())
+	}
+
+	dir := t.TempDir()
+	if err := record.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, RecordFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Record
+	if err := json.Unmarshal(raw, &decoded); err != nil || len(decoded.Dropped) != 2 {
+		t.Fatalf("unexpected record: %s (%v)", raw, err)
+	}
+	if decoded.Dropped[0].Name != "metrics.1" || decoded.Dropped[0].Budget != "ftdc_per_node" ||
+		decoded.Dropped[1].Budget != "ftdc_per_bundle" {
+		t.Fatalf("unexpected dropped files: %+v", decoded.Dropped)
+	}
+}
+
+func TestNilSetTakesEverything(t *testing.T) {
+	var s *Set
+	if !s.Take(1<<40) || s.Full() || len(s.Select([]File{{Name: "a"}, {Name: "b"}})) != 2 {
+		t.Fatal("a nil set should take every file")
+	}
+}
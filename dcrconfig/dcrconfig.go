@@ -18,6 +18,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+
+	"dcrcli/redactor"
 )
 
 // Config holds all connection and collection settings that dcrcli needs.
@@ -45,10 +47,267 @@ type Config struct {
 	// Leave empty if all cluster nodes are on the same machine as dcrcli.
 	SSHUsername string `json:"ssh_username"`
 
+	// SSH selects how remote nodes are reached. By default the ssh and rsync binaries are used.
+	SSH SSHConfig `json:"ssh,omitempty"`
+
+	// SSHHosts overrides the SSH user, port and key of the nodes matching each entry. For each
+	// setting the first matching entry wins; ssh_username and ssh are used otherwise.
+	SSHHosts []SSHHostConfig `json:"ssh_hosts,omitempty"`
+
+	// Bastion is the jump host every remote copy goes through. Leave empty to connect directly.
+	Bastion BastionConfig `json:"bastion,omitempty"`
+
+	// Transfer limits the bandwidth and I/O priority of remote copies and resumes them when
+	// interrupted.
+	Transfer TransferConfig `json:"transfer,omitempty"`
+
+	// Sudo reads the logs and FTDC files of remote nodes through sudo, for files only the
+	// mongod user can read. Leave empty to read them as the SSH user.
+	Sudo SudoConfig `json:"sudo,omitempty"`
+
+	// Kubernetes reads the logs and FTDC files of remote nodes with kubectl exec instead of
+	// SSH, for clusters run by the MongoDB Kubernetes operator.
+	Kubernetes KubernetesConfig `json:"kubernetes,omitempty"`
+
+	// Containers reads the logs and FTDC files of nodes running in containers on this host
+	// through the container runtime, since their paths are inside the containers.
+	Containers ContainersConfig `json:"containers,omitempty"`
+
 	// CollectNodes controls which nodes to collect diagnostic data from.
 	// Valid values: "one-secondary" (default), "all-secondaries", "all-nodes".
 	// Leave empty to be prompted interactively when running in a terminal.
 	CollectNodes string `json:"collect_nodes"`
+
+	// FTDCSamplerDuration enables a serverStatus/replSetGetStatus sampler for nodes where FTDC
+	// is disabled or its diagnostic.data directory cannot be read, e.g. "5m".
+	// Leave empty to skip sampling on those nodes.
+	FTDCSamplerDuration string `json:"ftdc_sampler_duration"`
+
+	// FTDCSamplerInterval is the time between sampler polls, e.g. "10s". Defaults to "10s".
+	FTDCSamplerInterval string `json:"ftdc_sampler_interval"`
+
+	// SyslogIdentifier is the syslog identifier of mongod for nodes with systemLog.destination: syslog.
+	// Leave empty to use the binary name reported by getCmdLineOpts (usually "mongod").
+	SyslogIdentifier string `json:"syslog_identifier,omitempty"`
+
+	// SyslogFiles are searched when journald has no entries for the node.
+	// Defaults to /var/log/syslog and /var/log/messages.
+	SyslogFiles []string `json:"syslog_files,omitempty"`
+
+	// Since and Until limit collected mongod logs to a time window. Each accepts an RFC3339
+	// time, a date such as "2024-03-09", or a duration before now such as "36h".
+	// Leave both empty to collect every log file.
+	Since string `json:"since"`
+	Until string `json:"until"`
+
+	// Redaction removes personal data from logs and getMongoData before archiving.
+	Redaction RedactionConfig `json:"redaction"`
+
+	// Anonymize replaces hostnames, IP addresses, replica set, database and collection names
+	// with tokens in the outputs. The mapping is written to a local file outside ./outputs.
+	Anonymize bool `json:"anonymize"`
+
+	// RotatedLogPatterns are file name globs matching rotated mongod logs, e.g.
+	// "mongod-*.log.gz". "{logname}" stands for the current log file name.
+	// Defaults to "{logname}*".
+	RotatedLogPatterns []string `json:"rotated_log_patterns,omitempty"`
+
+	// RotatedLogDirs are searched for rotated logs in addition to the directory of the
+	// current log. Relative dirs are relative to it, e.g. "archive".
+	RotatedLogDirs []string `json:"rotated_log_dirs,omitempty"`
+
+	// SkipLogAnalysis turns off the per-node slow query and error report.
+	SkipLogAnalysis bool `json:"skip_log_analysis,omitempty"`
+
+	// KeepTemp keeps the copies of each node's files in ./outputs/temp after its archives
+	// were written and verified.
+	KeepTemp bool `json:"keep_temp,omitempty"`
+
+	// CollectAuditLog also collects the audit log of Enterprise nodes writing it to a file.
+	CollectAuditLog bool `json:"collect_audit_log,omitempty"`
+
+	// SizeBudget caps the size of archived logs and FTDC files. The newest files are kept.
+	SizeBudget SizeBudgetConfig `json:"size_budget,omitempty"`
+}
+
+// SizeBudgetConfig holds sizes such as "500MB" or "2GiB". Empty sizes are unlimited.
+// Audit logs count towards the log budgets.
+type SizeBudgetConfig struct {
+	LogsPerNode   string `json:"logs_per_node,omitempty"`
+	FTDCPerNode   string `json:"ftdc_per_node,omitempty"`
+	LogsPerBundle string `json:"logs_per_bundle,omitempty"`
+	FTDCPerBundle string `json:"ftdc_per_bundle,omitempty"`
+}
+
+// SSHConfig selects and configures the transport used to reach remote nodes.
+type SSHConfig struct {
+	// Transport is "openssh" (default) to run the ssh and rsync binaries, or "native" for the
+	// built-in SSH client copying files over SFTP without prompting on the terminal.
+	Transport string `json:"transport,omitempty"`
+
+	// KnownHostsFile is checked for host keys by the native transport. Defaults to ~/.ssh/known_hosts.
+	KnownHostsFile string `json:"known_hosts_file,omitempty"`
+
+	// HostKeyPolicy is "strict" (default, only hosts in KnownHostsFile), "accept-new" (add
+	// unknown hosts, reject changed keys) or "off" (no verification).
+	HostKeyPolicy string `json:"host_key_policy,omitempty"`
+
+	// IdentityFile is the private key used next to the keys of ssh-agent. Defaults to
+	// ~/.ssh/id_ed25519, id_ecdsa and id_rsa.
+	IdentityFile string `json:"identity_file,omitempty"`
+
+	// Port is the SSH port of the nodes. Defaults to 22.
+	Port int `json:"port,omitempty"`
+}
+
+// SSHHostConfig are the SSH settings of the nodes whose hostname matches Match.
+type SSHHostConfig struct {
+	// Match is a hostname, IP address or glob such as "db*.example.net", matched case-insensitively.
+	Match string `json:"match"`
+
+	// User is the OS user on the matching nodes. Defaults to ssh_username.
+	User string `json:"user,omitempty"`
+
+	// Port is the SSH port of the matching nodes. Defaults to ssh.port with the native
+	// transport and to ~/.ssh/config with the ssh binary.
+	Port int `json:"port,omitempty"`
+
+	// IdentityFile is the private key for the matching nodes, tried before the default keys.
+	IdentityFile string `json:"identity_file,omitempty"`
+}
+
+// BastionConfig is the jump host remote nodes are only reachable through.
+type BastionConfig struct {
+	// Host is the hostname or IP address of the bastion.
+	Host string `json:"host,omitempty"`
+
+	// Port is the SSH port of the bastion. Defaults to 22.
+	Port int `json:"port,omitempty"`
+
+	// User is the OS user on the bastion. Defaults to ssh_username.
+	User string `json:"user,omitempty"`
+
+	// IdentityFile is the private key for the bastion. Defaults to the keys used for the nodes.
+	IdentityFile string `json:"identity_file,omitempty"`
+}
+
+// TransferConfig limits remote copies.
+type TransferConfig struct {
+	// BandwidthLimit caps remote copies at this many bytes per second, e.g. "20MB".
+	// Empty is unlimited.
+	BandwidthLimit string `json:"bandwidth_limit,omitempty"`
+
+	// LowPriority runs rsync on the nodes with nice and ionice, and makes the native
+	// transport read one block at a time.
+	LowPriority bool `json:"low_priority,omitempty"`
+
+	// Retries is how often an interrupted copy is resumed. Defaults to 3; -1 disables resuming.
+	Retries int `json:"retries,omitempty"`
+
+	// Stream tars the logs and FTDC files of remote nodes on the node and writes them
+	// straight into the archives, instead of copying them to ./outputs/temp first.
+	Stream bool `json:"stream,omitempty"`
+}
+
+// SudoConfig reads the files on remote nodes as another user.
+type SudoConfig struct {
+	// Enabled runs the commands and copies reading remote files with sudo -n, which must
+	// not ask for a password.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// User is the user the files are read as, e.g. "mongod". Empty is root.
+	User string `json:"user,omitempty"`
+
+	// SFTPServer is the sftp-server binary the native transport runs through sudo.
+	// Empty tries the usual locations.
+	SFTPServer string `json:"sftp_server,omitempty"`
+}
+
+// KubernetesConfig maps the nodes to pods and sets how kubectl reaches them.
+type KubernetesConfig struct {
+	// Enabled reads the files of every remote node from its pod with kubectl, which must be
+	// in the PATH.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Kubeconfig is the kubeconfig file kubectl uses. Empty uses kubectl's default.
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+
+	// Context is the kubeconfig context to use. Empty uses the current context.
+	Context string `json:"context,omitempty"`
+
+	// Namespace is the namespace of pods whose hostname does not name one, such as
+	// "<pod>.<service>.<namespace>.svc.cluster.local". Empty uses the context's namespace.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Container is the container running mongod in the pods, e.g. "mongodb-enterprise-database".
+	// Empty uses the pod's default container.
+	Container string `json:"container,omitempty"`
+
+	// Pods maps nodes whose pod or namespace cannot be told from the hostname. The first
+	// entry matching a hostname is used.
+	Pods []KubernetesPodConfig `json:"pods,omitempty"`
+}
+
+// KubernetesPodConfig maps the nodes matching a hostname glob to a pod.
+type KubernetesPodConfig struct {
+	// Match is a hostname or glob such as "mongo-*.example.net".
+	Match string `json:"match"`
+
+	// Pod is the pod name. Empty is the first label of the hostname.
+	Pod string `json:"pod,omitempty"`
+
+	// Namespace is the pod's namespace. Empty uses the hostname or namespace above.
+	Namespace string `json:"namespace,omitempty"`
+
+	// Container overrides the container above for the matching nodes.
+	Container string `json:"container,omitempty"`
+}
+
+// ContainersConfig maps the nodes on this host to the containers they run in.
+type ContainersConfig struct {
+	// Enabled looks up the container of every node on this host: the first matching
+	// mapping, otherwise the one container publishing the node's port.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Runtime is the container runtime command, e.g. "docker" or "podman". Defaults to docker.
+	Runtime string `json:"runtime,omitempty"`
+
+	// Mappings name the container of nodes whose port is not published, e.g. with host
+	// networking, or published by several containers.
+	Mappings []ContainerMappingConfig `json:"mappings,omitempty"`
+}
+
+// ContainerMappingConfig maps the nodes matching a hostname glob and port to a container.
+type ContainerMappingConfig struct {
+	// Match is a hostname or glob such as "mongo*". Empty matches every hostname.
+	Match string `json:"match,omitempty"`
+
+	// Port is the mongod port. 0 matches every port.
+	Port int `json:"port,omitempty"`
+
+	// Container is the container name or ID.
+	Container string `json:"container"`
+}
+
+// RedactionConfig enables redaction and optionally replaces the default rules.
+// Empty rules use redactor.DefaultConfig; text_patterns left out use the default patterns,
+// an empty list disables them.
+type RedactionConfig struct {
+	Enabled bool `json:"enabled"`
+	redactor.Config
+}
+
+// Rules returns the redaction rules to use, filling in the defaults.
+func (rc RedactionConfig) Rules() redactor.Config {
+	cfg := rc.Config
+	defaults := redactor.DefaultConfig()
+	if len(cfg.Rules) == 0 {
+		cfg.Rules = defaults.Rules
+	}
+	if cfg.TextPatterns == nil {
+		cfg.TextPatterns = defaults.TextPatterns
+	}
+	return cfg
 }
 
 // Load reads and parses a JSON config file at the given path.
@@ -69,13 +328,15 @@ func Load(path string) (*Config, error) {
 // GenerateSample writes a sample config file with placeholder values to path.
 func GenerateSample(path string) error {
 	sample := Config{
-		ClusterName:  "my-cluster",
-		SeedHost:     "localhost",
-		SeedPort:     "27017",
-		Username:     "",
-		URIOptions:   "",
-		SSHUsername:  "",
-		CollectNodes: "one-secondary",
+		ClusterName:         "my-cluster",
+		SeedHost:            "localhost",
+		SeedPort:            "27017",
+		Username:            "",
+		URIOptions:          "",
+		SSHUsername:         "",
+		CollectNodes:        "one-secondary",
+		FTDCSamplerDuration: "",
+		FTDCSamplerInterval: "10s",
 	}
 	data, err := json.MarshalIndent(sample, "", "  ")
 	if err != nil {
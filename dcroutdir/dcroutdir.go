@@ -15,7 +15,9 @@
 package dcroutdir
 
 import (
+	"io/fs"
 	"os"
+	"path/filepath"
 )
 
 type DCROutputDir struct {
@@ -35,3 +37,28 @@ func (od *DCROutputDir) CreateDCROutputDir() error {
 func (od *DCROutputDir) Path() string {
 	return od.OutputPrefix + od.Hostname + "_" + od.Port
 }
+
+// Usage returns the bytes taken by the regular files in the directory.
+func (od *DCROutputDir) Usage() (int64, error) {
+	var total int64
+	err := filepath.WalkDir(od.Path(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		total += fi.Size()
+		return nil
+	})
+	return total, err
+}
+
+// Remove removes the directory and everything in it.
+func (od *DCROutputDir) Remove() error {
+	return os.RemoveAll(od.Path())
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"dcrcli/dcrconfig"
+)
+
+// Bastion is the jump host remote nodes are reached through. The ssh binary connects to
+// the node through a ProxyCommand running ssh -W on the bastion; the native transport
+// tunnels its connection through a connection to the bastion.
+type Bastion struct {
+	Host         string
+	Port         int
+	User         string // empty uses the SSH user of the node
+	IdentityFile string // empty uses the keys of the nodes
+}
+
+// NewBastion returns the bastion configured by c, or nil when no bastion host is set.
+func NewBastion(c dcrconfig.BastionConfig) (*Bastion, error) {
+	b := &Bastion{
+		Host:         strings.TrimSpace(c.Host),
+		Port:         c.Port,
+		User:         strings.TrimSpace(c.User),
+		IdentityFile: strings.TrimSpace(c.IdentityFile),
+	}
+	if b.Host == "" {
+		return nil, nil
+	}
+	if err := checkSSHName("bastion hostname", b.Host, isHostnameChar); err != nil {
+		return nil, err
+	}
+	if b.User != "" {
+		if err := checkSSHName("bastion user", b.User, isUsernameChar); err != nil {
+			return nil, err
+		}
+	}
+	if b.Port == 0 {
+		b.Port = defaultSSHPort
+	}
+	if b.Port < 0 || b.Port > 65535 {
+		return nil, fmt.Errorf("invalid bastion port %d", b.Port)
+	}
+	var err error
+	if b.IdentityFile, err = expandHome(b.IdentityFile); err != nil {
+		return nil, fmt.Errorf("cannot locate bastion identity file: %w", err)
+	}
+	// the path ends up in the rsync -e command, where a double quote ends the argument
+	if strings.ContainsAny(b.IdentityFile, "\"\n") {
+		return nil, fmt.Errorf("invalid bastion identity_file %q", b.IdentityFile)
+	}
+	return b, nil
+}
+
+func (b *Bastion) String() string {
+	return net.JoinHostPort(b.Host, strconv.Itoa(b.Port))
+}
+
+// user returns the user on the bastion when the node is reached as nodeUser.
+func (b *Bastion) user(nodeUser string) string {
+	if b.User != "" {
+		return b.User
+	}
+	return nodeUser
+}
+
+// proxyCommand returns the ssh ProxyCommand reaching the node through the bastion. ssh
+// runs it with a shell after expanding its % tokens, so every argument is quoted and
+// every "%" but those of %h:%p is escaped.
+func (b *Bastion) proxyCommand(nodeUser string) string {
+	argv := []string{"ssh", "-W", "%h:%p", "-p", strconv.Itoa(b.Port), "-l", b.user(nodeUser)}
+	if b.IdentityFile != "" {
+		argv = append(argv, "-i", b.IdentityFile)
+	}
+	argv = append(argv, "--", b.Host)
+	for i, arg := range argv {
+		if arg != "%h:%p" {
+			argv[i] = strings.ReplaceAll(arg, "%", "%%")
+		}
+	}
+	return ShellQuoteArgs(argv)
+}
+
+// sshOptions returns the options making ssh connect to the node through the bastion, or
+// none without a bastion.
+func (b *Bastion) sshOptions(nodeUser string) []string {
+	if b == nil {
+		return nil
+	}
+	return []string{"-o", "ProxyCommand=" + b.proxyCommand(nodeUser)}
+}
@@ -0,0 +1,131 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dcrcli/dcrconfig"
+)
+
+func TestNewBastion(t *testing.T) {
+	b, err := NewBastion(dcrconfig.BastionConfig{})
+	if b != nil || err != nil {
+		t.Fatalf("no host should mean no bastion, got %v %v", b, err)
+	}
+
+	for _, c := range []dcrconfig.BastionConfig{
+		{Host: "-oProxyCommand=touch x"},
+		{Host: "jump;touch x"},
+		{Host: "jump", User: "-l"},
+		{Host: "jump", Port: 70000},
+		{Host: "jump", IdentityFile: `/keys/"id"`},
+	} {
+		if _, err := NewBastion(c); err == nil {
+			t.Errorf("bastion %+v should be rejected", c)
+		}
+	}
+
+	b, err = NewBastion(dcrconfig.BastionConfig{Host: "jump.example.net", IdentityFile: "/keys/it's 100%"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `'ssh' '-W' '%h:%p' '-p' '22' '-l' 'ubuntu' '-i' '/keys/it'\''s 100%%' '--' 'jump.example.net'`
+	if got := b.proxyCommand("ubuntu"); got != want {
+		t.Fatalf("unexpected ProxyCommand\n got %s\nwant %s", got, want)
+	}
+	b.User = "ops"
+	if got := b.proxyCommand("ubuntu"); !strings.Contains(got, "'-l' 'ops'") {
+		t.Fatalf("bastion user not used: %s", got)
+	}
+}
+
+func TestRemotePatternCopyThroughBastion(t *testing.T) {
+	argsFile := fakeRsync(t)
+	bastion, err := NewBastion(dcrconfig.BastionConfig{Host: "jump.example.net", Port: 2222, User: "ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	job := FSCopyJobWithPattern{
+		CopyJobDetails: &FSCopyJob{
+			Src:     SourceDir{Path: []byte("/var/log/mongodb"), Hostname: []byte("db1.internal"), Username: []byte("ubuntu")},
+			Dst:     DestDir{Path: []byte(dst)},
+			Bastion: bastion,
+			Dcrlog:  testLogger(t),
+		},
+		CurrentFileName: "mongod.log",
+		Dcrlog:          testLogger(t),
+	}
+	if err := job.StartCopyRemoteWithPattern(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"-az", "--protect-args",
+		"-e", `ssh -o "ProxyCommand='ssh' '-W' '%h:%p' '-p' '2222' '-l' 'ops' '--' 'jump.example.net'"`,
+		"--include=mongod.log*", "--exclude=*", "--progress", "--",
+		"ubuntu@db1.internal:/var/log/mongodb/", dst,
+	}
+	if got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+		t.Fatalf("unexpected rsync arguments:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestNativeSSHThroughBastion(t *testing.T) {
+	ns, node := newTestNativeSSH(t, HostKeyAcceptNew)
+	signer, err := loadIdentityFile(ns.IdentityFile, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jump := startTestSSHServer(t, signer.PublicKey())
+	ns.Bastion = &Bastion{Host: "127.0.0.1", Port: jump.port, User: "ops"}
+
+	remote := t.TempDir()
+	if err := os.WriteFile(filepath.Join(remote, "mongod.log"), []byte("log"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dst := t.TempDir()
+	src := SourceDir{Path: []byte(remote + "/"), Hostname: []byte("127.0.0.1"), Username: []byte("dcr")}
+	if err := ns.copyTree(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "mongod.log")); err != nil || string(data) != "log" {
+		t.Fatalf("file not copied through the bastion: %q %v", data, err)
+	}
+	if jump.forwards.Load() != 1 || node.forwards.Load() != 0 {
+		t.Fatalf("expected one connection forwarded by the bastion, got %d", jump.forwards.Load())
+	}
+
+	ns.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	ns.Bastion.Port = l.Addr().(*net.TCPAddr).Port
+	if err := ns.copyTree(src, t.TempDir()); err == nil || !strings.Contains(err.Error(), "bastion") {
+		t.Fatalf("expected an error naming the bastion, got %v", err)
+	}
+}
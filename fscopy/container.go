@@ -0,0 +1,147 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	"dcrcli/dcrconfig"
+)
+
+const defaultContainerRuntime = "docker"
+
+// Containers finds the containers that nodes on this host run in, for dev and CI clusters
+// where mongod runs in Docker or Podman: the host resolves as local, but the log and FTDC
+// paths mongod reports are inside the container.
+type Containers struct {
+	Runtime  string // docker, podman or another runtime with the same exec and ps commands
+	Mappings []ContainerMapping
+}
+
+// ContainerMapping names the container of the nodes matching Match and Port.
+type ContainerMapping struct {
+	Match     string // hostname or glob, empty matches every hostname
+	Port      int    // mongod port, 0 matches every port
+	Container string
+}
+
+// Container is the container a node runs in. Commands run in it with the runtime's exec
+// and files are copied out as tar streams, like docker cp.
+type Container struct {
+	Runtime string
+	Name    string
+}
+
+// NewContainers returns the container settings configured by c, or nil when containers
+// are not enabled.
+func NewContainers(c dcrconfig.ContainersConfig) (*Containers, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	cs := &Containers{Runtime: strings.TrimSpace(c.Runtime)}
+	if cs.Runtime == "" {
+		cs.Runtime = defaultContainerRuntime
+	}
+	if strings.HasPrefix(cs.Runtime, "-") || strings.ContainsAny(cs.Runtime, " \t\n") {
+		return nil, fmt.Errorf("invalid container runtime %q", cs.Runtime)
+	}
+	for i, entry := range c.Mappings {
+		m := ContainerMapping{
+			Match:     strings.ToLower(strings.TrimSpace(entry.Match)),
+			Port:      entry.Port,
+			Container: strings.TrimSpace(entry.Container),
+		}
+		if _, err := path.Match(m.Match, ""); err != nil {
+			return nil, fmt.Errorf("containers.mappings[%d]: invalid match %q: %w", i, entry.Match, err)
+		}
+		if m.Port < 0 || m.Port > 65535 {
+			return nil, fmt.Errorf("containers.mappings[%d]: invalid port %d", i, m.Port)
+		}
+		if err := checkContainerName(m.Container); err != nil {
+			return nil, fmt.Errorf("containers.mappings[%d]: %w", i, err)
+		}
+		cs.Mappings = append(cs.Mappings, m)
+	}
+	return cs, nil
+}
+
+// checkContainerName rejects names Docker and Podman would not accept.
+func checkContainerName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty container name")
+	}
+	for i, r := range name {
+		alnum := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9'
+		if !alnum && (i == 0 || !strings.ContainsRune("_.-", r)) {
+			return fmt.Errorf("invalid container name %q: character %q not allowed", name, r)
+		}
+	}
+	return nil
+}
+
+// Lookup returns the container of the node hostname:port: the container of the first
+// matching mapping, otherwise the one container publishing port. It returns nil when no
+// container publishes port, i.e. the node does not run in a container.
+func (cs *Containers) Lookup(hostname string, port int) (*Container, error) {
+	hostname = strings.ToLower(hostname)
+	for _, m := range cs.Mappings {
+		if m.Port != 0 && m.Port != port {
+			continue
+		}
+		if m.Match != "" {
+			if ok, _ := path.Match(m.Match, hostname); !ok {
+				continue
+			}
+		}
+		return &Container{Runtime: cs.Runtime, Name: m.Container}, nil
+	}
+
+	argv := []string{cs.Runtime, "ps", "--filter", "publish=" + strconv.Itoa(port), "--format", "{{.Names}}"}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, &CommandError{Args: argv, Stderr: strings.TrimSpace(stderr.String()), Err: err}
+	}
+	names := strings.Fields(stdout.String())
+	switch len(names) {
+	case 0:
+		return nil, nil
+	case 1:
+		if err := checkContainerName(names[0]); err != nil {
+			return nil, err
+		}
+		return &Container{Runtime: cs.Runtime, Name: names[0]}, nil
+	}
+	return nil, fmt.Errorf(
+		"port %d is published by %d containers (%s), set containers.mappings",
+		port, len(names), strings.Join(names, ", "),
+	)
+}
+
+func (c *Container) String() string {
+	return c.Runtime + " container " + c.Name
+}
+
+// execArgs returns the runtime arguments running argv in the container, without a shell.
+func (c *Container) execArgs(argv []string) []string {
+	return append([]string{"exec", c.Name}, argv...)
+}
@@ -0,0 +1,160 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dcrcli/dcrconfig"
+)
+
+// fakeDocker puts a docker on PATH that records its arguments. ps lists the containers
+// publishing 27017 (mongo1) and 27018 (mongo2 and mongo3); exec runs the command on this
+// host, standing in for the container.
+func fakeDocker(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$*\" >> '" + argsFile + "'\n" +
+		"case \"$1\" in\n" +
+		"ps) case \"$3\" in\n" +
+		"  publish=27017) echo mongo1 ;;\n" +
+		"  publish=27018) printf 'mongo2\\nmongo3\\n' ;;\n" +
+		"  esac ;;\n" +
+		"exec) shift 2; exec \"$@\" ;;\n" +
+		"esac\n"
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+func TestContainersLookup(t *testing.T) {
+	fakeDocker(t)
+	if cs, err := NewContainers(dcrconfig.ContainersConfig{Runtime: "podman"}); cs != nil || err != nil {
+		t.Fatalf("containers should be off unless enabled: %v %v", cs, err)
+	}
+	for _, c := range []dcrconfig.ContainersConfig{
+		{Enabled: true, Runtime: "-H tcp://x"},
+		{Enabled: true, Mappings: []dcrconfig.ContainerMappingConfig{{Port: 27017}}},
+		{Enabled: true, Mappings: []dcrconfig.ContainerMappingConfig{{Port: 27017, Container: "-it"}}},
+		{Enabled: true, Mappings: []dcrconfig.ContainerMappingConfig{{Port: 70000, Container: "mongo1"}}},
+		{Enabled: true, Mappings: []dcrconfig.ContainerMappingConfig{{Match: "[", Container: "mongo1"}}},
+	} {
+		if _, err := NewContainers(c); err == nil {
+			t.Errorf("%+v should be rejected", c)
+		}
+	}
+
+	cs, err := NewContainers(dcrconfig.ContainersConfig{
+		Enabled: true,
+		Mappings: []dcrconfig.ContainerMappingConfig{
+			{Port: 27019, Container: "mongo-host-net"},
+			{Match: "db.example.net", Container: "mongo-db"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		hostname string
+		port     int
+		want     string // empty is no container
+	}{
+		{"localhost", 27017, "mongo1"},
+		{"localhost", 27019, "mongo-host-net"},
+		{"DB.example.net", 27020, "mongo-db"},
+		{"localhost", 27020, ""},
+	}
+	for _, tt := range tests {
+		c, err := cs.Lookup(tt.hostname, tt.port)
+		if err != nil {
+			t.Errorf("Lookup(%s, %d): %v", tt.hostname, tt.port, err)
+			continue
+		}
+		got := ""
+		if c != nil {
+			got = c.Name
+			if c.Runtime != "docker" {
+				t.Errorf("Lookup(%s, %d) runtime = %s, want docker", tt.hostname, tt.port, c.Runtime)
+			}
+		}
+		if got != tt.want {
+			t.Errorf("Lookup(%s, %d) = %q, want %q", tt.hostname, tt.port, got, tt.want)
+		}
+	}
+	if _, err := cs.Lookup("localhost", 27018); err == nil || !strings.Contains(err.Error(), "mongo2, mongo3") {
+		t.Errorf("a port published by two containers should be an error, got %v", err)
+	}
+}
+
+func TestContainerCopy(t *testing.T) {
+	argsFile := fakeDocker(t)
+	src := t.TempDir()
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, dir := range []string{"log", "diagnostic.data"} {
+		if err := os.Mkdir(filepath.Join(src, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, filepath.Join(src, "log", "mongod.log"), "current", mtime)
+	writeTestFile(t, filepath.Join(src, "log", "syslog"), "other", mtime)
+	writeTestFile(t, filepath.Join(src, "diagnostic.data", "metrics.interim"), "interim", mtime)
+
+	logs := t.TempDir()
+	job := &FSCopyJob{
+		Src:       SourceDir{Path: []byte(filepath.Join(src, "log")), Hostname: []byte("localhost")},
+		Dst:       DestDir{Path: []byte(logs)},
+		Container: &Container{Runtime: "docker", Name: "mongo1"},
+		// a container takes precedence over sudo and the other transports
+		Sudo:   &Sudo{User: "mongod"},
+		Dcrlog: testLogger(t),
+	}
+	err := (&FSCopyJobWithPattern{CopyJobDetails: job, CurrentFileName: "mongod.log", Dcrlog: job.Dcrlog}).StartCopyWithPattern()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(logs, "mongod.log")); err != nil || string(got) != "current" {
+		t.Errorf("mongod.log = %q, %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(logs, "syslog")); err == nil {
+		t.Error("syslog does not match the pattern and should not be copied")
+	}
+
+	ftdc := t.TempDir()
+	job.Src.Path = []byte(filepath.Join(src, "diagnostic.data"))
+	job.Dst.Path = []byte(ftdc)
+	if err := job.StartCopy(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(ftdc, "diagnostic.data", "metrics.interim")); err != nil {
+		t.Errorf("diagnostic.data was not copied as a directory: %v", err)
+	}
+
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(args)), "\n") {
+		if !strings.HasPrefix(line, "exec mongo1 ") || strings.Contains(line, "sudo") {
+			t.Errorf("unexpected docker arguments %q", line)
+		}
+	}
+}
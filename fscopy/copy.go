@@ -0,0 +1,374 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"dcrcli/dcrlogger"
+)
+
+// copyAttempts is how often a file that changed while it was copied is copied again.
+const copyAttempts = 3
+
+const copyBufferSize = 1024 * 1024
+
+// ErrFileChanging is returned by copyStable when a file changed during every copy
+// attempt, such as metrics.interim which mongod rewrites in place. The file is skipped.
+var ErrFileChanging = errors.New("file kept changing while it was copied")
+
+// UnreadableError is returned when files or directories could not be read for lack of
+// permission. Everything readable was copied.
+type UnreadableError struct {
+	Host  string // empty for the local host
+	Paths []string
+}
+
+func (ue *UnreadableError) Error() string {
+	where := "on this host"
+	if ue.Host != "" {
+		where = "on " + ue.Host
+	}
+	shown := ue.Paths
+	more := ""
+	if len(shown) > 5 {
+		shown = shown[:5]
+		more = fmt.Sprintf(" and %d more", len(ue.Paths)-5)
+	}
+	return fmt.Sprintf("permission denied reading %d path(s) %s: %s%s",
+		len(ue.Paths), where, strings.Join(shown, ", "), more)
+}
+
+func (ue *UnreadableError) Unwrap() error {
+	return fs.ErrPermission
+}
+
+// sourceFS is the file system of the source node: the local one, or SFTP on a remote
+// node. Paths use forward slashes.
+type sourceFS interface {
+	Stat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.FileInfo, error)
+	Open(name string) (io.ReadCloser, error)
+}
+
+type localFS struct{}
+
+func (localFS) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(filepath.FromSlash(name))
+}
+
+func (localFS) ReadDir(name string) ([]fs.FileInfo, error) {
+	entries, err := os.ReadDir(filepath.FromSlash(name))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]fs.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (localFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.FromSlash(name))
+}
+
+// copier copies files from a source node, keeping their permissions and modification
+// times, which the archivers use to order rotated logs and FTDC files. Each file is
+// copied until its size and modification time were the same before and after the copy,
+// so the copy is consistent while mongod keeps writing. With appendOnly set, for logs,
+// a file that only grew during the copy is accepted as well.
+//
+// With resume set, files already copied with the same size and modification time are
+// skipped, and a file whose copy was interrupted continues from where it stopped when
+// the file did not change since.
+type copier struct {
+	src        sourceFS
+	host       string // empty for the local host
+	resume     bool
+	appendOnly bool         // the files are only appended to, such as logs
+	limiter    *rateLimiter // nil is unlimited
+	bufferSize int          // 0 uses copyBufferSize
+	files      int
+	bytes      int64
+	unreadable []string
+	dcrlog     *dcrlogger.DCRLogger
+}
+
+// copyTree copies root into dst like rsync -a: a root ending in "/" copies the contents
+// of the directory, otherwise the directory or file itself is placed in dst.
+func (c *copier) copyTree(root string, dst string) error {
+	fi, err := c.src.Stat(root)
+	if err != nil {
+		return fmt.Errorf("cannot stat %s: %w", root, err)
+	}
+	if !fi.IsDir() {
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			return err
+		}
+		return c.copyFile(root, filepath.Join(dst, path.Base(root)))
+	}
+
+	target := dst
+	if !strings.HasSuffix(root, "/") {
+		target = filepath.Join(dst, path.Base(root))
+	}
+	entries, err := c.src.ReadDir(root)
+	if err != nil {
+		return fmt.Errorf("cannot list %s: %w", root, err)
+	}
+	return c.copyDir(path.Clean(root), entries, target)
+}
+
+func (c *copier) copyDir(dir string, entries []fs.FileInfo, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for _, fi := range entries {
+		src := path.Join(dir, fi.Name())
+		target := filepath.Join(dst, fi.Name())
+		switch {
+		case fi.IsDir():
+			sub, err := c.src.ReadDir(src)
+			if errors.Is(err, fs.ErrPermission) {
+				c.unreadable = append(c.unreadable, src)
+				continue
+			}
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := c.copyDir(src, sub, target); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			if err := c.copyFile(src, target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// copyMatching copies the regular files of dir whose name matches one of patterns into
+// dst, like rsync with --include for each pattern and --exclude='*'. Subdirectories are
+// not copied.
+func (c *copier) copyMatching(dir string, patterns []string, dst string) error {
+	dir = strings.TrimSuffix(dir, "/")
+	entries, err := c.src.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("cannot list %s: %w", dir, err)
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for _, fi := range entries {
+		if !fi.Mode().IsRegular() || !matchesAny(fi.Name(), patterns) {
+			continue
+		}
+		if err := c.copyFile(path.Join(dir, fi.Name()), filepath.Join(dst, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesAny reports whether name matches one of the rsync-style globs in patterns.
+func matchesAny(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// copyFile copies src to target. Files removed meanwhile, e.g. by log rotation, are
+// skipped and unreadable files are recorded; both leave the other files to be copied.
+func (c *copier) copyFile(src string, target string) error {
+	if c.resume && c.alreadyCopied(src, target) {
+		return nil
+	}
+	n, err := c.copyStable(src, target)
+	switch {
+	case errors.Is(err, ErrFileChanging):
+		c.dcrlog.Warn(fmt.Sprintf("skipping %s, it changed during every copy attempt", src))
+		os.Remove(target)
+		os.Remove(partialPath(target))
+		return nil
+	case errors.Is(err, fs.ErrNotExist):
+		c.dcrlog.Warn(fmt.Sprintf("skipping %s, it was removed before it was copied", src))
+		os.Remove(target)
+		os.Remove(partialPath(target))
+		return nil
+	case errors.Is(err, fs.ErrPermission):
+		c.unreadable = append(c.unreadable, src)
+		os.Remove(target)
+		os.Remove(partialPath(target))
+		return nil
+	case err != nil:
+		return fmt.Errorf("copying %s: %w", src, err)
+	}
+	c.files++
+	c.bytes += n
+	return nil
+}
+
+// alreadyCopied reports whether target is a complete copy of src from an earlier attempt.
+func (c *copier) alreadyCopied(src string, target string) bool {
+	tfi, err := os.Stat(target)
+	if err != nil {
+		return false
+	}
+	fi, err := c.src.Stat(src)
+	return err == nil && tfi.Size() == fi.Size() && tfi.ModTime().Equal(fi.ModTime())
+}
+
+// copyStable copies src to target and checks that src had the same size and modification
+// time before and after the copy. With appendOnly set, a file that only grew meanwhile,
+// such as the current log, is accepted once the bytes it had when the copy started were
+// copied; a file rewritten in place, such as FTDC, may grow as well, so it is copied
+// again. A file that changed during every attempt returns ErrFileChanging, leaving a
+// copy in target that may be torn.
+func (c *copier) copyStable(src string, target string) (int64, error) {
+	for attempt := 0; attempt < copyAttempts; attempt++ {
+		before, err := c.src.Stat(src)
+		if err != nil {
+			return 0, err
+		}
+		copied, err := c.copyPrefix(src, target, before)
+		if err != nil {
+			return 0, err
+		}
+		after, err := c.src.Stat(src)
+		if err != nil {
+			return 0, err
+		}
+		unchanged := after.Size() == before.Size() && after.ModTime().Equal(before.ModTime())
+		grew := c.appendOnly && after.Size() > before.Size()
+		if copied == before.Size() && (unchanged || grew) {
+			return copied, os.Chtimes(target, before.ModTime(), before.ModTime())
+		}
+	}
+	return 0, ErrFileChanging
+}
+
+// partialPath returns where target is written until its copy is complete.
+func partialPath(target string) string {
+	return filepath.Join(filepath.Dir(target), PartialDir, filepath.Base(target))
+}
+
+// copyPrefix copies the first fi.Size() bytes of src to target. The bytes are written in
+// PartialDir first and moved to target once complete.
+func (c *copier) copyPrefix(src string, target string, fi fs.FileInfo) (int64, error) {
+	partial := partialPath(target)
+	offset := c.resumeOffset(partial, fi)
+
+	in, err := c.src.Open(src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		seeker, ok := in.(io.Seeker)
+		if !ok {
+			return 0, fmt.Errorf("cannot resume copying %s: not seekable", src)
+		}
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			return 0, err
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+		c.dcrlog.Debug(fmt.Sprintf("resuming copy of %s at %d of %d bytes", src, offset, fi.Size()))
+	}
+	if err := os.MkdirAll(filepath.Dir(partial), 0755); err != nil {
+		return 0, err
+	}
+	out, err := os.OpenFile(partial, flags, fi.Mode().Perm()|0200)
+	if err != nil {
+		return 0, err
+	}
+
+	bufferSize := c.bufferSize
+	if bufferSize == 0 {
+		bufferSize = copyBufferSize
+	}
+	// a large buffer lets SFTP reads run concurrently; struct{ io.Writer } keeps io.CopyBuffer
+	// from handing the copy to os.File.ReadFrom with a small buffer
+	n, err := io.CopyBuffer(
+		struct{ io.Writer }{out},
+		c.limiter.reader(io.LimitReader(in, fi.Size()-offset)),
+		make([]byte, bufferSize),
+	)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if c.resume {
+			// the modification time tells the next attempt which version of src this is a prefix of
+			os.Chtimes(partial, fi.ModTime(), fi.ModTime())
+		}
+		return 0, err
+	}
+	if err := os.Rename(partial, target); err != nil {
+		return 0, err
+	}
+	// removed once no interrupted copy is left in it
+	os.Remove(filepath.Dir(partial))
+	return offset + n, nil
+}
+
+// resumeOffset returns how many bytes of src an interrupted copy left in partial: all of
+// them when partial was written from the same version of src, otherwise none.
+func (c *copier) resumeOffset(partial string, fi fs.FileInfo) int64 {
+	if !c.resume {
+		return 0
+	}
+	pfi, err := os.Stat(partial)
+	if err != nil || !pfi.ModTime().Equal(fi.ModTime()) || pfi.Size() > fi.Size() {
+		return 0
+	}
+	return pfi.Size()
+}
+
+// result logs what was copied and reports the paths that could not be read.
+func (c *copier) result(what string) error {
+	where := "locally"
+	if c.host != "" {
+		where = "from " + c.host
+	}
+	c.dcrlog.Info(fmt.Sprintf("copied %d file(s), %d bytes of %s %s", c.files, c.bytes, what, where))
+	if len(c.unreadable) > 0 {
+		return &UnreadableError{Host: c.host, Paths: c.unreadable}
+	}
+	return nil
+}
@@ -0,0 +1,385 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dcrcli/archiver"
+)
+
+// hookFS is the local file system with errors for some files and a hook run when a file
+// is opened, standing in for files mongod writes or rotates during the copy.
+type hookFS struct {
+	localFS
+	errs   map[string]error // by base name
+	onOpen func(name string)
+}
+
+func (h hookFS) Open(name string) (io.ReadCloser, error) {
+	if err := h.errs[path.Base(name)]; err != nil {
+		return nil, err
+	}
+	if h.onOpen != nil {
+		h.onOpen(name)
+	}
+	return h.localFS.Open(name)
+}
+
+func writeTestFile(t *testing.T, name string, data string, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartCopyLocalWithPattern(t *testing.T) {
+	src := t.TempDir()
+	mtime := time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC)
+	writeTestFile(t, filepath.Join(src, "mongod.log"), "current\n", mtime)
+	writeTestFile(t, filepath.Join(src, "mongod.log.2024-03-08T00-00-00"), "rotated\n", mtime.Add(-time.Hour))
+	writeTestFile(t, filepath.Join(src, "other.log"), "other\n", mtime)
+	if err := os.Mkdir(filepath.Join(src, "mongod.log.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	job := FSCopyJobWithPattern{
+		CopyJobDetails: &FSCopyJob{
+			Src:    SourceDir{IsLocal: true, Path: []byte(src)},
+			Dst:    DestDir{Path: []byte(dst)},
+			Dcrlog: testLogger(t),
+		},
+		CurrentFileName: "mongod.log",
+		Dcrlog:          testLogger(t),
+	}
+	if err := job.StartCopyWithPattern(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 2 || names[0] != "mongod.log" || names[1] != "mongod.log.2024-03-08T00-00-00" {
+		t.Fatalf("unexpected copied files %v", names)
+	}
+	fi, err := os.Stat(filepath.Join(dst, "mongod.log.2024-03-08T00-00-00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(mtime.Add(-time.Hour)) {
+		t.Errorf("modification time not kept: %s", fi.ModTime())
+	}
+
+	// the copy is a snapshot: later writes to the live file do not reach it
+	writeTestFile(t, filepath.Join(src, "mongod.log"), "current\nmore\n", mtime)
+	data, _ := os.ReadFile(filepath.Join(dst, "mongod.log"))
+	if string(data) != "current\n" {
+		t.Errorf("copy changed with the source: %q", data)
+	}
+}
+
+func TestCopierSkipsRemovedAndReportsUnreadableFiles(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"metrics.1", "metrics.2", "metrics.3"} {
+		writeTestFile(t, filepath.Join(src, name), name, time.Now())
+	}
+
+	c := copier{
+		src: hookFS{errs: map[string]error{
+			"metrics.1": fs.ErrNotExist,
+			"metrics.2": fs.ErrPermission,
+		}},
+		dcrlog: testLogger(t),
+	}
+	dst := t.TempDir()
+	if err := c.copyTree(src+"/", dst); err != nil {
+		t.Fatal(err)
+	}
+	err := c.result(src)
+
+	var unreadable *UnreadableError
+	if !errors.As(err, &unreadable) || !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("expected an UnreadableError, got %v", err)
+	}
+	if len(unreadable.Paths) != 1 || unreadable.Paths[0] != path.Join(filepath.ToSlash(src), "metrics.2") {
+		t.Errorf("unexpected unreadable paths %v", unreadable.Paths)
+	}
+	for name, want := range map[string]bool{"metrics.1": false, "metrics.2": false, "metrics.3": true} {
+		if _, err := os.Stat(filepath.Join(dst, name)); (err == nil) != want {
+			t.Errorf("%s copied: %v, want %v", name, err == nil, want)
+		}
+	}
+}
+
+func TestCopierAcceptsAppendedFile(t *testing.T) {
+	src := t.TempDir()
+	live := filepath.Join(src, "mongod.log")
+	writeTestFile(t, live, "line 1\n", time.Now())
+
+	appends := 0
+	c := copier{
+		src: hookFS{onOpen: func(string) {
+			appends++
+			f, err := os.OpenFile(live, os.O_APPEND|os.O_WRONLY, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString("line 2\n")
+			f.Close()
+		}},
+		appendOnly: true,
+		dcrlog:     testLogger(t),
+	}
+	dst := t.TempDir()
+	if err := c.copyTree(live, dst); err != nil {
+		t.Fatal(err)
+	}
+	if appends != 1 {
+		t.Errorf("copied %d times, want the first copy accepted", appends)
+	}
+	data, _ := os.ReadFile(filepath.Join(dst, "mongod.log"))
+	if string(data) != "line 1\n" {
+		t.Errorf("copy = %q, want the bytes the file had when the copy started", data)
+	}
+}
+
+func TestCopierRecopiesFileRewrittenLarger(t *testing.T) {
+	src := t.TempDir()
+	interim := filepath.Join(src, "metrics.interim")
+	start := time.Now().Add(-time.Hour)
+	writeTestFile(t, interim, "sample 0", start)
+
+	opens := 0
+	c := copier{
+		src: hookFS{onOpen: func(string) {
+			opens++
+			if opens == 1 {
+				// rewritten in place while the first copy runs, ending larger
+				writeTestFile(t, interim, "sample 1 with more metrics", start.Add(time.Minute))
+			}
+		}},
+		dcrlog: testLogger(t),
+	}
+	dst := t.TempDir()
+	if err := c.copyTree(interim, dst); err != nil {
+		t.Fatal(err)
+	}
+	if opens != 2 {
+		t.Errorf("copied %d times, want a file that grew copied again", opens)
+	}
+	data, _ := os.ReadFile(filepath.Join(dst, "metrics.interim"))
+	if string(data) != "sample 1 with more metrics" {
+		t.Errorf("copy = %q, want the rewritten file", data)
+	}
+}
+
+func TestCopierSkipsRewrittenFile(t *testing.T) {
+	src := t.TempDir()
+	sizes := map[string]int{"metrics.interim": 8, "metrics.shrinking": 20, "metrics.growing": 8}
+	start := time.Now().Add(-time.Hour)
+	for name, size := range sizes {
+		writeTestFile(t, filepath.Join(src, name), strings.Repeat("0", size), start)
+	}
+	writeTestFile(t, filepath.Join(src, "metrics.1"), "stable", start)
+
+	opens := map[string]int{}
+	c := copier{
+		src: hookFS{onOpen: func(name string) {
+			base := path.Base(name)
+			opens[base]++
+			size := sizes[base]
+			switch base {
+			case "metrics.interim":
+				// rewritten in place with the same size, as mongod does
+			case "metrics.shrinking":
+				// truncated below the size each copy started from
+				size -= 5 * opens[base]
+			case "metrics.growing":
+				// rewritten in place and larger each time
+				size += 5 * opens[base]
+			default:
+				return
+			}
+			data := strings.Repeat(fmt.Sprint(opens[base]), size)
+			writeTestFile(t, filepath.Join(src, base), data, start.Add(time.Duration(opens[base])*time.Minute))
+		}},
+		dcrlog: testLogger(t),
+	}
+	dst := t.TempDir()
+	if err := c.copyTree(src+"/", dst); err != nil {
+		t.Fatalf("a rewritten file should not fail the copy: %v", err)
+	}
+	for name := range sizes {
+		if opens[name] != copyAttempts {
+			t.Errorf("%s was opened %d times, want %d", name, opens[name], copyAttempts)
+		}
+		if _, err := os.Stat(filepath.Join(dst, name)); err == nil {
+			t.Errorf("%s changed during every copy and should be skipped", name)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(dst, "metrics.1")); err != nil || string(data) != "stable" {
+		t.Errorf("metrics.1 = %q, %v", data, err)
+	}
+	if c.files != 1 {
+		t.Errorf("counted %d copied files, want 1", c.files)
+	}
+}
+
+// tornFS rewrites a file in place after the first bytes of it were read, as mongod does
+// to metrics.interim, so the copy holds the start of one version and the rest of another.
+type tornFS struct {
+	localFS
+	rewrites map[string]int // by base name, how many opens are torn
+	opens    map[string]int
+	t        *testing.T
+}
+
+type tornReader struct {
+	io.ReadCloser
+	rewrite func()
+}
+
+func (r *tornReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.rewrite != nil {
+		r.rewrite()
+		r.rewrite = nil
+	}
+	return n, err
+}
+
+func (tf tornFS) Open(name string) (io.ReadCloser, error) {
+	in, err := tf.localFS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	base := path.Base(name)
+	tf.opens[base]++
+	if tf.opens[base] > tf.rewrites[base] {
+		return in, nil
+	}
+	version := tf.opens[base]
+	return &tornReader{ReadCloser: in, rewrite: func() {
+		fi, err := os.Stat(name)
+		if err != nil {
+			tf.t.Fatal(err)
+		}
+		data := strings.Repeat(fmt.Sprint(version), int(fi.Size()))
+		writeTestFile(tf.t, name, data, fi.ModTime().Add(time.Second))
+	}}, nil
+}
+
+// Test the FTDC copy - a metrics file rewritten while it is copied never lands torn in
+// ftdcarchive.tar.gz: it is archived from a copy made after it stopped changing, or left out.
+func TestCopierNeverArchivesTornMetricsFile(t *testing.T) {
+	diag := filepath.Join(t.TempDir(), "diagnostic.data")
+	if err := os.Mkdir(diag, 0755); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Hour)
+	for _, name := range []string{"metrics.interim", "metrics.2024-01-01T00-00-00Z-00000", "metrics.busy"} {
+		writeTestFile(t, filepath.Join(diag, name), strings.Repeat("0", 64), start)
+	}
+
+	c := copier{
+		src: tornFS{
+			// metrics.interim settles after one torn copy, metrics.busy never does
+			rewrites: map[string]int{"metrics.interim": 1, "metrics.busy": copyAttempts},
+			opens:    map[string]int{},
+			t:        t,
+		},
+		bufferSize: 16,
+		dcrlog:     testLogger(t),
+	}
+	temp := t.TempDir()
+	if err := c.copyTree(diag, temp); err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []archiver.FileEntry
+	copies, err := os.ReadDir(filepath.Join(temp, "diagnostic.data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range copies {
+		if e.Type().IsRegular() {
+			entries = append(entries, archiver.FileEntry{Name: e.Name(), Path: filepath.Join(temp, "diagnostic.data", e.Name())})
+		}
+	}
+	archive := filepath.Join(t.TempDir(), "ftdcarchive.tar.gz")
+	out, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archiver.TarFiles(entries, out); err != nil {
+		t.Fatal(err)
+	}
+	out.Close()
+
+	f, err := os.Open(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		got[hdr.Name] = string(data)
+	}
+	want := map[string]string{
+		"metrics.interim":                    strings.Repeat("1", 64),
+		"metrics.2024-01-01T00-00-00Z-00000": strings.Repeat("0", 64),
+	}
+	if len(got) != len(want) {
+		t.Errorf("archived %d files, want %d: %q", len(got), len(want), got)
+	}
+	for name, data := range want {
+		if got[name] != data {
+			t.Errorf("%s archived as %q, want %q", name, got[name], data)
+		}
+	}
+}
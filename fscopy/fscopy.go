@@ -17,20 +17,30 @@ package fscopy
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
-	//"io"
+	"io"
 	"os"
 	"os/exec"
 	"strings"
 
+	"golang.org/x/crypto/ssh"
+
 	"dcrcli/dcrconfig"
 	"dcrcli/dcrlogger"
 )
 
 type RemoteCred struct {
-	Username  string
-	Available bool
-	Dcrlog    *dcrlogger.DCRLogger
+	Username   string
+	Available  bool
+	Hosts      []HostSSH  // per-host user, port and key, see UsernameFor
+	SSH        *NativeSSH // nil uses the ssh and rsync binaries
+	Bastion    *Bastion   // nil connects to the nodes directly
+	Transfer   Transfer
+	Sudo       *Sudo       // nil reads remote files as the SSH user
+	Kube       *Kubernetes // nil reaches remote nodes over SSH
+	Containers *Containers // nil reads the files of local nodes from this host
+	Dcrlog     *dcrlogger.DCRLogger
 }
 
 func (rc *RemoteCred) Get() error {
@@ -58,24 +68,82 @@ func (rc *RemoteCred) Get() error {
 	return nil
 }
 
-// GetFromConfig populates the SSH username from a config file instead of an interactive prompt.
-func (rc *RemoteCred) GetFromConfig(c *dcrconfig.Config) {
+// GetFromConfig populates the SSH username, per-host settings, transport and bastion from
+// a config file instead of an interactive prompt.
+func (rc *RemoteCred) GetFromConfig(c *dcrconfig.Config) error {
+	var err error
+	rc.Hosts, err = NewHostSSHTable(c.SSHHosts)
+	if err != nil {
+		return err
+	}
+
 	rc.Username = strings.TrimSpace(c.SSHUsername)
 	if rc.Username == "" {
 		rc.Available = false
-		rc.Dcrlog.Debug("config ssh_username empty, assuming all nodes local")
+		for _, h := range rc.Hosts {
+			rc.Available = rc.Available || h.User != ""
+		}
+		rc.Dcrlog.Debug(fmt.Sprintf("config ssh_username empty, %d ssh_hosts entries", len(rc.Hosts)))
 	} else {
 		rc.Available = true
 		rc.Dcrlog.Debug(fmt.Sprintf("config ssh_username: %s", rc.Username))
 	}
+
+	rc.SSH, err = NewNativeSSH(c.SSH, rc.Dcrlog)
+	if err != nil {
+		return err
+	}
+	if rc.SSH != nil {
+		rc.Dcrlog.Debug(fmt.Sprintf(
+			"native ssh transport, host_key_policy %s, known_hosts %s", rc.SSH.HostKeyPolicy, rc.SSH.KnownHostsFile,
+		))
+	}
+
+	rc.Bastion, err = NewBastion(c.Bastion)
+	if err != nil {
+		return err
+	}
+	if rc.Bastion != nil {
+		rc.Dcrlog.Debug(fmt.Sprintf("remote nodes are reached through bastion %s", rc.Bastion))
+		if rc.SSH != nil {
+			rc.SSH.Bastion = rc.Bastion
+		}
+	}
+
+	rc.Kube, err = NewKubernetes(c.Kubernetes)
+	if err != nil {
+		return err
+	}
+	if rc.Kube != nil {
+		rc.Dcrlog.Debug(fmt.Sprintf("remote nodes are read from their pods with %s", rc.Kube))
+	}
+
+	rc.Containers, err = NewContainers(c.Containers)
+	if err != nil {
+		return err
+	}
+	if rc.Containers != nil {
+		rc.Dcrlog.Debug(fmt.Sprintf(
+			"local nodes are read from their %s containers, %d mappings", rc.Containers.Runtime, len(rc.Containers.Mappings),
+		))
+	}
+	return nil
+}
+
+// CanReach reports whether the files of the remote node hostname can be copied: from its
+// pod with Kube, otherwise over SSH when it has a user.
+func (rc *RemoteCred) CanReach(hostname string) bool {
+	return rc.Kube != nil || rc.UsernameFor(hostname) != ""
 }
 
 type SourceDir struct {
 	IsLocal  bool
 	Path     []byte
 	Hostname []byte
-	SyncPort uint
+	SyncPort uint // SSH port, 0 uses the default
 	Username []byte
+	// IdentityFile is the private key for the node, empty uses the default keys.
+	IdentityFile string
 }
 
 type DestDir struct {
@@ -86,9 +154,19 @@ type DestDir struct {
 type FSCopyJobWithPattern struct {
 	CopyJobDetails  *FSCopyJob
 	CurrentFileName string
+	Patterns        []string // file name globs to copy; empty copies CurrentFileName*
+	AppendOnly      bool     // the files are logs mongod only appends to, see copier
 	Dcrlog          *dcrlogger.DCRLogger
 }
 
+// patterns returns the file name globs copied by the job.
+func (fcjwp *FSCopyJobWithPattern) patterns() []string {
+	if len(fcjwp.Patterns) == 0 {
+		return []string{fcjwp.CurrentFileName + "*"}
+	}
+	return fcjwp.Patterns
+}
+
 func (fcjwp *FSCopyJobWithPattern) StartCopyWithPattern() error {
 	if fcjwp.CopyJobDetails.Src.IsLocal {
 		return fcjwp.StartCopyLocalWithPattern()
@@ -96,92 +174,56 @@ func (fcjwp *FSCopyJobWithPattern) StartCopyWithPattern() error {
 	return fcjwp.StartCopyRemoteWithPattern()
 }
 
+// StartCopyLocalWithPattern copies the files of the local source directory matching the
+// patterns, giving local nodes the same layout as remote ones.
 func (fcjwp *FSCopyJobWithPattern) StartCopyLocalWithPattern() error {
+	cp := copier{src: localFS{}, appendOnly: fcjwp.AppendOnly, dcrlog: fcjwp.Dcrlog}
+	err := cp.copyMatching(string(fcjwp.CopyJobDetails.Src.Path), fcjwp.patterns(), string(fcjwp.CopyJobDetails.Dst.Path))
+	if err == nil {
+		err = cp.result(string(fcjwp.CopyJobDetails.Src.Path))
+	}
+	if err != nil {
+		return fmt.Errorf("StartCopyLocalWithPattern: %w", err)
+	}
 	return nil
 }
 
 func (fcjwp *FSCopyJobWithPattern) StartCopyRemoteWithPattern() error {
-	var cmd *exec.Cmd
-
-	filepattern := `'` + fcjwp.CurrentFileName + `*` + `'`
-	excludepattern := `'` + `*` + `'`
-
-	// we invoke bash shell because the wildcards are interpretted by bash shell not the rsync program
-	fcjwp.Dcrlog.Debug(
-		fmt.Sprintf(
-			"preparing command rsync -az --include=%s --exclude=%s --progress %s@%s:%s/ %s",
-			filepattern,
-			excludepattern,
-			fcjwp.CopyJobDetails.Src.Username,
-			fcjwp.CopyJobDetails.Src.Hostname,
-			fcjwp.CopyJobDetails.Src.Path,
-			fcjwp.CopyJobDetails.Dst.Path,
-		),
-	)
-
-	cmd = exec.Command(
-		"bash",
-		"-c",
-		fmt.Sprintf(
-			"rsync -az --include=%s --exclude=%s --progress %s@%s:%s/ %s",
-			filepattern,
-			excludepattern,
-			fcjwp.CopyJobDetails.Src.Username,
-			fcjwp.CopyJobDetails.Src.Hostname,
-			fcjwp.CopyJobDetails.Src.Path,
-			fcjwp.CopyJobDetails.Dst.Path,
-		))
-
-	//commenting out the cmd.Stdout because it is being used to capture the output below.
-	//cmd.Stdout = fcjwp.CopyJobDetails.Output
-	
-	//Allow user to provide input if needed.
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-    cmd.Stderr = os.Stderr
-    
-	//Executing the rsync command
-	fcjwp.Dcrlog.Debug("rsync command start")
-    fmt.Println("Please add your password for SSH connection:")
-	err := cmd.Run()
-    if err != nil {
-        fcjwp.Dcrlog.Debug(
-            fmt.Sprintf("StartCopyRemoteWithPattern: error doing remote copy job wait %w", err),
-        )
-        return fmt.Errorf("StartCopyRemoteWithPattern: error doing remote copy job wait %w", err)
-    }
-    return nil
-
-    // Removing stderr pipe for now. cmd.StderrPipe() The error produced by the command will appear in real time in the terminal.
-/*	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return err
+	if fcjwp.CopyJobDetails.Kube != nil || fcjwp.CopyJobDetails.Container != nil {
+		err := fcjwp.CopyJobDetails.copyMatchingByTar(fcjwp.patterns())
+		if err != nil {
+			return fmt.Errorf("StartCopyRemoteWithPattern: %w", err)
+		}
+		return nil
 	}
-
-	fcjwp.Dcrlog.Debug("rsync command start")
-	err = cmd.Start()
-	if err != nil {
-		return err
+	if fcjwp.CopyJobDetails.SSH != nil {
+		err := fcjwp.CopyJobDetails.SSH.copyMatching(
+			fcjwp.CopyJobDetails.remoteSource(),
+			fcjwp.patterns(),
+			fcjwp.AppendOnly,
+			string(fcjwp.CopyJobDetails.Dst.Path),
+		)
+		if err != nil {
+			return fmt.Errorf("StartCopyRemoteWithPattern: %w", err)
+		}
+		return nil
 	}
 
-	_, err = io.ReadAll(stderr)
+	args, err := fcjwp.rsyncArgs()
 	if err != nil {
-		_ = cmd.Process.Kill()
-		fcjwp.Dcrlog.Debug(
-			fmt.Sprintf("StartCopyRemoteWithPattern: Error reading from stderr pipe %w", err),
-		)
-		return fmt.Errorf("StartCopyRemoteWithPattern: Error reading from stderr pipe %w", err)
+		return fmt.Errorf("StartCopyRemoteWithPattern: %w", err)
 	}
+	fcjwp.Dcrlog.Debug(fmt.Sprintf("preparing command rsync %q", args))
 
-	err = cmd.Wait()
+	// rsync runs without a shell; it matches the include patterns itself
+	err = fcjwp.CopyJobDetails.Transfer.runRsync(args, fcjwp.Dcrlog)
 	if err != nil {
 		fcjwp.Dcrlog.Debug(
-			fmt.Sprintf("StartCopyRemoteWithPattern: error doing remote copy job wait %w", err),
+			fmt.Sprintf("StartCopyRemoteWithPattern: error doing remote copy job wait %v", err),
 		)
 		return fmt.Errorf("StartCopyRemoteWithPattern: error doing remote copy job wait %w", err)
 	}
 	return nil
-*/
 }
 
 // Copy job
@@ -191,82 +233,155 @@ func (fcjwp *FSCopyJobWithPattern) StartCopyRemoteWithPattern() error {
 // A - Aborted
 // C - Completed successfully
 type FSCopyJob struct {
-	Src    SourceDir
-	Dst    DestDir
-	State  string
-	Output *bytes.Buffer
-	Dcrlog *dcrlogger.DCRLogger
+	Src       SourceDir
+	Dst       DestDir
+	State     string
+	Output    *bytes.Buffer
+	Hosts     []HostSSH   // per-host SSH settings overriding Src, see remoteSource
+	SSH       *NativeSSH  // nil uses the ssh and rsync binaries
+	Bastion   *Bastion    // nil connects to the node directly
+	Transfer  Transfer    // bandwidth, priority and retries of rsync
+	Sudo      *Sudo       // nil reads remote files as the SSH user
+	Kube      *Kubernetes // nil reaches the node over SSH
+	Container *Container  // the node's container on this host, takes precedence when set
+	Dcrlog    *dcrlogger.DCRLogger
 }
 
 // currently only run for remote source directories
 func (fcj *FSCopyJob) StartCopyRemote() error {
-	// var cmd *exec.Cmd
-
-	fcj.Dcrlog.Debug(fmt.Sprintf("preparing command rsync -az --progress %s@%s:%s/ %s",
-		fcj.Src.Username,
-		fcj.Src.Hostname,
-		fcj.Src.Path,
-		fcj.Dst.Path))
-
-	cmd := exec.Command(
-		"rsync",
-		"-az",
-		"--progress",
-		fmt.Sprintf(`%s@%s:%s`,
-			fcj.Src.Username,
-			fcj.Src.Hostname,
-			fcj.Src.Path),
-		fmt.Sprintf(`%s`,
-			fcj.Dst.Path),
-	)
-
-	//cmd.Stdout = fcj.Output
-	// Allow user to provide input if needed
-	cmd.Stdin = os.Stdin
-    cmd.Stdout = os.Stdout
-    cmd.Stderr = os.Stderr
-
-
-    fcj.Dcrlog.Debug("starting rsync command")
-	// Ask for SSH password
-    fmt.Println("Please add your password for SSH connection ")
-	err := cmd.Run()
-    if err != nil {
-        fcj.Dcrlog.Debug(fmt.Sprintf("error doing remote copy job wait %w", err))
-        return fmt.Errorf("error doing remote copy job wait %w", err)
-    }
-    return nil
+	if fcj.Kube != nil || fcj.Container != nil {
+		err := fcj.copyTreeByTar()
+		if err != nil {
+			return fmt.Errorf("error doing remote copy job %w", err)
+		}
+		return nil
+	}
+	if fcj.SSH != nil {
+		err := fcj.SSH.copyTree(fcj.remoteSource(), string(fcj.Dst.Path))
+		if err != nil {
+			return fmt.Errorf("error doing remote copy job %w", err)
+		}
+		return nil
+	}
 
-/*	stderr, err := cmd.StderrPipe()
+	args, err := fcj.rsyncArgs()
 	if err != nil {
-		return err
+		return fmt.Errorf("error doing remote copy job %w", err)
 	}
+	fcj.Dcrlog.Debug(fmt.Sprintf("preparing command rsync %q", args))
 
-	fcj.Dcrlog.Debug("starting rsync command")
-	err = cmd.Start()
+	err = fcj.Transfer.runRsync(args, fcj.Dcrlog)
 	if err != nil {
-		return err
+		fcj.Dcrlog.Debug(fmt.Sprintf("error doing remote copy job wait %v", err))
+		return fmt.Errorf("error doing remote copy job wait %w", err)
 	}
+	return nil
+}
 
-	_, err = io.ReadAll(stderr)
-	if err != nil {
-		_ = cmd.Process.Kill()
-		fcj.Dcrlog.Debug(fmt.Sprintf("error reading from stderr pipe %w", err))
-		return fmt.Errorf("error reading from stderr pipe %w", err)
+// RunCommand runs argv on the source node and writes its standard output to stdout.
+// Local sources run argv directly; remote sources run it over ssh, through Sudo when set,
+// where every argument is single quoted so the remote shell passes it through unchanged,
+// or in their pod with kubectl exec when Kube is set. Nodes in a Container run it there.
+func (fcj *FSCopyJob) RunCommand(argv []string, stdout io.Writer) error {
+	overSSH := !fcj.Src.IsLocal && fcj.Kube == nil && fcj.Container == nil
+	if overSSH {
+		argv = fcj.Sudo.command(argv)
+	}
+	if overSSH && fcj.SSH != nil {
+		fcj.Dcrlog.Debug(fmt.Sprintf("running command %q on %s over native ssh", argv, fcj.Src.Hostname))
+		var stderr bytes.Buffer
+		err := fcj.SSH.run(fcj.remoteSource(), argv, stdout, &stderr)
+		if err != nil {
+			return &CommandError{Args: argv, Stderr: strings.TrimSpace(stderr.String()), Err: err}
+		}
+		return nil
 	}
 
-	err = cmd.Wait()
-	if err != nil {
-		fcj.Dcrlog.Debug(fmt.Sprintf("error doing remote copy job wait %w", err))
-		return fmt.Errorf("error doing remote copy job wait %w", err)
+	var cmd *exec.Cmd
+	switch {
+	case fcj.Container != nil:
+		cmd = exec.Command(fcj.Container.Runtime, fcj.Container.execArgs(argv)...)
+	case fcj.Src.IsLocal:
+		cmd = exec.Command(argv[0], argv[1:]...)
+	case fcj.Kube != nil:
+		args, err := fcj.Kube.execArgs(string(fcj.Src.Hostname), argv)
+		if err != nil {
+			return &CommandError{Args: argv, Err: err}
+		}
+		cmd = exec.Command(kubectlCommand, args...)
+	default:
+		src := fcj.remoteSource()
+		target, err := src.sshTarget()
+		if err != nil {
+			return &CommandError{Args: argv, Err: err}
+		}
+		args := append(src.sshOptions(fcj.Bastion), "--", target, ShellQuoteArgs(argv))
+		cmd = exec.Command("ssh", args...)
 	}
+	fcj.Dcrlog.Debug(fmt.Sprintf("running command %q on source node", cmd.Args))
+
+	var stderr bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
 
+	err := cmd.Run()
+	if err != nil {
+		return &CommandError{Args: argv, Stderr: strings.TrimSpace(stderr.String()), Err: err}
+	}
 	return nil
-	*/
+}
+
+// CommandError is returned by RunCommand when the command fails or cannot be started.
+type CommandError struct {
+	Args   []string
+	Stderr string
+	Err    error
+}
 
+func (ce *CommandError) Error() string {
+	if ce.Stderr == "" {
+		return fmt.Sprintf("command %q failed: %v", ce.Args, ce.Err)
+	}
+	return fmt.Sprintf("command %q failed: %v: %s", ce.Args, ce.Err, ce.Stderr)
 }
 
+func (ce *CommandError) Unwrap() error {
+	return ce.Err
+}
+
+// ExitCode is the command's exit status, or -1 when it did not run to completion.
+func (ce *CommandError) ExitCode() int {
+	var exitErr *exec.ExitError
+	if errors.As(ce.Err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	var sshExitErr *ssh.ExitError
+	if errors.As(ce.Err, &sshExitErr) {
+		return sshExitErr.ExitStatus()
+	}
+	return -1
+}
+
+// ShellQuoteArgs quotes each argument for a POSIX shell and joins them with spaces.
+func ShellQuoteArgs(argv []string) string {
+	quoted := make([]string, 0, len(argv))
+	for _, arg := range argv {
+		quoted = append(quoted, "'"+strings.ReplaceAll(arg, "'", `'\''`)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
+
+// StartCopyLocal copies the local source path like StartCopyRemote does for remote ones.
 func (fcj *FSCopyJob) StartCopyLocal() error {
+	cp := copier{src: localFS{}, dcrlog: fcj.Dcrlog}
+	err := cp.copyTree(string(fcj.Src.Path), string(fcj.Dst.Path))
+	if err == nil {
+		err = cp.result(string(fcj.Src.Path))
+	}
+	if err != nil {
+		return fmt.Errorf("error doing local copy job %w", err)
+	}
 	return nil
 }
 
@@ -16,29 +16,25 @@ package fscopy
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
-// Testing for
-// - local copy job
-// - remote copy job with Default sync port
-// - remote copy job with non-Default sync port
-
-// - local copy job
 func TestStartCopyLocal(t *testing.T) {
 	fcj := FSCopyJob{
-		SourceDir{
-			true,
-			[]byte(`/Users/nishant/myprojects/testclusters/standalone/data/db/diagnostic.data/`),
-			[]byte(``),
-			0,
-			[]byte(`ubuntu`),
+		Src: SourceDir{
+			IsLocal:  true,
+			Path:     []byte(t.TempDir() + "/"),
+			Username: []byte(`ubuntu`),
 		},
-		DestDir{
-			[]byte(`/Users/nishant/myprojects/dcrcliProject/branches/remotecopier/dcrcli/outputs`),
+		Dst: DestDir{
+			Path: []byte(t.TempDir()),
 		},
-		"N",
-		&bytes.Buffer{},
+		State:  "N",
+		Output: &bytes.Buffer{},
+		Dcrlog: testLogger(t),
 	}
 	err := fcj.StartCopy()
 	if err != nil {
@@ -46,7 +42,6 @@ func TestStartCopyLocal(t *testing.T) {
 	}
 }
 
-// - remote copy job with Default sync port
 /**
 func TestStartCopyRemote(t *testing.T) {
 	fcj := FSCopyJob{
@@ -69,3 +64,117 @@ func TestStartCopyRemote(t *testing.T) {
 	}
 }
 */
+
+// fakeRsync puts an rsync on PATH that records its arguments, one per line, and returns
+// the file they are written to.
+func fakeRsync(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := "#!/bin/sh\nfor a in \"$@\"; do printf '%s\\n' \"$a\"; done > '" + argsFile + "'\n"
+	if err := os.WriteFile(filepath.Join(dir, "rsync"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+func TestRemotePatternCopyRejectsHostileHostnames(t *testing.T) {
+	argsFile := fakeRsync(t)
+	pwned := filepath.Join(t.TempDir(), "pwned")
+
+	for _, host := range []string{
+		"-oProxyCommand=touch " + pwned,
+		"db1;touch " + pwned,
+		"$(touch " + pwned + ")",
+		"`touch " + pwned + "`",
+		"db1 -oProxyCommand=x",
+		"db1\ntouch",
+		"",
+	} {
+		job := FSCopyJobWithPattern{
+			CopyJobDetails: &FSCopyJob{
+				Src:    SourceDir{Path: []byte("/var/log/mongodb"), Hostname: []byte(host), Username: []byte("ubuntu")},
+				Dst:    DestDir{Path: []byte(t.TempDir())},
+				Dcrlog: testLogger(t),
+			},
+			CurrentFileName: "mongod.log",
+			Dcrlog:          testLogger(t),
+		}
+		if err := job.StartCopyRemoteWithPattern(); err == nil {
+			t.Errorf("hostname %q should be rejected", host)
+		}
+	}
+	if _, err := os.Stat(argsFile); err == nil {
+		t.Error("rsync must not run for a rejected hostname")
+	}
+	if _, err := os.Stat(pwned); err == nil {
+		t.Fatal("hostname was executed by a shell")
+	}
+
+	fcj := FSCopyJob{
+		Src:    SourceDir{Hostname: []byte("db1"), Username: []byte("-oProxyCommand=x")},
+		Dcrlog: testLogger(t),
+	}
+	if err := fcj.RunCommand([]string{"true"}, &bytes.Buffer{}); err == nil {
+		t.Error("user name starting with - should be rejected")
+	}
+}
+
+func TestRemotePatternCopyPassesHostilePathsAsArguments(t *testing.T) {
+	argsFile := fakeRsync(t)
+	pwned := filepath.Join(t.TempDir(), "pwned")
+
+	for _, tc := range []struct {
+		path, file string
+	}{
+		{"/var/log/$(touch " + pwned + ")", "mongod.log"},
+		{"/var/log/`touch " + pwned + "`; touch " + pwned, "mongod.log"},
+		{"/var/log/it's here", "mongod.log'; touch " + pwned + "; '"},
+		{"/var/log/mongodb", "-e touch " + pwned},
+	} {
+		dst := filepath.Join(t.TempDir(), "dst; touch "+pwned)
+		job := FSCopyJobWithPattern{
+			CopyJobDetails: &FSCopyJob{
+				Src:    SourceDir{Path: []byte(tc.path), Hostname: []byte("db1.example.net"), Username: []byte("ubuntu")},
+				Dst:    DestDir{Path: []byte(dst)},
+				Dcrlog: testLogger(t),
+			},
+			CurrentFileName: tc.file,
+			Dcrlog:          testLogger(t),
+		}
+		if err := job.StartCopyRemoteWithPattern(); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := os.ReadFile(argsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{
+			"-az", "--protect-args", "--include=" + tc.file + "*", "--exclude=*", "--progress", "--",
+			"ubuntu@db1.example.net:" + tc.path + "/", dst,
+		}
+		if got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+			t.Fatalf("unexpected rsync arguments:\n got %q\nwant %q", got, want)
+		}
+	}
+	if _, err := os.Stat(pwned); err == nil {
+		t.Fatal("path was executed by a shell")
+	}
+
+	job := FSCopyJob{
+		Src:    SourceDir{Path: []byte(":module/x"), Hostname: []byte("db1"), Username: []byte("ubuntu")},
+		Dcrlog: testLogger(t),
+	}
+	if err := job.StartCopyRemote(); err == nil {
+		t.Error("relative remote path should be rejected")
+	}
+}
+
+func TestRsyncSourceBracketsIPv6(t *testing.T) {
+	src, err := SourceDir{Hostname: []byte("fe80::1"), Username: []byte("ubuntu")}.rsyncSource("/data/")
+	if err != nil || src != "ubuntu@[fe80::1]:/data/" {
+		t.Fatalf("unexpected rsync source %q: %v", src, err)
+	}
+}
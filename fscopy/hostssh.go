@@ -0,0 +1,119 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"dcrcli/dcrconfig"
+)
+
+// HostSSH overrides the SSH user, port and key of the nodes whose hostname matches Match,
+// a hostname, IP address or glob such as "db*.example.net".
+type HostSSH struct {
+	Match        string
+	User         string // empty keeps the user of the job
+	Port         int    // 0 keeps the default port
+	IdentityFile string // empty uses the default keys
+}
+
+// NewHostSSHTable returns the validated per-host SSH settings of c, in order.
+func NewHostSSHTable(c []dcrconfig.SSHHostConfig) ([]HostSSH, error) {
+	table := make([]HostSSH, 0, len(c))
+	for i, entry := range c {
+		h := HostSSH{
+			Match:        strings.ToLower(strings.TrimSpace(entry.Match)),
+			User:         strings.TrimSpace(entry.User),
+			Port:         entry.Port,
+			IdentityFile: strings.TrimSpace(entry.IdentityFile),
+		}
+		if h.Match == "" {
+			return nil, fmt.Errorf("ssh_hosts[%d]: match is empty", i)
+		}
+		if _, err := path.Match(h.Match, ""); err != nil {
+			return nil, fmt.Errorf("ssh_hosts[%d]: invalid match %q: %w", i, entry.Match, err)
+		}
+		if h.User != "" {
+			if err := checkSSHName("user", h.User, isUsernameChar); err != nil {
+				return nil, fmt.Errorf("ssh_hosts[%d]: %w", i, err)
+			}
+		}
+		if h.Port < 0 || h.Port > 65535 {
+			return nil, fmt.Errorf("ssh_hosts[%d]: invalid port %d", i, h.Port)
+		}
+		var err error
+		if h.IdentityFile, err = expandHome(h.IdentityFile); err != nil {
+			return nil, fmt.Errorf("ssh_hosts[%d]: cannot locate identity file: %w", i, err)
+		}
+		// the path ends up in the rsync -e command, where a double quote ends the argument
+		if strings.ContainsAny(h.IdentityFile, "\"\n") {
+			return nil, fmt.Errorf("ssh_hosts[%d]: invalid identity_file %q", i, h.IdentityFile)
+		}
+		table = append(table, h)
+	}
+	return table, nil
+}
+
+// lookupHostSSH returns the settings of hostname like ssh_config: each setting comes from
+// the first entry matching hostname that sets it.
+func lookupHostSSH(table []HostSSH, hostname string) HostSSH {
+	hostname = strings.ToLower(hostname)
+	var found HostSSH
+	for _, h := range table {
+		if ok, _ := path.Match(h.Match, hostname); !ok {
+			continue
+		}
+		if found.Match == "" {
+			found.Match = h.Match
+		}
+		if found.User == "" {
+			found.User = h.User
+		}
+		if found.Port == 0 {
+			found.Port = h.Port
+		}
+		if found.IdentityFile == "" {
+			found.IdentityFile = h.IdentityFile
+		}
+	}
+	return found
+}
+
+// remoteSource returns Src with the user, port and key the Hosts table sets for its host.
+func (fcj *FSCopyJob) remoteSource() SourceDir {
+	src := fcj.Src
+	h := lookupHostSSH(fcj.Hosts, string(src.Hostname))
+	if h.User != "" {
+		src.Username = []byte(h.User)
+	}
+	if h.Port != 0 {
+		src.SyncPort = uint(h.Port)
+	}
+	if h.IdentityFile != "" {
+		src.IdentityFile = h.IdentityFile
+	}
+	return src
+}
+
+// UsernameFor returns the SSH user of hostname: the one set by the Hosts table, or
+// Username. Empty means the node cannot be reached over SSH.
+func (rc *RemoteCred) UsernameFor(hostname string) string {
+	if h := lookupHostSSH(rc.Hosts, hostname); h.User != "" {
+		return h.User
+	}
+	return rc.Username
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dcrcli/dcrconfig"
+)
+
+func TestHostSSHTable(t *testing.T) {
+	for _, c := range []dcrconfig.SSHHostConfig{
+		{User: "ubuntu"},
+		{Match: "db[", User: "ubuntu"},
+		{Match: "db*", User: "-oProxyCommand=x"},
+		{Match: "db*", Port: -1},
+		{Match: "db*", IdentityFile: "/keys/\"x\""},
+	} {
+		if _, err := NewHostSSHTable([]dcrconfig.SSHHostConfig{c}); err == nil {
+			t.Errorf("entry %+v should be rejected", c)
+		}
+	}
+
+	table, err := NewHostSSHTable([]dcrconfig.SSHHostConfig{
+		{Match: "DB1.example.net", Port: 2222},
+		{Match: "db*.example.net", User: "ec2-user", IdentityFile: "/keys/aws"},
+		{Match: "*", User: "ubuntu", Port: 22},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, want := range map[string]HostSSH{
+		"db1.example.net": {Match: "db1.example.net", User: "ec2-user", Port: 2222, IdentityFile: "/keys/aws"},
+		"db2.EXAMPLE.net": {Match: "db*.example.net", User: "ec2-user", Port: 22, IdentityFile: "/keys/aws"},
+		"10.0.0.7":        {Match: "*", User: "ubuntu", Port: 22},
+	} {
+		if got := lookupHostSSH(table, host); got != want {
+			t.Errorf("%s: got %+v, want %+v", host, got, want)
+		}
+	}
+
+	rc := RemoteCred{Username: "mongod-admin", Hosts: table[:2]}
+	if u := rc.UsernameFor("db3.example.net"); u != "ec2-user" {
+		t.Errorf("expected the user of the matching entry, got %q", u)
+	}
+	if u := rc.UsernameFor("10.0.0.7"); u != "mongod-admin" {
+		t.Errorf("expected ssh_username for an unmatched host, got %q", u)
+	}
+}
+
+func TestRemotePatternCopyUsesHostSSH(t *testing.T) {
+	argsFile := fakeRsync(t)
+	table, err := NewHostSSHTable([]dcrconfig.SSHHostConfig{
+		{Match: "db*.internal", User: "ec2-user", Port: 2222, IdentityFile: "/keys/it's here"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := t.TempDir()
+	job := FSCopyJobWithPattern{
+		CopyJobDetails: &FSCopyJob{
+			Src:    SourceDir{Path: []byte("/var/log/mongodb"), Hostname: []byte("db1.internal"), Username: []byte("ubuntu")},
+			Dst:    DestDir{Path: []byte(dst)},
+			Hosts:  table,
+			Dcrlog: testLogger(t),
+		},
+		CurrentFileName: "mongod.log",
+		Dcrlog:          testLogger(t),
+	}
+	if err := job.StartCopyRemoteWithPattern(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"-az", "--protect-args",
+		"-e", `ssh -p 2222 -i "/keys/it's here"`,
+		"--include=mongod.log*", "--exclude=*", "--progress", "--",
+		"ec2-user@db1.internal:/var/log/mongodb/", dst,
+	}
+	if got := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n"); strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+		t.Fatalf("unexpected rsync arguments:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestNativeSSHUsesHostSSHPort(t *testing.T) {
+	ns, server := newTestNativeSSH(t, HostKeyAcceptNew)
+	ns.Port = 1
+
+	remote := t.TempDir()
+	if err := os.WriteFile(filepath.Join(remote, "mongod.log"), []byte("log"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	job := FSCopyJob{
+		Src:    SourceDir{Path: []byte(remote + "/"), Hostname: []byte("127.0.0.1"), Username: []byte("dcr")},
+		Dst:    DestDir{Path: []byte(t.TempDir())},
+		Hosts:  []HostSSH{{Match: "127.0.0.*", Port: server.port}},
+		SSH:    ns,
+		Dcrlog: ns.Dcrlog,
+	}
+	if err := job.StartCopyRemote(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(string(job.Dst.Path), "mongod.log")); err != nil {
+		t.Fatal(err)
+	}
+}
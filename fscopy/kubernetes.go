@@ -0,0 +1,187 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"dcrcli/dcrconfig"
+)
+
+// kubectlCommand is the kubectl binary, looked up in the PATH.
+var kubectlCommand = "kubectl"
+
+// Kubernetes reads the files of remote nodes from their pods with kubectl exec, for
+// clusters run by the MongoDB Kubernetes operator where the nodes have no SSH. Commands
+// run in the mongod container and files are copied out as tar streams, like kubectl cp.
+type Kubernetes struct {
+	Kubeconfig string // empty uses kubectl's default
+	Context    string // empty uses the current context
+	Namespace  string // namespace of hostnames without one, empty uses the context's
+	Container  string // empty uses the pod's default container
+	Pods       []KubernetesPod
+}
+
+// KubernetesPod is the pod of the nodes whose hostname matches Match, a hostname or glob.
+// Empty fields are taken from the hostname and the Kubernetes defaults.
+type KubernetesPod struct {
+	Match     string
+	Pod       string
+	Namespace string
+	Container string
+}
+
+// NewKubernetes returns the kubectl settings configured by c, or nil when the Kubernetes
+// transport is not enabled.
+func NewKubernetes(c dcrconfig.KubernetesConfig) (*Kubernetes, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	k := &Kubernetes{
+		Context:   strings.TrimSpace(c.Context),
+		Namespace: strings.TrimSpace(c.Namespace),
+		Container: strings.TrimSpace(c.Container),
+	}
+	var err error
+	if k.Kubeconfig, err = expandHome(strings.TrimSpace(c.Kubeconfig)); err != nil {
+		return nil, fmt.Errorf("cannot locate kubeconfig: %w", err)
+	}
+	if strings.HasPrefix(k.Context, "-") {
+		return nil, fmt.Errorf("invalid kubernetes context %q: must not start with \"-\"", k.Context)
+	}
+	if err := checkKubeName("namespace", k.Namespace); err != nil {
+		return nil, err
+	}
+	if err := checkKubeName("container", k.Container); err != nil {
+		return nil, err
+	}
+
+	for i, entry := range c.Pods {
+		p := KubernetesPod{
+			Match:     strings.ToLower(strings.TrimSpace(entry.Match)),
+			Pod:       strings.TrimSpace(entry.Pod),
+			Namespace: strings.TrimSpace(entry.Namespace),
+			Container: strings.TrimSpace(entry.Container),
+		}
+		if p.Match == "" {
+			return nil, fmt.Errorf("kubernetes.pods[%d]: match is empty", i)
+		}
+		if _, err := path.Match(p.Match, ""); err != nil {
+			return nil, fmt.Errorf("kubernetes.pods[%d]: invalid match %q: %w", i, entry.Match, err)
+		}
+		for _, name := range []struct{ kind, value string }{
+			{"pod", p.Pod}, {"namespace", p.Namespace}, {"container", p.Container},
+		} {
+			if err := checkKubeName(name.kind, name.value); err != nil {
+				return nil, fmt.Errorf("kubernetes.pods[%d]: %w", i, err)
+			}
+		}
+		k.Pods = append(k.Pods, p)
+	}
+	return k, nil
+}
+
+// checkKubeName rejects names that are not lowercase DNS names, which pod, namespace and
+// container names are. Empty names are accepted.
+func checkKubeName(kind string, value string) error {
+	if value == "" {
+		return nil
+	}
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, ".") {
+		return fmt.Errorf("invalid kubernetes %s %q: must start with a letter or digit", kind, value)
+	}
+	for _, r := range value {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '.') {
+			return fmt.Errorf("invalid kubernetes %s %q: character %q not allowed", kind, value, r)
+		}
+	}
+	return nil
+}
+
+func (k *Kubernetes) String() string {
+	where := "current context"
+	if k.Context != "" {
+		where = "context " + k.Context
+	}
+	if k.Kubeconfig != "" {
+		where += " of " + k.Kubeconfig
+	}
+	return "kubectl exec, " + where
+}
+
+// PodFor returns the pod running the node hostname. The operator names members
+// "<pod>.<service>.<namespace>.svc.cluster.local", so the pod is the first label of the
+// hostname and the namespace the third when the fourth is "svc"; the first Pods entry
+// matching hostname overrides either.
+func (k *Kubernetes) PodFor(hostname string) (KubernetesPod, error) {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	labels := strings.Split(hostname, ".")
+	p := KubernetesPod{Match: hostname, Pod: labels[0], Namespace: k.Namespace, Container: k.Container}
+	if len(labels) >= 4 && labels[3] == "svc" {
+		p.Namespace = labels[2]
+	}
+	for _, entry := range k.Pods {
+		if ok, _ := path.Match(entry.Match, hostname); !ok {
+			continue
+		}
+		if entry.Pod != "" {
+			p.Pod = entry.Pod
+		}
+		if entry.Namespace != "" {
+			p.Namespace = entry.Namespace
+		}
+		if entry.Container != "" {
+			p.Container = entry.Container
+		}
+		break
+	}
+	if p.Pod == "" {
+		return p, fmt.Errorf("no kubernetes pod for node %q", hostname)
+	}
+	for _, name := range []struct{ kind, value string }{{"pod", p.Pod}, {"namespace", p.Namespace}} {
+		if err := checkKubeName(name.kind, name.value); err != nil {
+			return p, fmt.Errorf("node %q: %w, set kubernetes.pods", hostname, err)
+		}
+	}
+	return p, nil
+}
+
+// execArgs returns the kubectl arguments running argv in the pod of hostname. kubectl
+// exec passes argv to the container runtime as is, without a shell.
+func (k *Kubernetes) execArgs(hostname string, argv []string) ([]string, error) {
+	p, err := k.PodFor(hostname)
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	if k.Kubeconfig != "" {
+		args = append(args, "--kubeconfig", k.Kubeconfig)
+	}
+	if k.Context != "" {
+		args = append(args, "--context", k.Context)
+	}
+	args = append(args, "exec")
+	if p.Namespace != "" {
+		args = append(args, "-n", p.Namespace)
+	}
+	args = append(args, p.Pod)
+	if p.Container != "" {
+		args = append(args, "-c", p.Container)
+	}
+	args = append(args, "--")
+	return append(args, argv...), nil
+}
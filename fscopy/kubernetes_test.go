@@ -0,0 +1,164 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dcrcli/dcrconfig"
+)
+
+// fakeKubectl puts a kubectl on PATH that records its arguments and runs the command
+// after "--" on this host, standing in for the pod.
+func fakeKubectl(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$*\" >> '" + argsFile + "'\n" +
+		"while [ \"$1\" != -- ]; do shift; done\nshift\nexec \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "kubectl"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+func TestKubernetesPodFor(t *testing.T) {
+	if k, err := NewKubernetes(dcrconfig.KubernetesConfig{Context: "prod"}); k != nil || err != nil {
+		t.Fatalf("kubernetes should be off unless enabled: %v %v", k, err)
+	}
+	for _, c := range []dcrconfig.KubernetesConfig{
+		{Enabled: true, Namespace: "Mongo DB"},
+		{Enabled: true, Container: "-it"},
+		{Enabled: true, Pods: []dcrconfig.KubernetesPodConfig{{Pod: "rs0-0"}}},
+		{Enabled: true, Pods: []dcrconfig.KubernetesPodConfig{{Match: "[", Pod: "rs0-0"}}},
+	} {
+		if _, err := NewKubernetes(c); err == nil {
+			t.Errorf("%+v should be rejected", c)
+		}
+	}
+
+	k, err := NewKubernetes(dcrconfig.KubernetesConfig{
+		Enabled:   true,
+		Namespace: "default-ns",
+		Container: "mongod",
+		Pods: []dcrconfig.KubernetesPodConfig{
+			{Match: "*.example.net", Namespace: "external"},
+			{Match: "db1", Pod: "rs1-0", Container: "mongodb-enterprise-database"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		hostname string
+		want     KubernetesPod
+	}{
+		{"rs0-0.rs0-svc.mongodb.svc.cluster.local", KubernetesPod{Pod: "rs0-0", Namespace: "mongodb", Container: "mongod"}},
+		{"rs0-1.rs0-svc.mongodb.svc", KubernetesPod{Pod: "rs0-1", Namespace: "mongodb", Container: "mongod"}},
+		{"rs0-2", KubernetesPod{Pod: "rs0-2", Namespace: "default-ns", Container: "mongod"}},
+		{"RS0-0.Example.NET", KubernetesPod{Pod: "rs0-0", Namespace: "external", Container: "mongod"}},
+		{"db1", KubernetesPod{Pod: "rs1-0", Namespace: "default-ns", Container: "mongodb-enterprise-database"}},
+	}
+	for _, tt := range tests {
+		got, err := k.PodFor(tt.hostname)
+		if err != nil {
+			t.Errorf("PodFor(%q): %v", tt.hostname, err)
+			continue
+		}
+		got.Match = ""
+		if got != tt.want {
+			t.Errorf("PodFor(%q) = %+v, want %+v", tt.hostname, got, tt.want)
+		}
+	}
+	if _, err := k.PodFor("db_2.example.org"); err == nil {
+		t.Error("a hostname that is no pod name should be rejected")
+	}
+}
+
+func TestKubernetesCopyFromPod(t *testing.T) {
+	argsFile := fakeKubectl(t)
+	src := t.TempDir()
+	mtime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, dir := range []string{"log", "diagnostic.data"} {
+		if err := os.Mkdir(filepath.Join(src, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, filepath.Join(src, "log", "mongod.log"), "current", mtime)
+	writeTestFile(t, filepath.Join(src, "log", "mongod.log.2024-01-01T00-00-00"), "rotated", mtime)
+	writeTestFile(t, filepath.Join(src, "log", "audit.log"), "audit", mtime)
+	writeTestFile(t, filepath.Join(src, "diagnostic.data", "metrics.2024-01-01T00-00-00Z-00000"), "metrics", mtime)
+	writeTestFile(t, filepath.Join(src, "diagnostic.data", "metrics.interim"), "interim", mtime)
+
+	k, err := NewKubernetes(dcrconfig.KubernetesConfig{Enabled: true, Context: "ci", Container: "mongod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname := []byte("rs0-0.rs0-svc.mongodb.svc.cluster.local")
+	logs := t.TempDir()
+	job := &FSCopyJob{
+		Src:    SourceDir{Path: []byte(filepath.Join(src, "log")), Hostname: hostname},
+		Dst:    DestDir{Path: []byte(logs)},
+		Kube:   k,
+		Dcrlog: testLogger(t),
+	}
+	err = (&FSCopyJobWithPattern{CopyJobDetails: job, CurrentFileName: "mongod.log", Dcrlog: job.Dcrlog}).StartCopyWithPattern()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"mongod.log": "current", "mongod.log.2024-01-01T00-00-00": "rotated"} {
+		got, err := os.ReadFile(filepath.Join(logs, name))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if fi, err := os.Stat(filepath.Join(logs, "mongod.log")); err != nil || !fi.ModTime().Equal(mtime) {
+		t.Errorf("the modification time of mongod.log was not kept: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(logs, "audit.log")); err == nil {
+		t.Error("audit.log does not match the pattern and should not be copied")
+	}
+
+	ftdc := t.TempDir()
+	job.Src.Path = []byte(filepath.Join(src, "diagnostic.data") + "/")
+	job.Dst.Path = []byte(ftdc)
+	if err := job.StartCopy(); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"metrics.2024-01-01T00-00-00Z-00000", "metrics.interim"} {
+		if _, err := os.Stat(filepath.Join(ftdc, name)); err != nil {
+			t.Errorf("%s was not copied: %v", name, err)
+		}
+	}
+
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(args)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a listing and two tar streams, got %q", lines)
+	}
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "--context ci exec -n mongodb rs0-0 -c mongod -- ") {
+			t.Errorf("unexpected kubectl arguments %q", line)
+		}
+	}
+}
@@ -0,0 +1,531 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+
+	"dcrcli/dcrconfig"
+	"dcrcli/dcrlogger"
+)
+
+// SSH transports selectable with ssh.transport in the config.
+const (
+	TransportOpenSSH = "openssh"
+	TransportNative  = "native"
+)
+
+// Host key policies of the native transport.
+const (
+	// HostKeyStrict only connects to hosts whose key is in the known_hosts file.
+	HostKeyStrict = "strict"
+	// HostKeyAcceptNew adds the key of hosts not yet in the known_hosts file and rejects
+	// hosts whose key changed.
+	HostKeyAcceptNew = "accept-new"
+	// HostKeyOff does not verify host keys.
+	HostKeyOff = "off"
+)
+
+const defaultSSHPort = 22
+
+const sshConnectTimeout = 30 * time.Second
+
+// defaultIdentityFiles are tried, relative to ~/.ssh, when no identity file is configured.
+var defaultIdentityFiles = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// NativeSSH connects to remote nodes with the built-in SSH client and copies files over
+// SFTP, so no ssh or rsync binary and no terminal prompt are needed. Connections are
+// kept open per user, host and port until Close.
+type NativeSSH struct {
+	KnownHostsFile string
+	HostKeyPolicy  string
+	IdentityFile   string // empty tries the default keys in ~/.ssh
+	Port           int
+	Bastion        *Bastion // nil connects to the nodes directly
+	Sudo           *Sudo    // nil runs the sftp subsystem as the SSH user
+	Transfer       Transfer
+	Dcrlog         *dcrlogger.DCRLogger
+
+	mu      sync.Mutex
+	signers []ssh.Signer
+	agent   net.Conn
+	clients map[string]*nativeConn
+	jumps   map[string]*ssh.Client // connections to the bastion by user
+}
+
+type nativeConn struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+}
+
+// NewNativeSSH returns the native transport configured by c, or nil when c selects the
+// ssh and rsync binaries.
+func NewNativeSSH(c dcrconfig.SSHConfig, dcrlog *dcrlogger.DCRLogger) (*NativeSSH, error) {
+	switch strings.TrimSpace(c.Transport) {
+	case "", TransportOpenSSH:
+		return nil, nil
+	case TransportNative:
+	default:
+		return nil, fmt.Errorf("invalid ssh transport %q: expected %q or %q", c.Transport, TransportOpenSSH, TransportNative)
+	}
+
+	ns := &NativeSSH{
+		KnownHostsFile: c.KnownHostsFile,
+		HostKeyPolicy:  c.HostKeyPolicy,
+		IdentityFile:   c.IdentityFile,
+		Port:           c.Port,
+		Dcrlog:         dcrlog,
+	}
+	switch ns.HostKeyPolicy {
+	case "":
+		ns.HostKeyPolicy = HostKeyStrict
+	case HostKeyStrict, HostKeyAcceptNew, HostKeyOff:
+	default:
+		return nil, fmt.Errorf(
+			"invalid ssh host_key_policy %q: expected %q, %q or %q",
+			c.HostKeyPolicy, HostKeyStrict, HostKeyAcceptNew, HostKeyOff,
+		)
+	}
+	if ns.KnownHostsFile == "" {
+		ns.KnownHostsFile = "~/.ssh/known_hosts"
+	}
+	var err error
+	if ns.KnownHostsFile, err = expandHome(ns.KnownHostsFile); err != nil {
+		return nil, fmt.Errorf("cannot locate known_hosts file: %w", err)
+	}
+	if ns.IdentityFile, err = expandHome(ns.IdentityFile); err != nil {
+		return nil, fmt.Errorf("cannot locate ssh identity file: %w", err)
+	}
+	if ns.Port == 0 {
+		ns.Port = defaultSSHPort
+	}
+	if ns.HostKeyPolicy == HostKeyOff {
+		println("WARNING: ssh host_key_policy is \"off\", host keys of remote nodes are not verified")
+	}
+	return ns, nil
+}
+
+// expandHome replaces a leading "~/" in path with the home directory.
+func expandHome(path string) (string, error) {
+	if !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, path[2:]), nil
+}
+
+// Close closes every connection. It is safe to call on a nil NativeSSH.
+func (ns *NativeSSH) Close() {
+	if ns == nil {
+		return
+	}
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	for key, c := range ns.clients {
+		c.sftp.Close()
+		c.ssh.Close()
+		delete(ns.clients, key)
+	}
+	for user, jump := range ns.jumps {
+		jump.Close()
+		delete(ns.jumps, user)
+	}
+	if ns.agent != nil {
+		ns.agent.Close()
+		ns.agent = nil
+	}
+}
+
+// nodeAddr returns the address and the cache key of the connection to the node of src.
+func (ns *NativeSSH) nodeAddr(src SourceDir) (string, string) {
+	port := ns.Port
+	if src.SyncPort != 0 {
+		port = int(src.SyncPort)
+	}
+	addr := net.JoinHostPort(string(src.Hostname), strconv.Itoa(port))
+	return addr, string(src.Username) + "@" + addr
+}
+
+// disconnect closes the connection to the node of src, so the next connect dials again.
+func (ns *NativeSSH) disconnect(src SourceDir) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	_, key := ns.nodeAddr(src)
+	if c, ok := ns.clients[key]; ok {
+		c.sftp.Close()
+		c.ssh.Close()
+		delete(ns.clients, key)
+	}
+	if ns.Bastion != nil {
+		// the connection to the bastion may be what broke
+		user := ns.Bastion.user(string(src.Username))
+		if jump, ok := ns.jumps[user]; ok {
+			jump.Close()
+			delete(ns.jumps, user)
+		}
+	}
+}
+
+// connect returns the open connection to the node of src as its user, dialing it on first
+// use. The port and identity file of src override those of the transport.
+func (ns *NativeSSH) connect(src SourceDir) (*nativeConn, error) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	user := string(src.Username)
+	addr, key := ns.nodeAddr(src)
+	if c, ok := ns.clients[key]; ok {
+		return c, nil
+	}
+
+	if ns.signers == nil {
+		signers, err := ns.loadSigners()
+		if err != nil {
+			return nil, err
+		}
+		ns.signers = signers
+	}
+	signers := ns.signers
+	if src.IdentityFile != "" {
+		signer, err := loadIdentityFile(src.IdentityFile, true)
+		if err != nil {
+			return nil, err
+		}
+		signers = append([]ssh.Signer{signer}, signers...)
+	}
+
+	ns.Dcrlog.Debug(fmt.Sprintf("native ssh: connecting to %s", key))
+	client, err := ns.dial(user, addr, signers)
+	if err != nil {
+		return nil, fmt.Errorf("ssh connection to %s failed: %w", key, err)
+	}
+	sftpClient, err := ns.startSFTP(client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("cannot start SFTP on %s: %w", key, err)
+	}
+
+	if ns.clients == nil {
+		ns.clients = make(map[string]*nativeConn)
+	}
+	c := &nativeConn{ssh: client, sftp: sftpClient}
+	ns.clients[key] = c
+	return c, nil
+}
+
+// startSFTP starts SFTP on client: the sftp subsystem, or the sftp-server binary run
+// through sudo.
+func (ns *NativeSSH) startSFTP(client *ssh.Client) (*sftp.Client, error) {
+	if ns.Sudo == nil {
+		return sftp.NewClient(client)
+	}
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	var stderr bytes.Buffer
+	session.Stderr = &stderr
+	if err := session.Start(ns.Sudo.sftpServerCommand()); err != nil {
+		session.Close()
+		return nil, err
+	}
+	sftpClient, err := sftp.NewClientPipe(stdout, stdin)
+	if err != nil {
+		stdin.Close()
+		session.Wait()
+		session.Close()
+		return nil, fmt.Errorf("%s sftp-server: %w: %s", ns.Sudo, err, strings.TrimSpace(stderr.String()))
+	}
+	return sftpClient, nil
+}
+
+// clientConfig returns the configuration connecting to addr as user with signers.
+func (ns *NativeSSH) clientConfig(user string, addr string, signers []ssh.Signer) (*ssh.ClientConfig, error) {
+	hostKeyCallback, algorithms, err := ns.hostKeyCallback(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.ClientConfig{
+		User:              user,
+		Auth:              []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+		HostKeyCallback:   hostKeyCallback,
+		HostKeyAlgorithms: algorithms,
+		Timeout:           sshConnectTimeout,
+	}, nil
+}
+
+// dial opens an SSH connection to addr, tunneled through the bastion when one is set.
+func (ns *NativeSSH) dial(user string, addr string, signers []ssh.Signer) (*ssh.Client, error) {
+	config, err := ns.clientConfig(user, addr, signers)
+	if err != nil {
+		return nil, err
+	}
+	if ns.Bastion == nil {
+		return ssh.Dial("tcp", addr, config)
+	}
+
+	jump, err := ns.jump(ns.Bastion.user(user))
+	if err != nil {
+		return nil, err
+	}
+	conn, err := jump.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("bastion %s cannot reach %s: %w", ns.Bastion, addr, err)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// jump returns the open connection to the bastion as user, dialing it on first use. The
+// bastion identity file is tried before the keys of the nodes.
+func (ns *NativeSSH) jump(user string) (*ssh.Client, error) {
+	if jump, ok := ns.jumps[user]; ok {
+		return jump, nil
+	}
+
+	signers := ns.signers
+	if ns.Bastion.IdentityFile != "" {
+		signer, err := loadIdentityFile(ns.Bastion.IdentityFile, true)
+		if err != nil {
+			return nil, err
+		}
+		signers = append([]ssh.Signer{signer}, signers...)
+	}
+	addr := ns.Bastion.String()
+	config, err := ns.clientConfig(user, addr, signers)
+	if err != nil {
+		return nil, err
+	}
+	ns.Dcrlog.Debug(fmt.Sprintf("native ssh: connecting to bastion %s@%s", user, addr))
+	jump, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("ssh connection to bastion %s@%s failed: %w", user, addr, err)
+	}
+
+	if ns.jumps == nil {
+		ns.jumps = make(map[string]*ssh.Client)
+	}
+	ns.jumps[user] = jump
+	return jump, nil
+}
+
+// loadSigners collects the keys of the running ssh-agent and the identity file.
+func (ns *NativeSSH) loadSigners() ([]ssh.Signer, error) {
+	var signers []ssh.Signer
+
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			ns.Dcrlog.Warn(fmt.Sprintf("cannot connect to ssh-agent at %s: %s", sock, err))
+		} else {
+			agentSigners, err := agent.NewClient(conn).Signers()
+			if err != nil {
+				conn.Close()
+				ns.Dcrlog.Warn(fmt.Sprintf("cannot list ssh-agent keys: %s", err))
+			} else {
+				ns.agent = conn
+				signers = append(signers, agentSigners...)
+				ns.Dcrlog.Debug(fmt.Sprintf("native ssh: %d key(s) from ssh-agent", len(agentSigners)))
+			}
+		}
+	}
+
+	if ns.IdentityFile != "" {
+		signer, err := loadIdentityFile(ns.IdentityFile, true)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range defaultIdentityFiles {
+			path := filepath.Join(home, ".ssh", name)
+			signer, err := loadIdentityFile(path, false)
+			if err != nil {
+				ns.Dcrlog.Debug(fmt.Sprintf("native ssh: skipping %s: %s", path, err))
+				continue
+			}
+			signers = append(signers, signer)
+		}
+	}
+
+	if len(signers) == 0 {
+		return nil, errors.New(
+			"no SSH keys available for the native transport: start ssh-agent with your key or set ssh.identity_file",
+		)
+	}
+	return signers, nil
+}
+
+// loadIdentityFile reads a private key. An encrypted key asks for its passphrase when
+// prompt is set and stdin is a terminal.
+func loadIdentityFile(path string, prompt bool) (ssh.Signer, error) {
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read ssh identity file: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse ssh identity file %s: %w", path, err)
+		}
+		return signer, nil
+	}
+
+	if !prompt || !term.IsTerminal(int(syscall.Stdin)) {
+		return nil, fmt.Errorf("ssh identity file %s is encrypted: add it to ssh-agent for unattended runs", path)
+	}
+	fmt.Printf("Enter passphrase for %s: ", path)
+	passphrase, err := term.ReadPassword(syscall.Stdin)
+	fmt.Println()
+	if err != nil {
+		return nil, err
+	}
+	signer, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt ssh identity file %s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// hostKeyCallback verifies the host key of addr against the known_hosts file according
+// to the host key policy. It also returns the key algorithms known for addr, so the
+// server presents a key that can be checked instead of one of its other keys.
+func (ns *NativeSSH) hostKeyCallback(addr string) (ssh.HostKeyCallback, []string, error) {
+	if ns.HostKeyPolicy == HostKeyOff {
+		return ssh.InsecureIgnoreHostKey(), nil, nil
+	}
+
+	if ns.HostKeyPolicy == HostKeyAcceptNew {
+		if err := os.MkdirAll(filepath.Dir(ns.KnownHostsFile), 0700); err != nil {
+			return nil, nil, fmt.Errorf("cannot create known_hosts file: %w", err)
+		}
+		f, err := os.OpenFile(ns.KnownHostsFile, os.O_CREATE|os.O_RDONLY, 0600)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot create known_hosts file: %w", err)
+		}
+		f.Close()
+	}
+	// the file is read on each connect, so keys accepted earlier in the run are known
+	check, err := knownhosts.New(ns.KnownHostsFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		// no known_hosts file knows no hosts
+		check = func(string, net.Addr, ssh.PublicKey) error { return &knownhosts.KeyError{} }
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("cannot read known_hosts file: %w", err)
+	}
+
+	callback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return fmt.Errorf(
+				"host key of %s does not match %s, the host may have been reinstalled or the connection intercepted: %w",
+				hostname, ns.KnownHostsFile, err,
+			)
+		}
+		if ns.HostKeyPolicy != HostKeyAcceptNew {
+			return fmt.Errorf(
+				"host %s is not in %s (host_key_policy %q): add its key with ssh-keyscan or use %q: %w",
+				hostname, ns.KnownHostsFile, ns.HostKeyPolicy, HostKeyAcceptNew, err,
+			)
+		}
+		return ns.addKnownHost(hostname, key)
+	}
+	return callback, knownHostAlgorithms(check, addr), nil
+}
+
+func (ns *NativeSSH) addKnownHost(hostname string, key ssh.PublicKey) error {
+	f, err := os.OpenFile(ns.KnownHostsFile, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("cannot add %s to known_hosts: %w", hostname, err)
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key))
+	if err != nil {
+		return fmt.Errorf("cannot add %s to known_hosts: %w", hostname, err)
+	}
+	ns.Dcrlog.Info(fmt.Sprintf("added %s key of %s to %s", key.Type(), hostname, ns.KnownHostsFile))
+	return nil
+}
+
+// knownHostAlgorithms returns the host key algorithms matching the keys known for addr,
+// or nil when none are known. It checks a throwaway key, so the KeyError lists the keys
+// on file.
+func knownHostAlgorithms(check ssh.HostKeyCallback, addr string) []string {
+	probe, err := ssh.NewPublicKey(ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)).Public())
+	if err != nil {
+		return nil
+	}
+	remote := &net.TCPAddr{IP: net.IPv4zero}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			remote.IP = ip
+		}
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(check(addr, remote, probe), &keyErr) {
+		return nil
+	}
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		switch known.Key.Type() {
+		case ssh.KeyAlgoRSA:
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256, ssh.KeyAlgoRSA)
+		default:
+			algorithms = append(algorithms, known.Key.Type())
+		}
+	}
+	return algorithms
+}
@@ -0,0 +1,324 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"dcrcli/dcrlogger"
+)
+
+func testLogger(t *testing.T) *dcrlogger.DCRLogger {
+	t.Helper()
+	dcrlog := dcrlogger.DCRLogger{OutputPrefix: t.TempDir() + "/", FileName: "fscopy_test"}
+	if err := dcrlog.Create(); err != nil {
+		t.Fatal(err)
+	}
+	return &dcrlog
+}
+
+func newTestKey(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, priv
+}
+
+// testSSHServer serves SFTP and exec sessions on the local file system for one client key,
+// and forwards direct-tcpip connections like a bastion.
+type testSSHServer struct {
+	addr     string
+	port     int
+	hostKey  ssh.PublicKey
+	forwards atomic.Int32
+}
+
+func startTestSSHServer(t *testing.T, clientKey ssh.PublicKey) *testSSHServer {
+	t.Helper()
+	hostSigner, _ := newTestKey(t)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	server := &testSSHServer{
+		addr:    listener.Addr().String(),
+		port:    listener.Addr().(*net.TCPAddr).Port,
+		hostKey: hostSigner.PublicKey(),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serveConn(conn, config)
+		}
+	}()
+	return server
+}
+
+// forward connects a direct-tcpip channel to the address it asks for.
+func (s *testSSHServer) forward(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.forwards.Add(1)
+	go ssh.DiscardRequests(requests)
+	go func() {
+		io.Copy(conn, channel)
+		conn.Close()
+	}()
+	go func() {
+		io.Copy(channel, conn)
+		channel.Close()
+	}()
+}
+
+func (s *testSSHServer) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			go s.forward(newChannel)
+			continue
+		}
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				switch req.Type {
+				case "subsystem":
+					req.Reply(true, nil)
+					server, err := sftp.NewServer(channel)
+					if err != nil {
+						return
+					}
+					server.Serve()
+					return
+				case "exec":
+					req.Reply(true, nil)
+					command := string(req.Payload[4:])
+					cmd := exec.Command("sh", "-c", command)
+					cmd.Stdin = channel
+					cmd.Stdout = channel
+					cmd.Stderr = channel.Stderr()
+					status := uint32(0)
+					if err := cmd.Run(); err != nil {
+						status = 255
+						var exitErr *exec.ExitError
+						if errors.As(err, &exitErr) {
+							status = uint32(exitErr.ExitCode())
+						}
+					}
+					channel.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, status))
+					return
+				default:
+					req.Reply(false, nil)
+				}
+			}
+		}()
+	}
+}
+
+// newTestNativeSSH returns a native transport for server using a fresh identity file and
+// known_hosts file.
+func newTestNativeSSH(t *testing.T, policy string) (*NativeSSH, *testSSHServer) {
+	t.Helper()
+	t.Setenv("SSH_AUTH_SOCK", "")
+	signer, priv := newTestKey(t)
+	server := startTestSSHServer(t, signer.PublicKey())
+
+	dir := t.TempDir()
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(identity, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ns := &NativeSSH{
+		KnownHostsFile: filepath.Join(dir, "known_hosts"),
+		HostKeyPolicy:  policy,
+		IdentityFile:   identity,
+		Port:           server.port,
+		Dcrlog:         testLogger(t),
+	}
+	t.Cleanup(ns.Close)
+	return ns, server
+}
+
+func writeKnownHost(t *testing.T, ns *NativeSSH, server *testSSHServer, key ssh.PublicKey) {
+	t.Helper()
+	line := knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, key) + "\n"
+	if err := os.WriteFile(ns.KnownHostsFile, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNativeSSHHostKeyPolicies(t *testing.T) {
+	src := SourceDir{Path: []byte(t.TempDir() + "/"), Hostname: []byte("127.0.0.1"), Username: []byte("dcr")}
+
+	ns, server := newTestNativeSSH(t, HostKeyStrict)
+	writeKnownHost(t, ns, server, server.hostKey)
+	if err := ns.copyTree(src, t.TempDir()); err != nil {
+		t.Fatalf("strict with a known host: %v", err)
+	}
+
+	ns, _ = newTestNativeSSH(t, HostKeyStrict)
+	err := ns.copyTree(src, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "is not in") {
+		t.Fatalf("strict should reject an unknown host, got %v", err)
+	}
+
+	ns, server = newTestNativeSSH(t, HostKeyStrict)
+	other, _ := newTestKey(t)
+	writeKnownHost(t, ns, server, other.PublicKey())
+	err = ns.copyTree(src, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("strict should reject a changed host key, got %v", err)
+	}
+
+	ns, server = newTestNativeSSH(t, HostKeyAcceptNew)
+	if err := ns.copyTree(src, t.TempDir()); err != nil {
+		t.Fatalf("accept-new with an unknown host: %v", err)
+	}
+	known, _ := os.ReadFile(ns.KnownHostsFile)
+	if !strings.Contains(string(known), "[127.0.0.1]:"+strconv.Itoa(server.port)) {
+		t.Fatalf("accept-new should record the host key, known_hosts:\n%s", known)
+	}
+}
+
+func TestNativeSSHCopy(t *testing.T) {
+	ns, _ := newTestNativeSSH(t, HostKeyAcceptNew)
+
+	remote := t.TempDir()
+	mtime := time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC)
+	for _, name := range []string{"mongod.log", "mongod.log.2024-03-08", "other.log", "sub/metrics.interim"} {
+		path := filepath.Join(remote, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	dst := t.TempDir()
+	job := FSCopyJobWithPattern{
+		CopyJobDetails: &FSCopyJob{
+			Src:    SourceDir{Path: []byte(remote), Hostname: []byte("127.0.0.1"), Username: []byte("dcr")},
+			Dst:    DestDir{Path: []byte(dst)},
+			SSH:    ns,
+			Dcrlog: ns.Dcrlog,
+		},
+		CurrentFileName: "mongod.log",
+		Dcrlog:          ns.Dcrlog,
+	}
+	if err := job.StartCopyWithPattern(); err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(dst)
+	if len(entries) != 2 || entries[0].Name() != "mongod.log" || entries[1].Name() != "mongod.log.2024-03-08" {
+		t.Fatalf("unexpected pattern copy: %v", entries)
+	}
+	fi, err := os.Stat(filepath.Join(dst, "mongod.log"))
+	if err != nil || !fi.ModTime().Equal(mtime) {
+		t.Fatalf("modification time not kept: %v %v", fi, err)
+	}
+
+	treeDst := filepath.Join(t.TempDir(), "diagnostic.data")
+	job.CopyJobDetails.Src.Path = []byte(remote + "/")
+	job.CopyJobDetails.Dst.Path = []byte(treeDst)
+	if err := job.CopyJobDetails.StartCopy(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(treeDst, "sub", "metrics.interim")); err != nil || string(data) != "sub/metrics.interim" {
+		t.Fatalf("directory not copied: %q %v", data, err)
+	}
+
+	var out bytes.Buffer
+	if err := job.CopyJobDetails.RunCommand([]string{"echo", "it's here"}, &out); err != nil || out.String() != "it's here\n" {
+		t.Fatalf("unexpected command output %q: %v", out.String(), err)
+	}
+	err = job.CopyJobDetails.RunCommand([]string{"sh", "-c", "exit 3"}, &out)
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.ExitCode() != 3 {
+		t.Fatalf("expected exit code 3, got %v", err)
+	}
+}
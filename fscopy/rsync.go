@@ -0,0 +1,151 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Hostnames come from the cluster topology and paths from the server's command line
+// options, so none of them is trusted. rsync and ssh are run without a shell, rsync
+// with --protect-args so the remote shell does not split or expand the path either,
+// and hostnames and user names that ssh could read as options are rejected.
+
+func isHostnameChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		strings.ContainsRune(".-_:%[]", r)
+}
+
+func isUsernameChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		strings.ContainsRune(".-_@\\", r)
+}
+
+// checkSSHName rejects empty values, values starting with "-" and values with
+// characters not allowed by valid.
+func checkSSHName(kind string, value string, valid func(r rune) bool) error {
+	if value == "" {
+		return fmt.Errorf("empty ssh %s", kind)
+	}
+	if strings.HasPrefix(value, "-") {
+		return fmt.Errorf("invalid ssh %s %q: must not start with \"-\"", kind, value)
+	}
+	for _, r := range value {
+		if !valid(r) {
+			return fmt.Errorf("invalid ssh %s %q: character %q not allowed", kind, value, r)
+		}
+	}
+	return nil
+}
+
+// sshTarget returns the user@host argument for ssh.
+func (sd SourceDir) sshTarget() (string, error) {
+	if err := checkSSHName("user", string(sd.Username), isUsernameChar); err != nil {
+		return "", err
+	}
+	host := string(sd.Hostname)
+	if err := checkSSHName("hostname", host, isHostnameChar); err != nil {
+		return "", err
+	}
+	return string(sd.Username) + "@" + host, nil
+}
+
+// rsyncSource returns the user@host:path source argument for rsync. IPv6 addresses are
+// put in brackets so their colons are not read as the path separator.
+func (sd SourceDir) rsyncSource(path string) (string, error) {
+	target, err := sd.sshTarget()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(path, "/") {
+		// a leading ":" would select an rsync daemon module instead of a path
+		return "", fmt.Errorf("remote path %q is not absolute", path)
+	}
+	host := string(sd.Hostname)
+	if strings.Contains(host, ":") && !strings.HasPrefix(host, "[") {
+		target = string(sd.Username) + "@[" + host + "]"
+	}
+	return target + ":" + path, nil
+}
+
+// sshOptions returns the ssh options selecting the port and key of the node, and the
+// bastion when one is set.
+func (sd SourceDir) sshOptions(b *Bastion) []string {
+	var options []string
+	if sd.SyncPort != 0 {
+		options = append(options, "-p", strconv.FormatUint(uint64(sd.SyncPort), 10))
+	}
+	if sd.IdentityFile != "" {
+		options = append(options, "-i", sd.IdentityFile)
+	}
+	return append(options, b.sshOptions(string(sd.Username))...)
+}
+
+// rsyncShellOptions returns the -e option running ssh with options, or none without
+// options. rsync splits the command at spaces outside of quotes and treats both quote
+// characters as quotes, so arguments with either are put in double quotes; no option
+// holds a double quote.
+func rsyncShellOptions(options []string) []string {
+	if len(options) == 0 {
+		return nil
+	}
+	command := "ssh"
+	for _, o := range options {
+		if strings.ContainsAny(o, " '") {
+			o = `"` + o + `"`
+		}
+		command += " " + o
+	}
+	return []string{"-e", command}
+}
+
+// rsyncArgs returns the arguments of rsync copying src to dst. Options always precede
+// "--", so no path is read as an option.
+func rsyncArgs(options []string, src string, dst string) []string {
+	args := append([]string{"-az", "--protect-args"}, options...)
+	return append(args, "--progress", "--", src, dst)
+}
+
+// rsyncArgs returns the rsync arguments of the job: the files of the source directory
+// matching the patterns, and nothing else.
+func (fcjwp *FSCopyJobWithPattern) rsyncArgs() ([]string, error) {
+	remote := fcjwp.CopyJobDetails.remoteSource()
+	src, err := remote.rsyncSource(strings.TrimSuffix(string(remote.Path), "/") + "/")
+	if err != nil {
+		return nil, err
+	}
+	options := append(
+		fcjwp.CopyJobDetails.Transfer.rsyncOptions(fcjwp.CopyJobDetails.Sudo),
+		rsyncShellOptions(remote.sshOptions(fcjwp.CopyJobDetails.Bastion))...,
+	)
+	for _, p := range fcjwp.patterns() {
+		options = append(options, "--include="+p)
+	}
+	options = append(options, "--exclude=*")
+	return rsyncArgs(options, src, string(fcjwp.CopyJobDetails.Dst.Path)), nil
+}
+
+// rsyncArgs returns the rsync arguments of the job.
+func (fcj *FSCopyJob) rsyncArgs() ([]string, error) {
+	remote := fcj.remoteSource()
+	src, err := remote.rsyncSource(string(remote.Path))
+	if err != nil {
+		return nil, err
+	}
+	options := append(fcj.Transfer.rsyncOptions(fcj.Sudo), rsyncShellOptions(remote.sshOptions(fcj.Bastion))...)
+	return rsyncArgs(options, src, string(fcj.Dst.Path)), nil
+}
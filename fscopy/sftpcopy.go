@@ -0,0 +1,107 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// sftpFS reads the file system of a remote node over SFTP.
+type sftpFS struct {
+	client *sftp.Client
+}
+
+func (s sftpFS) Stat(name string) (fs.FileInfo, error) {
+	return s.client.Stat(name)
+}
+
+func (s sftpFS) ReadDir(name string) ([]fs.FileInfo, error) {
+	return s.client.ReadDir(name)
+}
+
+func (s sftpFS) Open(name string) (io.ReadCloser, error) {
+	return s.client.Open(name)
+}
+
+// copyTree copies src.Path from the node into dst, see copier.copyTree.
+func (ns *NativeSSH) copyTree(src SourceDir, dst string) error {
+	return ns.copyResuming(src, func(cp *copier) error {
+		return cp.copyTree(string(src.Path), dst)
+	})
+}
+
+// copyMatching copies the files of the src.Path directory matching patterns into dst.
+// appendOnly is set for logs, see copier.
+func (ns *NativeSSH) copyMatching(src SourceDir, patterns []string, appendOnly bool, dst string) error {
+	return ns.copyResuming(src, func(cp *copier) error {
+		cp.appendOnly = appendOnly
+		return cp.copyMatching(string(src.Path), patterns, dst)
+	})
+}
+
+// copyResuming runs copy over a connection to the node of src. When the connection fails,
+// it reconnects and runs copy again up to Transfer.Retries times; files copied before are
+// skipped and the interrupted one continues where it stopped.
+func (ns *NativeSSH) copyResuming(src SourceDir, copy func(cp *copier) error) error {
+	cp := &copier{
+		host:    string(src.Hostname),
+		resume:  ns.Transfer.Retries > 0,
+		limiter: newRateLimiter(ns.Transfer.BandwidthLimit),
+		dcrlog:  ns.Dcrlog,
+	}
+	if ns.Transfer.LowPriority {
+		cp.bufferSize = lowPriorityBufferSize
+	}
+	for attempt := 0; ; attempt++ {
+		c, err := ns.connect(src)
+		if err == nil {
+			cp.src = sftpFS{c.sftp}
+			cp.unreadable = nil
+			err = copy(cp)
+		}
+		if err == nil {
+			return cp.result(string(src.Path) + " over SFTP")
+		}
+		if attempt >= ns.Transfer.Retries || !connectionLost(err) {
+			return err
+		}
+		ns.disconnect(src)
+		delay := transferRetryDelay << attempt
+		ns.Dcrlog.Warn(fmt.Sprintf("copy from %s was interrupted (%s), resuming in %s", src.Hostname, err, delay))
+		time.Sleep(delay)
+	}
+}
+
+// run runs argv on the node in a session of the native connection. The remote shell
+// parses the command line, so every argument is quoted.
+func (ns *NativeSSH) run(src SourceDir, argv []string, stdout io.Writer, stderr io.Writer) error {
+	c, err := ns.connect(src)
+	if err != nil {
+		return err
+	}
+	session, err := c.ssh.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	session.Stdout = stdout
+	session.Stderr = stderr
+	return session.Run(ShellQuoteArgs(argv))
+}
@@ -0,0 +1,301 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"dcrcli/budget"
+)
+
+// lowPriorityCommand runs a command on the node at the lowest CPU and I/O priority.
+var lowPriorityCommand = []string{"nice", "-n", "19", "ionice", "-c", "3"}
+
+// RemoteFile is a regular file listed on the source node.
+type RemoteFile struct {
+	Name    string // base name
+	Size    int64
+	ModTime time.Time
+}
+
+// ListFiles lists the regular files directly inside dir on the source node, sorted by
+// name, with find, which prints each one as "size mtime name" ended by a NUL byte.
+func (fcj *FSCopyJob) ListFiles(dir string) ([]RemoteFile, error) {
+	if !path.IsAbs(dir) {
+		return nil, fmt.Errorf("cannot list %q: not an absolute path", dir)
+	}
+	var out bytes.Buffer
+	err := fcj.RunCommand([]string{"find", "-H", dir, "-maxdepth", "1", "-type", "f", "-printf", `%s %T@ %f\0`}, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []RemoteFile
+	for _, record := range strings.Split(out.String(), "\x00") {
+		if record == "" {
+			continue
+		}
+		fields := strings.SplitN(record, " ", 3)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("cannot parse file listing of %s: %q", dir, record)
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse file listing of %s: %w", dir, err)
+		}
+		mtime, err := parseFindTime(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse file listing of %s: %w", dir, err)
+		}
+		files = append(files, RemoteFile{Name: fields[2], Size: size, ModTime: mtime})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, nil
+}
+
+// parseFindTime parses the seconds since the epoch printed by find's %T@.
+func parseFindTime(s string) (time.Time, error) {
+	secs, frac, _ := strings.Cut(s, ".")
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	var nsec int64
+	if frac != "" {
+		frac = (frac + "000000000")[:9]
+		nsec, err = strconv.ParseInt(frac, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	return time.Unix(sec, nsec), nil
+}
+
+// StreamFiles runs tar on the source node to read the files names in dir, and passes
+// each one to fn as it arrives over SSH, compressed on the way, without writing it to
+// disk. Files that cannot be read on the node, e.g. removed since they were listed, are
+// left out of the stream and never passed to fn. An interrupted stream is not resumed.
+func (fcj *FSCopyJob) StreamFiles(dir string, names []string, fn func(hdr *tar.Header, r io.Reader) error) error {
+	if len(names) == 0 {
+		return nil
+	}
+	var argv []string
+	if fcj.Transfer.LowPriority {
+		argv = append(argv, lowPriorityCommand...)
+	}
+	argv = append(argv, "tar", "-czf", "-", "--ignore-failed-read", "-C", dir, "--")
+	argv = append(argv, names...)
+
+	pr, pw := io.Pipe()
+	read := make(chan error, 1)
+	var fnErr error
+	go func() {
+		err := readTarStream(newRateLimiter(fcj.Transfer.BandwidthLimit).reader(pr), func(hdr *tar.Header, r io.Reader) error {
+			fnErr = fn(hdr, r)
+			return fnErr
+		})
+		// stops tar when the stream could not be read
+		pr.CloseWithError(err)
+		read <- err
+	}()
+	runErr := fcj.RunCommand(argv, pw)
+	pw.CloseWithError(runErr)
+	readErr := <-read
+
+	var cmdErr *CommandError
+	if errors.As(runErr, &cmdErr) && cmdErr.ExitCode() == 1 {
+		// GNU tar: some files changed while being read, e.g. the live log
+		fcj.Dcrlog.Debug(fmt.Sprintf("files in %s changed while being streamed: %s", dir, cmdErr.Stderr))
+		runErr = nil
+	}
+	// a failed fn or tar also breaks the other end of the stream
+	switch {
+	case fnErr != nil:
+		return fmt.Errorf("error streaming files from %s: %w", dir, fnErr)
+	case runErr != nil:
+		return fmt.Errorf("error streaming files from %s: %w", dir, runErr)
+	case readErr != nil:
+		return fmt.Errorf("error streaming files from %s: %w", dir, readErr)
+	}
+	return nil
+}
+
+// readTarStream passes each regular file of the gzip compressed tar stream r to fn and
+// reads r to its end.
+func readTarStream(r io.Reader, fn func(hdr *tar.Header, r io.Reader) error) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gzr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+	}
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
+
+// sourceName names where the files of the job are read from in log messages.
+func (fcj *FSCopyJob) sourceName() string {
+	switch {
+	case fcj.Container != nil:
+		return fcj.Container.String()
+	case fcj.Kube != nil:
+		return "the pod of " + string(fcj.Src.Hostname)
+	}
+	return string(fcj.Src.Hostname)
+}
+
+// copyTreeByTar is StartCopyRemote for nodes in a pod or container: it streams Src.Path
+// out with tar and extracts it into Dst.Path, like kubectl cp and docker cp.
+func (fcj *FSCopyJob) copyTreeByTar() error {
+	root := string(fcj.Src.Path)
+	dir, name := path.Clean(root), "."
+	if !strings.HasSuffix(root, "/") {
+		dir, name = path.Dir(dir), path.Base(dir)
+	}
+	dst := string(fcj.Dst.Path)
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	var files int
+	var bytes int64
+	err := fcj.StreamFiles(dir, []string{name}, func(hdr *tar.Header, r io.Reader) error {
+		files++
+		bytes += hdr.Size
+		return extractFile(dst, hdr, r)
+	})
+	if err != nil {
+		return err
+	}
+	fcj.Dcrlog.Info(fmt.Sprintf("copied %d file(s), %d bytes of %s from %s", files, bytes, root, fcj.sourceName()))
+	return nil
+}
+
+// copyMatchingByTar is StartCopyRemoteWithPattern for nodes in a pod or container. Files
+// that could not be read there are reported as an UnreadableError.
+func (fcj *FSCopyJob) copyMatchingByTar(patterns []string) error {
+	dir := path.Clean(string(fcj.Src.Path))
+	listed, err := fcj.ListFiles(dir)
+	if err != nil {
+		return err
+	}
+	dst := string(fcj.Dst.Path)
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	pending := make(map[string]bool)
+	var names []string
+	for _, f := range listed {
+		if matchesAny(f.Name, patterns) {
+			pending[f.Name] = true
+			names = append(names, f.Name)
+		}
+	}
+	var bytes int64
+	err = fcj.StreamFiles(dir, names, func(hdr *tar.Header, r io.Reader) error {
+		if !pending[hdr.Name] {
+			return fmt.Errorf("unexpected file %s in the stream", hdr.Name)
+		}
+		delete(pending, hdr.Name)
+		bytes += hdr.Size
+		return extractFile(dst, hdr, r)
+	})
+	if err != nil {
+		return err
+	}
+	fcj.Dcrlog.Info(fmt.Sprintf(
+		"copied %d file(s), %d bytes of %s from %s", len(names)-len(pending), bytes, dir, fcj.sourceName(),
+	))
+
+	var unreadable []string
+	for _, name := range names {
+		if pending[name] {
+			unreadable = append(unreadable, path.Join(dir, name))
+		}
+	}
+	if len(unreadable) > 0 {
+		return &UnreadableError{Host: string(fcj.Src.Hostname), Paths: unreadable}
+	}
+	return nil
+}
+
+// extractFile writes the regular file hdr of a tar stream below dst, rejecting names that
+// leave dst.
+func extractFile(dst string, hdr *tar.Header, r io.Reader) error {
+	name := path.Clean(hdr.Name)
+	if name == "." || name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+		return fmt.Errorf("unsafe file name %q in the stream", hdr.Name)
+	}
+	target := filepath.Join(dst, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, hdr.FileInfo().Mode().Perm()|0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", target, err)
+	}
+	return os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+}
+
+// CheckFreeSpace returns an error when the file system of dir has less than need bytes
+// available.
+func CheckFreeSpace(dir string, need int64) error {
+	var fsstat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &fsstat); err != nil {
+		return fmt.Errorf("cannot check free space in %s: %w", dir, err)
+	}
+	free := int64(fsstat.Bavail) * int64(fsstat.Bsize)
+	if free < need {
+		return fmt.Errorf(
+			"not enough free space in %s: %s needed, %s available",
+			dir, budget.FormatSize(need), budget.FormatSize(free),
+		)
+	}
+	return nil
+}
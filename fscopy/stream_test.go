@@ -0,0 +1,83 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNativeSSHListAndStreamFiles(t *testing.T) {
+	ns, _ := newTestNativeSSH(t, HostKeyAcceptNew)
+
+	remote := t.TempDir()
+	mtime := time.Date(2024, 3, 9, 10, 0, 0, 500000000, time.UTC)
+	writeTestFile(t, filepath.Join(remote, "metrics.2024-03-09T10-00-00Z-00000"), "ftdc", mtime)
+	writeTestFile(t, filepath.Join(remote, "-name with spaces"), "odd name", mtime)
+	if err := os.Mkdir(filepath.Join(remote, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	job := FSCopyJob{
+		Src:    SourceDir{Hostname: []byte("127.0.0.1"), Username: []byte("dcr")},
+		SSH:    ns,
+		Dcrlog: ns.Dcrlog,
+	}
+
+	files, err := job.ListFiles(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := map[string]RemoteFile{}
+	for _, f := range files {
+		listed[f.Name] = f
+	}
+	if f := listed["-name with spaces"]; len(files) != 2 || f.Size != 8 || !f.ModTime.Equal(mtime) {
+		t.Fatalf("unexpected listing %+v", files)
+	}
+
+	streamed := map[string]string{}
+	err = job.StreamFiles(remote, []string{"-name with spaces", "gone", "metrics.2024-03-09T10-00-00Z-00000"},
+		func(hdr *tar.Header, r io.Reader) error {
+			data, err := io.ReadAll(r)
+			streamed[hdr.Name] = string(data)
+			return err
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streamed) != 2 || streamed["-name with spaces"] != "odd name" || streamed["metrics.2024-03-09T10-00-00Z-00000"] != "ftdc" {
+		t.Fatalf("unexpected streamed files %q", streamed)
+	}
+
+	failed := errors.New("archive full")
+	err = job.StreamFiles(remote, []string{"-name with spaces"}, func(*tar.Header, io.Reader) error { return failed })
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+}
+
+func TestCheckFreeSpace(t *testing.T) {
+	if err := CheckFreeSpace(t.TempDir(), 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckFreeSpace(t.TempDir(), 1<<62); err == nil {
+		t.Fatal("expected an error for more space than any disk has")
+	}
+}
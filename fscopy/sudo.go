@@ -0,0 +1,134 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"dcrcli/dcrconfig"
+)
+
+// sftpServerPaths are where the sftp-server binary is installed by the usual distributions.
+var sftpServerPaths = []string{
+	"/usr/lib/openssh/sftp-server",
+	"/usr/libexec/openssh/sftp-server",
+	"/usr/lib/ssh/sftp-server",
+	"/usr/libexec/sftp-server",
+}
+
+// Sudo reads the files on remote nodes as another user with sudo -n, for logs and
+// diagnostic.data only readable by the mongod user. Commands, rsync and the SFTP server
+// of the native transport run through it; sudo must not ask for a password.
+type Sudo struct {
+	User       string // empty is root
+	SFTPServer string // empty tries sftpServerPaths
+}
+
+// NewSudo returns the sudo settings configured by c, or nil when sudo is not enabled.
+func NewSudo(c dcrconfig.SudoConfig) (*Sudo, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	s := &Sudo{User: strings.TrimSpace(c.User), SFTPServer: strings.TrimSpace(c.SFTPServer)}
+	if s.User != "" {
+		// the user ends up in the rsync path, which the remote shell splits
+		if err := checkSSHName("sudo user", s.User, isSudoUserChar); err != nil {
+			return nil, err
+		}
+	}
+	if s.SFTPServer != "" && !path.IsAbs(s.SFTPServer) {
+		return nil, fmt.Errorf("invalid sudo sftp_server %q: not an absolute path", s.SFTPServer)
+	}
+	return s, nil
+}
+
+func isSudoUserChar(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+		strings.ContainsRune(".-_", r)
+}
+
+func (s *Sudo) String() string {
+	return strings.Join(s.prefix(), " ")
+}
+
+func (s *Sudo) prefix() []string {
+	if s.User == "" {
+		return []string{"sudo", "-n"}
+	}
+	return []string{"sudo", "-n", "-u", s.User}
+}
+
+// command returns argv run through sudo, or argv itself for a nil s.
+func (s *Sudo) command(argv []string) []string {
+	if s == nil {
+		return argv
+	}
+	return append(append(s.prefix(), "--"), argv...)
+}
+
+// rsyncPath returns the --rsync-path value running rsyncCommand on the node through
+// sudo, or rsyncCommand itself for a nil s. The remote shell splits it at spaces.
+func (s *Sudo) rsyncPath(rsyncCommand string) string {
+	if s == nil {
+		return rsyncCommand
+	}
+	return s.String() + " " + rsyncCommand
+}
+
+// sftpServerCommand returns the command the native transport runs on the node instead of
+// the sftp subsystem, starting the first sftp-server found through sudo.
+func (s *Sudo) sftpServerCommand() string {
+	if s.SFTPServer != "" {
+		return ShellQuoteArgs(s.command([]string{s.SFTPServer}))
+	}
+	var script bytes.Buffer
+	script.WriteString("for p in")
+	for _, p := range sftpServerPaths {
+		script.WriteString(" " + p)
+	}
+	script.WriteString(`; do if [ -x "$p" ]; then exec "$p"; fi; done; echo "sftp-server not found, set sudo.sftp_server" >&2; exit 127`)
+	return ShellQuoteArgs(s.command([]string{"sh", "-c", script.String()}))
+}
+
+// unreadableScript prints, NUL terminated, each directory argument that cannot be listed
+// and the files directly inside the others that cannot be read.
+const unreadableScript = `for d; do
+  if [ -d "$d" ] && [ -r "$d" ] && [ -x "$d" ]; then
+    find -H "$d" -maxdepth 1 -type f ! -readable -print0
+  else
+    printf '%s\0' "$d"
+  fi
+done`
+
+// UnreadablePaths returns the directories of dirs on the source node that are missing or
+// cannot be listed, and the files directly inside the others that cannot be read, as the
+// user files are read as: through Sudo when set, otherwise the SSH user.
+func (fcj *FSCopyJob) UnreadablePaths(dirs []string) ([]string, error) {
+	var out bytes.Buffer
+	err := fcj.RunCommand(append([]string{"sh", "-c", unreadableScript, "sh"}, dirs...), &out)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, p := range strings.Split(out.String(), "\x00") {
+		if p != "" {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
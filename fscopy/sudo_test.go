@@ -0,0 +1,131 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+
+	"dcrcli/dcrconfig"
+)
+
+// TestMain serves SFTP on stdin and stdout when the test binary is started as the
+// sftp-server of a node.
+func TestMain(m *testing.M) {
+	if os.Getenv("DCRCLI_TEST_SFTP_SERVER") != "" {
+		server, err := sftp.NewServer(struct {
+			io.Reader
+			io.WriteCloser
+		}{os.Stdin, os.Stdout})
+		if err == nil {
+			server.Serve()
+		}
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeSudo puts a sudo on PATH that records its arguments and runs the command after
+// "--" as the current user.
+func fakeSudo(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := "#!/bin/sh\necho \"$*\" >> '" + argsFile + "'\n" +
+		"while [ \"$1\" != -- ]; do shift; done\nshift\nDCRCLI_TEST_SFTP_SERVER=1 exec \"$@\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return argsFile
+}
+
+func TestNewSudo(t *testing.T) {
+	if s, err := NewSudo(dcrconfig.SudoConfig{User: "mongod"}); s != nil || err != nil {
+		t.Fatalf("sudo should be off unless enabled: %v %v", s, err)
+	}
+	for _, c := range []dcrconfig.SudoConfig{
+		{Enabled: true, User: "-s"},
+		{Enabled: true, User: "mongod;id"},
+		{Enabled: true, SFTPServer: "sftp-server"},
+	} {
+		if _, err := NewSudo(c); err == nil {
+			t.Errorf("%+v should be rejected", c)
+		}
+	}
+
+	s, err := NewSudo(dcrconfig.SudoConfig{Enabled: true, User: "mongod"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := FSCopyJob{
+		Src:      SourceDir{Path: []byte("/data/diagnostic.data/"), Hostname: []byte("db1"), Username: []byte("ubuntu")},
+		Dst:      DestDir{Path: []byte("/tmp/out")},
+		Transfer: Transfer{LowPriority: true},
+		Sudo:     s,
+	}
+	args, err := job.rsyncArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[2] != "--rsync-path=sudo -n -u mongod nice -n 19 ionice -c 3 rsync" {
+		t.Fatalf("unexpected rsync arguments %q", args)
+	}
+}
+
+func TestNativeSSHReadsThroughSudo(t *testing.T) {
+	argsFile := fakeSudo(t)
+	ns, _ := newTestNativeSSH(t, HostKeyAcceptNew)
+	ns.Sudo = &Sudo{User: "mongod", SFTPServer: os.Args[0]}
+
+	remote := t.TempDir()
+	if err := os.WriteFile(filepath.Join(remote, "mongod.log"), []byte("log"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	job := FSCopyJob{
+		Src:    SourceDir{Path: []byte(remote + "/"), Hostname: []byte("127.0.0.1"), Username: []byte("dcr")},
+		Dst:    DestDir{Path: []byte(t.TempDir())},
+		SSH:    ns,
+		Sudo:   ns.Sudo,
+		Dcrlog: ns.Dcrlog,
+	}
+	if err := job.StartCopyRemote(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(string(job.Dst.Path), "mongod.log")); err != nil || string(data) != "log" {
+		t.Fatalf("file not copied through sudo: %q %v", data, err)
+	}
+
+	missing := filepath.Join(remote, "missing")
+	paths, err := job.UnreadablePaths([]string{remote, missing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 || paths[0] != missing {
+		t.Fatalf("unexpected unreadable paths %q", paths)
+	}
+
+	data, _ := os.ReadFile(argsFile)
+	calls := string(data)
+	if strings.Count(calls, "-n -u mongod -- ") != 2 ||
+		!strings.HasPrefix(calls, "-n -u mongod -- "+os.Args[0]+"\n-n -u mongod -- sh -c for d;") {
+		t.Fatalf("unexpected sudo calls %q", calls)
+	}
+}
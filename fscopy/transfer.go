@@ -0,0 +1,205 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+
+	"dcrcli/budget"
+	"dcrcli/dcrconfig"
+	"dcrcli/dcrlogger"
+)
+
+const defaultTransferRetries = 3
+
+// PartialDir is the directory, next to the copied files, holding the files whose copy
+// was interrupted, so they are not mistaken for complete files and the next attempt
+// continues them. rsync and the native transport both use it.
+const PartialDir = ".rsync-partial"
+
+// rsyncIOTimeout makes rsync give up, and so be resumed, when no data moved for this long.
+const rsyncIOTimeout = 5 * time.Minute
+
+// lowPriorityRsyncPath runs rsync on the node at the lowest CPU and I/O priority.
+const lowPriorityRsyncPath = "nice -n 19 ionice -c 3 rsync"
+
+// lowPriorityBufferSize is the read size of the native transport in low priority mode: one
+// SFTP request at a time instead of many concurrent ones.
+const lowPriorityBufferSize = 32 * 1024
+
+// transferRetryDelay is the wait before resuming an interrupted copy, doubled for every
+// further attempt.
+var transferRetryDelay = 5 * time.Second
+
+// Transfer limits the load remote copies put on the nodes and their network, and resumes
+// copies interrupted by a network failure. The zero Transfer copies at full speed and does
+// not resume.
+type Transfer struct {
+	BandwidthLimit int64 // bytes per second, 0 is unlimited
+	LowPriority    bool
+	Retries        int  // times an interrupted copy is resumed
+	Stream         bool // archive remote files from a tar stream, see FSCopyJob.StreamFiles
+}
+
+// NewTransfer returns the transfer settings configured by c.
+func NewTransfer(c dcrconfig.TransferConfig) (Transfer, error) {
+	t := Transfer{LowPriority: c.LowPriority, Retries: c.Retries, Stream: c.Stream}
+	var err error
+	t.BandwidthLimit, err = budget.ParseSize(c.BandwidthLimit)
+	if err != nil {
+		return Transfer{}, fmt.Errorf("invalid transfer bandwidth_limit: %w", err)
+	}
+	switch {
+	case t.Retries == 0:
+		t.Retries = defaultTransferRetries
+	case t.Retries < 0:
+		t.Retries = 0
+	}
+	return t, nil
+}
+
+func (t Transfer) String() string {
+	limit := "unlimited"
+	if t.BandwidthLimit > 0 {
+		limit = budget.FormatSize(t.BandwidthLimit) + "/s"
+	}
+	return fmt.Sprintf(
+		"bandwidth %s, low priority %v, %d retries, stream %v", limit, t.LowPriority, t.Retries, t.Stream,
+	)
+}
+
+// rsyncOptions returns the rsync options applying the settings, running rsync on the node
+// through sudo unless sudo is nil.
+func (t Transfer) rsyncOptions(sudo *Sudo) []string {
+	var options []string
+	if t.BandwidthLimit > 0 {
+		// rsync counts in units of 1024 bytes per second
+		options = append(options, fmt.Sprintf("--bwlimit=%d", max(1, t.BandwidthLimit/1024)))
+	}
+	rsyncPath := "rsync"
+	if t.LowPriority {
+		rsyncPath = lowPriorityRsyncPath
+	}
+	if rsyncPath = sudo.rsyncPath(rsyncPath); rsyncPath != "rsync" {
+		options = append(options, "--rsync-path="+rsyncPath)
+	}
+	if t.Retries > 0 {
+		options = append(options,
+			"--partial-dir="+PartialDir,
+			fmt.Sprintf("--timeout=%d", int(rsyncIOTimeout.Seconds())),
+		)
+	}
+	return options
+}
+
+// runRsync runs rsync with args, prompting on the terminal if ssh needs to, and runs it
+// again when the connection failed; rsync then continues from the partial files.
+func (t Transfer) runRsync(args []string, dcrlog *dcrlogger.DCRLogger) error {
+	for attempt := 0; ; attempt++ {
+		cmd := exec.Command("rsync", args...)
+		// allow user to provide input if needed
+		cmd.Stdin = os.Stdin
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		dcrlog.Debug("starting rsync command")
+		fmt.Println("Please add your password for SSH connection:")
+		err := cmd.Run()
+		if err == nil || attempt >= t.Retries || !rsyncInterrupted(err) {
+			return err
+		}
+		delay := transferRetryDelay << attempt
+		dcrlog.Warn(fmt.Sprintf("rsync was interrupted (%s), resuming in %s", err, delay))
+		time.Sleep(delay)
+	}
+}
+
+// rsyncInterrupted reports whether rsync failed for lack of a working connection, from
+// its exit code: 10 socket I/O, 12 protocol data stream, 30 and 35 timeouts, 255 ssh.
+func rsyncInterrupted(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	switch exitErr.ExitCode() {
+	case 10, 12, 30, 35, 255:
+		return true
+	}
+	return false
+}
+
+// connectionLost reports whether err comes from a failed or broken SSH connection rather
+// than from the files being copied.
+func connectionLost(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, sftp.ErrSSHFxConnectionLost) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// rateLimiter delays reads so that on average no more than bytesPerSec pass.
+type rateLimiter struct {
+	bytesPerSec int64
+	start       time.Time
+	passed      int64
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &rateLimiter{bytesPerSec: bytesPerSec}
+}
+
+// reader returns r limited by l, or r itself for a nil l.
+func (l *rateLimiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{r: r, limiter: l}
+}
+
+func (l *rateLimiter) wait(n int) {
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+	l.passed += int64(n)
+	due := time.Duration(float64(l.passed) / float64(l.bytesPerSec) * float64(time.Second))
+	if d := due - time.Since(l.start); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+type limitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.limiter.wait(n)
+	return n, err
+}
@@ -0,0 +1,189 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fscopy
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dcrcli/dcrconfig"
+)
+
+func TestNewTransfer(t *testing.T) {
+	if _, err := NewTransfer(dcrconfig.TransferConfig{BandwidthLimit: "fast"}); err == nil {
+		t.Error("invalid bandwidth_limit should be rejected")
+	}
+	tr, err := NewTransfer(dcrconfig.TransferConfig{})
+	if err != nil || tr.Retries != defaultTransferRetries || tr.BandwidthLimit != 0 {
+		t.Fatalf("unexpected defaults %+v: %v", tr, err)
+	}
+	tr, _ = NewTransfer(dcrconfig.TransferConfig{Retries: -1})
+	if tr.Retries != 0 || len(tr.rsyncOptions(nil)) != 0 {
+		t.Fatalf("retries -1 should disable resuming: %+v %q", tr, tr.rsyncOptions(nil))
+	}
+
+	tr, err = NewTransfer(dcrconfig.TransferConfig{BandwidthLimit: "2MiB", LowPriority: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job := FSCopyJob{
+		Src:      SourceDir{Path: []byte("/data/diagnostic.data/"), Hostname: []byte("db1"), Username: []byte("ubuntu")},
+		Dst:      DestDir{Path: []byte("/tmp/out")},
+		Transfer: tr,
+	}
+	args, err := job.rsyncArgs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"-az", "--protect-args",
+		"--bwlimit=2048", "--rsync-path=nice -n 19 ionice -c 3 rsync", "--partial-dir=.rsync-partial", "--timeout=300",
+		"--progress", "--", "ubuntu@db1:/data/diagnostic.data/", "/tmp/out",
+	}
+	if strings.Join(args, "\x00") != strings.Join(want, "\x00") {
+		t.Fatalf("unexpected rsync arguments:\n got %q\nwant %q", args, want)
+	}
+}
+
+func TestRsyncResumesAfterConnectionFailure(t *testing.T) {
+	delay := transferRetryDelay
+	transferRetryDelay = time.Millisecond
+	t.Cleanup(func() { transferRetryDelay = delay })
+
+	// fails with the given exit codes in turn, then succeeds
+	dir := t.TempDir()
+	runs := filepath.Join(dir, "runs")
+	script := "#!/bin/sh\necho run >> '" + runs + "'\nn=$(wc -l < '" + runs + "')\n" +
+		"code=$(echo \"$RSYNC_CODES\" | cut -d, -f$n)\nexit ${code:-0}\n"
+	if err := os.WriteFile(filepath.Join(dir, "rsync"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	for _, tc := range []struct {
+		codes   string
+		retries int
+		runs    int
+		fails   bool
+	}{
+		{"255,12", 3, 3, false},
+		{"255,255", 1, 2, true},
+		{"23", 3, 1, true}, // some files could not be transferred: not a connection failure
+	} {
+		os.Remove(runs)
+		t.Setenv("RSYNC_CODES", tc.codes)
+		err := Transfer{Retries: tc.retries}.runRsync(nil, testLogger(t))
+		if (err != nil) != tc.fails {
+			t.Errorf("exit codes %s: unexpected error %v", tc.codes, err)
+		}
+		data, _ := os.ReadFile(runs)
+		if n := strings.Count(string(data), "run"); n != tc.runs {
+			t.Errorf("exit codes %s: rsync ran %d times, want %d", tc.codes, n, tc.runs)
+		}
+	}
+}
+
+// interruptedFS reads at most failAfter bytes of each file before failing like a broken
+// connection, and counts the bytes read.
+type interruptedFS struct {
+	localFS
+	failAfter int
+	read      *int
+}
+
+type interruptedFile struct {
+	*os.File
+	left int
+	read *int
+}
+
+func (f *interruptedFile) Read(p []byte) (int, error) {
+	if f.left <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if len(p) > f.left {
+		p = p[:f.left]
+	}
+	n, err := f.File.Read(p)
+	f.left -= n
+	*f.read += n
+	return n, err
+}
+
+func (i interruptedFS) Open(name string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.FromSlash(name))
+	if err != nil {
+		return nil, err
+	}
+	return &interruptedFile{File: f, left: i.failAfter, read: i.read}, nil
+}
+
+func TestCopierResumesInterruptedCopy(t *testing.T) {
+	src := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	writeTestFile(t, filepath.Join(src, "mongod.log.1"), string(data), time.Now().Add(-time.Hour))
+	writeTestFile(t, filepath.Join(src, "mongod.log.2"), "done", time.Now().Add(-time.Hour))
+	dst := t.TempDir()
+
+	read := 0
+	c := copier{src: interruptedFS{failAfter: 4000, read: &read}, resume: true, dcrlog: testLogger(t)}
+	if err := c.copyFile(filepath.Join(src, "mongod.log.2"), filepath.Join(dst, "mongod.log.2")); err != nil {
+		t.Fatal(err)
+	}
+	err := c.copyFile(filepath.Join(src, "mongod.log.1"), filepath.Join(dst, "mongod.log.1"))
+	if !connectionLost(err) {
+		t.Fatalf("expected a lost connection, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "mongod.log.1")); err == nil {
+		t.Fatal("an interrupted copy must not look complete")
+	}
+
+	read = 0
+	c.src = interruptedFS{failAfter: len(data), read: &read}
+	for _, name := range []string{"mongod.log.2", "mongod.log.1"} {
+		if err := c.copyFile(filepath.Join(src, name), filepath.Join(dst, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if read != len(data)-4000 {
+		t.Errorf("read %d bytes after resuming, want %d", read, len(data)-4000)
+	}
+	copied, _ := os.ReadFile(filepath.Join(dst, "mongod.log.1"))
+	if !bytes.Equal(copied, data) {
+		t.Fatal("resumed copy differs from the source")
+	}
+	if _, err := os.Stat(filepath.Join(dst, PartialDir)); err == nil {
+		t.Error("partial dir left behind after the copy completed")
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	if newRateLimiter(0) != nil {
+		t.Fatal("no limit should mean no limiter")
+	}
+	start := time.Now()
+	r := newRateLimiter(1000 * 1000).reader(bytes.NewReader(make([]byte, 200*1000)))
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Fatalf("200KB at 1MB/s took only %s", elapsed)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftdc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// BSON element types used by FTDC.
+const (
+	bsonDouble    = 0x01
+	bsonString    = 0x02
+	bsonDocument  = 0x03
+	bsonArray     = 0x04
+	bsonBinary    = 0x05
+	bsonUndefined = 0x06
+	bsonObjectID  = 0x07
+	bsonBool      = 0x08
+	bsonDate      = 0x09
+	bsonNull      = 0x0A
+	bsonRegex     = 0x0B
+	bsonDBPointer = 0x0C
+	bsonCode      = 0x0D
+	bsonSymbol    = 0x0E
+	bsonCodeScope = 0x0F
+	bsonInt32     = 0x10
+	bsonTimestamp = 0x11
+	bsonInt64     = 0x12
+	bsonDecimal   = 0x13
+	bsonMinKey    = 0xFF
+	bsonMaxKey    = 0x7F
+)
+
+var errBadBSON = errors.New("malformed BSON document")
+
+// element is one field of a BSON document. value holds the encoded value.
+type element struct {
+	typ   byte
+	key   string
+	value []byte
+}
+
+// documentBody checks the length prefix of doc and returns its elements without the
+// length and the trailing zero.
+func documentBody(doc []byte) ([]byte, error) {
+	if len(doc) < 5 {
+		return nil, errBadBSON
+	}
+	n := int(int32(binary.LittleEndian.Uint32(doc)))
+	if n < 5 || n > len(doc) || doc[n-1] != 0 {
+		return nil, errBadBSON
+	}
+	return doc[4 : n-1], nil
+}
+
+// valueSize returns the encoded size of a value of type typ at the start of b.
+func valueSize(typ byte, b []byte) (int, error) {
+	fixed := func(n int) (int, error) {
+		if len(b) < n {
+			return 0, errBadBSON
+		}
+		return n, nil
+	}
+	prefixed := func(extra int) (int, error) {
+		if len(b) < 4 {
+			return 0, errBadBSON
+		}
+		n := int(int32(binary.LittleEndian.Uint32(b))) + extra
+		if n < 4 || n > len(b) {
+			return 0, errBadBSON
+		}
+		return n, nil
+	}
+
+	switch typ {
+	case bsonDouble, bsonDate, bsonTimestamp, bsonInt64:
+		return fixed(8)
+	case bsonInt32:
+		return fixed(4)
+	case bsonBool:
+		return fixed(1)
+	case bsonDecimal:
+		return fixed(16)
+	case bsonObjectID:
+		return fixed(12)
+	case bsonUndefined, bsonNull, bsonMinKey, bsonMaxKey:
+		return 0, nil
+	case bsonString, bsonCode, bsonSymbol:
+		return prefixed(4)
+	case bsonDocument, bsonArray, bsonCodeScope:
+		return prefixed(0)
+	case bsonBinary:
+		return prefixed(5)
+	case bsonDBPointer:
+		n, err := prefixed(4)
+		if err != nil {
+			return 0, err
+		}
+		return fixed(n + 12)
+	case bsonRegex:
+		pattern := bytes.IndexByte(b, 0)
+		if pattern < 0 {
+			return 0, errBadBSON
+		}
+		options := bytes.IndexByte(b[pattern+1:], 0)
+		if options < 0 {
+			return 0, errBadBSON
+		}
+		return pattern + options + 2, nil
+	}
+	return 0, fmt.Errorf("unknown BSON type 0x%02x: %w", typ, errBadBSON)
+}
+
+// readElements calls fn for each element of doc in order.
+func readElements(doc []byte, fn func(e element) error) error {
+	body, err := documentBody(doc)
+	if err != nil {
+		return err
+	}
+	for len(body) > 0 {
+		typ := body[0]
+		end := bytes.IndexByte(body[1:], 0)
+		if end < 0 {
+			return errBadBSON
+		}
+		key := string(body[1 : 1+end])
+		body = body[2+end:]
+
+		n, err := valueSize(typ, body)
+		if err != nil {
+			return err
+		}
+		if err := fn(element{typ: typ, key: key, value: body[:n]}); err != nil {
+			return err
+		}
+		body = body[n:]
+	}
+	return nil
+}
+
+// binaryData returns the payload of a BSON binary value.
+func binaryData(value []byte) []byte {
+	return value[5:]
+}
+
+// int64Value returns the value of a numeric, bool or date element.
+func int64Value(e element) (int64, bool) {
+	switch e.typ {
+	case bsonDouble:
+		return int64(math.Float64frombits(binary.LittleEndian.Uint64(e.value))), true
+	case bsonInt32:
+		return int64(int32(binary.LittleEndian.Uint32(e.value))), true
+	case bsonInt64, bsonDate:
+		return int64(binary.LittleEndian.Uint64(e.value)), true
+	case bsonBool:
+		if e.value[0] != 0 {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// flattenMetrics appends the metrics of doc to keys and values in the order mongod
+// compresses them: numbers, bools and dates are one metric, timestamps two (seconds
+// and increment), sub-documents and arrays are walked depth first and everything else
+// is not a metric. Keys are the dotted path of the field.
+func flattenMetrics(doc []byte, prefix string, keys []string, values []int64) ([]string, []int64, error) {
+	err := readElements(doc, func(e element) error {
+		key := e.key
+		if prefix != "" {
+			key = prefix + "." + e.key
+		}
+		switch e.typ {
+		case bsonDocument, bsonArray:
+			var err error
+			keys, values, err = flattenMetrics(e.value, key, keys, values)
+			return err
+		case bsonTimestamp:
+			keys = append(keys, key+".t", key+".i")
+			values = append(values,
+				int64(binary.LittleEndian.Uint32(e.value[4:])),
+				int64(binary.LittleEndian.Uint32(e.value[:4])))
+		default:
+			if v, ok := int64Value(e); ok {
+				keys = append(keys, key)
+				values = append(values, v)
+			}
+		}
+		return nil
+	})
+	return keys, values, err
+}
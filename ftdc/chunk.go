@@ -0,0 +1,213 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ftdc decodes the metrics files mongod writes to diagnostic.data.
+//
+// A metrics file is a sequence of BSON documents. Metric chunks (type 1) hold a zlib
+// compressed reference sample followed by the deltas of every metric for the samples
+// after it, run-length encoded as varints.
+package ftdc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// FTDC document types.
+const (
+	typeMetadata         = 0
+	typeMetricChunk      = 1
+	typePeriodicMetadata = 2
+)
+
+// maxDocumentSize bounds the documents read from a file, so a corrupt length cannot make
+// the reader allocate gigabytes.
+const maxDocumentSize = 64 * 1024 * 1024
+
+// Chunk is one decoded metric chunk: every metric of the reference sample and its value
+// in each sample of the chunk.
+type Chunk struct {
+	ID      time.Time
+	Keys    []string
+	Samples int
+	values  [][]int64 // values[metric][sample]
+}
+
+// Index returns the position of key in Keys, or -1.
+func (c *Chunk) Index(key string) int {
+	for i, k := range c.Keys {
+		if k == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// Value returns metric i in sample s.
+func (c *Chunk) Value(i int, s int) int64 {
+	return c.values[i][s]
+}
+
+// ReadChunks reads the metrics file r and calls fn for each metric chunk in order.
+// Metadata documents are skipped.
+func ReadChunks(r io.Reader, fn func(c *Chunk) error) error {
+	br := bufio.NewReaderSize(r, 1024*1024)
+	for {
+		doc, err := readDocument(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var id time.Time
+		var typ int64 = -1
+		var data []byte
+		err = readElements(doc, func(e element) error {
+			switch {
+			case e.key == "_id" && e.typ == bsonDate:
+				ms, _ := int64Value(e)
+				id = time.UnixMilli(ms).UTC()
+			case e.key == "type":
+				typ, _ = int64Value(e)
+			case e.key == "data" && e.typ == bsonBinary:
+				data = binaryData(e.value)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if typ != typeMetricChunk {
+			continue
+		}
+		if data == nil {
+			return fmt.Errorf("metric chunk %s has no data", id.Format(time.RFC3339))
+		}
+
+		chunk, err := decodeChunk(data)
+		if err != nil {
+			return fmt.Errorf("metric chunk %s: %w", id.Format(time.RFC3339), err)
+		}
+		chunk.ID = id
+		if err := fn(chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// readDocument reads one length-prefixed BSON document.
+func readDocument(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("truncated document: %w", err)
+		}
+		return nil, err
+	}
+	n := int(int32(binary.LittleEndian.Uint32(size[:])))
+	if n < 5 || n > maxDocumentSize {
+		return nil, fmt.Errorf("invalid document length %d: %w", n, errBadBSON)
+	}
+	doc := make([]byte, n)
+	copy(doc, size[:])
+	if _, err := io.ReadFull(r, doc[4:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("truncated document: %w", err)
+	}
+	return doc, nil
+}
+
+// decodeChunk decompresses the data of a metric chunk: a little endian uint32 with the
+// uncompressed length, then zlib data holding the reference document, the number of
+// metrics, the number of deltas and the deltas.
+func decodeChunk(data []byte) (*Chunk, error) {
+	if len(data) < 4 {
+		return nil, errors.New("chunk data too short")
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data[4:]))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(io.LimitReader(zr, maxDocumentSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(raw) < 4 {
+		return nil, errBadBSON
+	}
+	refSize := int(int32(binary.LittleEndian.Uint32(raw)))
+	if refSize < 5 || refSize+8 > len(raw) {
+		return nil, errBadBSON
+	}
+	keys, ref, err := flattenMetrics(raw[:refSize], "", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	rest := raw[refSize:]
+	metrics := int(binary.LittleEndian.Uint32(rest))
+	deltas := int(binary.LittleEndian.Uint32(rest[4:]))
+	if metrics != len(keys) {
+		return nil, fmt.Errorf("chunk has %d metrics but its reference sample has %d", metrics, len(keys))
+	}
+
+	values, err := decodeDeltas(rest[8:], ref, deltas)
+	if err != nil {
+		return nil, err
+	}
+	return &Chunk{Keys: keys, Samples: deltas + 1, values: values}, nil
+}
+
+// decodeDeltas reads the delta stream, metric by metric. A zero delta is followed by the
+// number of further zero deltas.
+func decodeDeltas(stream []byte, ref []int64, deltas int) ([][]int64, error) {
+	r := bytes.NewReader(stream)
+	values := make([][]int64, len(ref))
+	zeros := uint64(0)
+	for i := range ref {
+		values[i] = make([]int64, deltas+1)
+		values[i][0] = ref[i]
+		for j := 1; j <= deltas; j++ {
+			var delta uint64
+			if zeros > 0 {
+				zeros--
+			} else {
+				var err error
+				delta, err = binary.ReadUvarint(r)
+				if err != nil {
+					return nil, fmt.Errorf("reading deltas: %w", err)
+				}
+				if delta == 0 {
+					zeros, err = binary.ReadUvarint(r)
+					if err != nil {
+						return nil, fmt.Errorf("reading deltas: %w", err)
+					}
+				}
+			}
+			values[i][j] = int64(uint64(values[i][j-1]) + delta)
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,338 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftdc
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Column is an exported metric. Counters only go up; the summary reports their rate.
+type Column struct {
+	Name    string
+	Keys    []string // FTDC keys to read, the first one present in a chunk is used
+	Counter bool
+}
+
+// LagColumn is derived from replSetGetStatus: the optime of the primary minus the optime
+// of this node, in seconds.
+const LagColumn = "replication.lagSeconds"
+
+// DefaultColumns are the metrics exported by default: opcounters, WiredTiger cache
+// usage, replication lag and read/write tickets.
+func DefaultColumns() []Column {
+	cols := []Column{}
+	for _, op := range []string{"insert", "query", "update", "delete", "getmore", "command"} {
+		cols = append(cols, Column{
+			Name:    "opcounters." + op,
+			Keys:    []string{"serverStatus.opcounters." + op},
+			Counter: true,
+		})
+	}
+	for _, stat := range [][2]string{
+		{"cache.bytesInCache", "bytes currently in the cache"},
+		{"cache.dirtyBytes", "tracked dirty bytes in the cache"},
+		{"cache.maxBytes", "maximum bytes configured"},
+	} {
+		cols = append(cols, Column{Name: stat[0], Keys: []string{"serverStatus.wiredTiger.cache." + stat[1]}})
+	}
+	cols = append(cols, Column{Name: LagColumn})
+	for _, rw := range []string{"read", "write"} {
+		for _, stat := range []string{"out", "available"} {
+			cols = append(cols, Column{
+				Name: "tickets." + rw + "." + stat,
+				Keys: []string{
+					"serverStatus.queues.execution." + rw + "." + stat,
+					"serverStatus.wiredTiger.concurrentTransactions." + rw + "." + stat,
+				},
+			})
+		}
+	}
+	return cols
+}
+
+// Row is one sample. Values follow the export columns; NaN means not collected.
+type Row struct {
+	Time   time.Time
+	Values []float64
+}
+
+// Export collects the selected columns from metric chunks.
+type Export struct {
+	Columns []Column
+	Rows    []Row
+	Chunks  int
+}
+
+// NewExport starts an export of the given columns.
+func NewExport(columns []Column) *Export {
+	return &Export{Columns: columns}
+}
+
+// sampleTimeKeys hold the time of a sample, newest layout first.
+var sampleTimeKeys = []string{"start", "serverStatus.localTime"}
+
+// member is where the replSetGetStatus fields of one member are in a chunk.
+type member struct {
+	state, optime, self int
+}
+
+func replMembers(c *Chunk) []member {
+	var members []member
+	for n := 0; ; n++ {
+		prefix := "replSetGetStatus.members." + strconv.Itoa(n) + "."
+		m := member{
+			state:  c.Index(prefix + "state"),
+			optime: c.Index(prefix + "optimeDate"),
+			self:   c.Index(prefix + "self"),
+		}
+		if m.state < 0 {
+			return members
+		}
+		members = append(members, m)
+	}
+}
+
+// replLag returns the replication lag of the node in sample s.
+func replLag(c *Chunk, members []member, s int) float64 {
+	primary, self := int64(-1), int64(-1)
+	for _, m := range members {
+		if m.optime < 0 {
+			continue
+		}
+		if c.Value(m.state, s) == 1 {
+			primary = c.Value(m.optime, s)
+		}
+		if m.self >= 0 && c.Value(m.self, s) == 1 {
+			self = c.Value(m.optime, s)
+		}
+	}
+	if primary < 0 || self < 0 {
+		return math.NaN()
+	}
+	return math.Max(float64(primary-self)/1000, 0)
+}
+
+// Add takes the samples of a chunk.
+func (e *Export) Add(c *Chunk) error {
+	e.Chunks++
+	timeIndex := -1
+	for _, key := range sampleTimeKeys {
+		if timeIndex = c.Index(key); timeIndex >= 0 {
+			break
+		}
+	}
+
+	indexes := make([]int, len(e.Columns))
+	var members []member
+	for i, col := range e.Columns {
+		indexes[i] = -1
+		if col.Name == LagColumn {
+			members = replMembers(c)
+			continue
+		}
+		for _, key := range col.Keys {
+			if indexes[i] = c.Index(key); indexes[i] >= 0 {
+				break
+			}
+		}
+	}
+
+	for s := 0; s < c.Samples; s++ {
+		row := Row{Values: make([]float64, len(e.Columns))}
+		if timeIndex >= 0 {
+			row.Time = time.UnixMilli(c.Value(timeIndex, s)).UTC()
+		} else {
+			// without a sample time assume one sample per second from the chunk id
+			row.Time = c.ID.Add(time.Duration(s) * time.Second)
+		}
+		for i, col := range e.Columns {
+			switch {
+			case col.Name == LagColumn:
+				row.Values[i] = replLag(c, members, s)
+			case indexes[i] >= 0:
+				row.Values[i] = float64(c.Value(indexes[i], s))
+			default:
+				row.Values[i] = math.NaN()
+			}
+		}
+		e.Rows = append(e.Rows, row)
+	}
+	return nil
+}
+
+// Sort orders the rows by time, for files read out of order.
+func (e *Export) Sort() {
+	sort.SliceStable(e.Rows, func(a, b int) bool { return e.Rows[a].Time.Before(e.Rows[b].Time) })
+}
+
+func formatValue(v float64) string {
+	if math.IsNaN(v) {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// WriteCSV writes one row per sample with a time column and one column per metric.
+func (e *Export) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := []string{"time"}
+	for _, col := range e.Columns {
+		header = append(header, col.Name)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(header))
+	for _, row := range e.Rows {
+		record[0] = row.Time.Format(time.RFC3339)
+		for i, v := range row.Values {
+			record[i+1] = formatValue(v)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Series is one metric as a time series in the JSON export.
+type Series struct {
+	Name    string       `json:"name"`
+	Counter bool         `json:"counter"`
+	Points  [][2]float64 `json:"points"` // [unix seconds, value]
+}
+
+// WriteJSON writes one time series per metric. Samples without a value are left out.
+func (e *Export) WriteJSON(w io.Writer) error {
+	series := make([]Series, len(e.Columns))
+	for i, col := range e.Columns {
+		series[i] = Series{Name: col.Name, Counter: col.Counter, Points: [][2]float64{}}
+		for _, row := range e.Rows {
+			if v := row.Values[i]; !math.IsNaN(v) {
+				series[i].Points = append(series[i].Points, [2]float64{float64(row.Time.Unix()), v})
+			}
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Series []Series `json:"series"`
+	}{series})
+}
+
+// stats summarises one column.
+type stats struct {
+	n             int
+	min, max, sum float64
+}
+
+func (s *stats) add(v float64) {
+	if s.n == 0 || v < s.min {
+		s.min = v
+	}
+	if s.n == 0 || v > s.max {
+		s.max = v
+	}
+	s.n++
+	s.sum += v
+}
+
+// WriteSummary prints the time range of the samples and, per metric, the average and
+// peak rate per second of counters and the min/avg/max of the other metrics.
+func (e *Export) WriteSummary(w io.Writer) error {
+	var b strings.Builder
+	if len(e.Rows) == 0 {
+		b.WriteString("No FTDC samples found.\n")
+		_, err := io.WriteString(w, b.String())
+		return err
+	}
+	first, last := e.Rows[0].Time, e.Rows[len(e.Rows)-1].Time
+	fmt.Fprintf(&b, "%d samples in %d chunks from %s to %s (%s)\n",
+		len(e.Rows), e.Chunks, first.Format(time.RFC3339), last.Format(time.RFC3339), last.Sub(first).Round(time.Second))
+
+	for i, col := range e.Columns {
+		var s stats
+		for r, row := range e.Rows {
+			v := row.Values[i]
+			if !col.Counter {
+				if !math.IsNaN(v) {
+					s.add(v)
+				}
+				continue
+			}
+			if r == 0 {
+				continue
+			}
+			prev := e.Rows[r-1]
+			seconds := row.Time.Sub(prev.Time).Seconds()
+			// skip gaps between files and counter resets after a restart
+			if math.IsNaN(v) || math.IsNaN(prev.Values[i]) || v < prev.Values[i] || seconds <= 0 {
+				continue
+			}
+			s.add((v - prev.Values[i]) / seconds)
+		}
+
+		switch {
+		case s.n == 0:
+			fmt.Fprintf(&b, "  %-24s not collected\n", col.Name)
+		case col.Counter:
+			fmt.Fprintf(&b, "  %-24s avg %.1f/s  peak %.1f/s\n", col.Name, s.sum/float64(s.n), s.max)
+		default:
+			fmt.Fprintf(&b, "  %-24s min %s  avg %s  max %s\n", col.Name,
+				formatSummaryValue(col.Name, s.min), formatSummaryValue(col.Name, s.sum/float64(s.n)),
+				formatSummaryValue(col.Name, s.max))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatSummaryValue(name string, v float64) string {
+	if strings.HasPrefix(name, "cache.") {
+		return fmt.Sprintf("%.1fMB", v/1e6)
+	}
+	return strconv.FormatFloat(math.Round(v*10)/10, 'f', -1, 64)
+}
+
+// SelectColumns keeps the columns whose name starts with one of prefixes, e.g.
+// "opcounters" or "tickets.write". An empty list keeps every column.
+func SelectColumns(columns []Column, prefixes []string) ([]Column, error) {
+	if len(prefixes) == 0 {
+		return columns, nil
+	}
+	var selected []Column
+	for _, prefix := range prefixes {
+		found := false
+		for _, col := range columns {
+			if col.Name == prefix || strings.HasPrefix(col.Name, prefix+".") {
+				selected = append(selected, col)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown FTDC metric %q", prefix)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,140 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftdc
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNoMetricsFiles is returned by ReadPath when path has no metrics files.
+var ErrNoMetricsFiles = errors.New("no FTDC metrics files found")
+
+func isMetricsFile(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), "metrics.")
+}
+
+// ReadPath reads the metric chunks of an ftdcarchive.tar.gz, a diagnostic.data directory
+// or a single metrics file and returns the names of the files read. A metrics file that
+// ends in a partly written chunk, such as metrics.interim, is read up to that chunk.
+func ReadPath(path string, fn func(c *Chunk) error) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	switch {
+	case fi.IsDir():
+		files, err = readDir(path, fn)
+	case strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz"):
+		files, err = readTarGz(path, fn)
+	default:
+		files = []string{filepath.Base(path)}
+		err = readFile(path, fn)
+	}
+	if err == nil && len(files) == 0 {
+		err = ErrNoMetricsFiles
+	}
+	return files, err
+}
+
+func readFile(path string, fn func(c *Chunk) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readMetrics(filepath.Base(path), f, fn)
+}
+
+// readMetrics reads one metrics file, tolerating a truncated last document.
+func readMetrics(name string, r io.Reader, fn func(c *Chunk) error) error {
+	err := ReadChunks(r, fn)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+func readDir(dir string, fn func(c *Chunk) error) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() && isMetricsFile(path) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	files := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if err := readFile(path, fn); err != nil {
+			return files, err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files = append(files, rel)
+	}
+	return files, nil
+}
+
+func readTarGz(path string, fn func(c *Chunk) error) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+
+	var files []string
+	tr := tar.NewReader(gzr)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, err
+		}
+		if header.Typeflag != tar.TypeReg || !isMetricsFile(header.Name) {
+			continue
+		}
+		if err := readMetrics(header.Name, tr, fn); err != nil {
+			return files, err
+		}
+		files = append(files, header.Name)
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftdc
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// field is a BSON element for building test documents.
+type field struct {
+	key   string
+	typ   byte
+	value []byte
+}
+
+func bsonDoc(fields ...field) []byte {
+	var body bytes.Buffer
+	for _, f := range fields {
+		body.WriteByte(f.typ)
+		body.WriteString(f.key)
+		body.WriteByte(0)
+		body.Write(f.value)
+	}
+	body.WriteByte(0)
+	doc := binary.LittleEndian.AppendUint32(nil, uint32(body.Len()+4))
+	return append(doc, body.Bytes()...)
+}
+
+func i64(key string, v int64) field {
+	return field{key, bsonInt64, binary.LittleEndian.AppendUint64(nil, uint64(v))}
+}
+
+func i32(key string, v int32) field {
+	return field{key, bsonInt32, binary.LittleEndian.AppendUint32(nil, uint32(v))}
+}
+
+func date(key string, t time.Time) field {
+	return field{key, bsonDate, binary.LittleEndian.AppendUint64(nil, uint64(t.UnixMilli()))}
+}
+
+func boolean(key string, v bool) field {
+	if v {
+		return field{key, bsonBool, []byte{1}}
+	}
+	return field{key, bsonBool, []byte{0}}
+}
+
+func str(key string, v string) field {
+	value := binary.LittleEndian.AppendUint32(nil, uint32(len(v)+1))
+	return field{key, bsonString, append(append(value, v...), 0)}
+}
+
+func sub(key string, fields ...field) field {
+	return field{key, bsonDocument, bsonDoc(fields...)}
+}
+
+// encodeChunk compresses samples the way mongod does. Every sample must flatten to the
+// same metrics as the first one.
+func encodeChunk(t *testing.T, id time.Time, samples [][]byte) []byte {
+	t.Helper()
+	_, ref, err := flattenMetrics(samples[0], "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make([][]int64, len(samples))
+	for s, sample := range samples {
+		if _, values[s], err = flattenMetrics(sample, "", nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var raw bytes.Buffer
+	raw.Write(samples[0])
+	raw.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(ref))))
+	raw.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(samples)-1)))
+	zeros := uint64(0)
+	flush := func() {
+		if zeros > 0 {
+			raw.Write(binary.AppendUvarint(nil, 0))
+			raw.Write(binary.AppendUvarint(nil, zeros-1))
+			zeros = 0
+		}
+	}
+	for i := range ref {
+		for s := 1; s < len(samples); s++ {
+			delta := uint64(values[s][i] - values[s-1][i])
+			if delta == 0 {
+				zeros++
+				continue
+			}
+			flush()
+			raw.Write(binary.AppendUvarint(nil, delta))
+		}
+	}
+	flush()
+
+	var compressed bytes.Buffer
+	compressed.Write(binary.LittleEndian.AppendUint32(nil, uint32(raw.Len())))
+	zw := zlib.NewWriter(&compressed)
+	zw.Write(raw.Bytes())
+	zw.Close()
+
+	data := binary.LittleEndian.AppendUint32(nil, uint32(compressed.Len()))
+	data = append(append(data, 0), compressed.Bytes()...)
+	return bsonDoc(date("_id", id), i32("type", typeMetricChunk), field{"data", bsonBinary, data})
+}
+
+func sample(start time.Time, inserts int64, cacheBytes int64, primaryOptime time.Time, selfOptime time.Time) []byte {
+	return bsonDoc(
+		date("start", start),
+		sub("serverStatus",
+			str("host", "db1:27017"),
+			sub("opcounters", i64("insert", inserts), i64("query", 5)),
+			sub("wiredTiger",
+				sub("cache", i64("bytes currently in the cache", cacheBytes)),
+				sub("concurrentTransactions",
+					sub("read", i32("out", 1), i32("available", 127)),
+					sub("write", i32("out", 2), i32("available", 126)),
+				),
+			),
+		),
+		sub("replSetGetStatus",
+			field{"members", bsonArray, bsonDoc(
+				sub("0", i32("state", 1), date("optimeDate", primaryOptime), boolean("self", false)),
+				sub("1", i32("state", 2), date("optimeDate", selfOptime), boolean("self", true)),
+			)},
+		),
+	)
+}
+
+func writeMetricsFile(t *testing.T, dir string) string {
+	t.Helper()
+	start := time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC)
+	var samples [][]byte
+	for s := 0; s < 5; s++ {
+		now := start.Add(time.Duration(s) * time.Second)
+		samples = append(samples, sample(now, int64(100+10*s), 1_000_000, now, now.Add(-time.Duration(s)*time.Second)))
+	}
+
+	var file bytes.Buffer
+	file.Write(bsonDoc(date("_id", start), i32("type", typeMetadata), sub("doc", str("host", "db1"))))
+	file.Write(encodeChunk(t, start, samples))
+	// a partly written chunk at the end, as in metrics.interim
+	file.Write(encodeChunk(t, start.Add(time.Minute), samples[:2])[:20])
+
+	path := filepath.Join(dir, "metrics.2024-03-09T10-00-00Z-00000")
+	if err := os.WriteFile(path, file.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadChunksDecodesDeltas(t *testing.T) {
+	path := writeMetricsFile(t, t.TempDir())
+	var chunks []*Chunk
+	files, err := ReadPath(path, func(c *Chunk) error {
+		chunks = append(chunks, c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || len(chunks) != 1 || chunks[0].Samples != 5 {
+		t.Fatalf("unexpected chunks: files %v, %d chunks", files, len(chunks))
+	}
+
+	c := chunks[0]
+	inserts := c.Index("serverStatus.opcounters.insert")
+	if inserts < 0 || c.Index("serverStatus.host") >= 0 {
+		t.Fatalf("unexpected keys: %v", c.Keys)
+	}
+	for s := 0; s < c.Samples; s++ {
+		if got := c.Value(inserts, s); got != int64(100+10*s) {
+			t.Fatalf("sample %d: inserts %d", s, got)
+		}
+	}
+}
+
+func TestExportColumnsAndSummary(t *testing.T) {
+	dir := t.TempDir()
+	writeMetricsFile(t, dir)
+
+	columns, err := SelectColumns(DefaultColumns(), []string{"opcounters.insert", "cache.bytesInCache", "replication", "tickets.write"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	export := NewExport(columns)
+	if _, err := ReadPath(dir, export.Add); err != nil {
+		t.Fatal(err)
+	}
+	if len(export.Rows) != 5 {
+		t.Fatalf("expected 5 rows, got %d", len(export.Rows))
+	}
+	last := export.Rows[4].Values
+	if last[0] != 140 || last[1] != 1_000_000 || last[2] != 4 || last[3] != 2 || last[4] != 126 {
+		t.Fatalf("unexpected last row: %v", last)
+	}
+
+	var csvOut bytes.Buffer
+	if err := export.WriteCSV(&csvOut); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(csvOut.String()), "\n")
+	if lines[0] != "time,opcounters.insert,cache.bytesInCache,replication.lagSeconds,tickets.write.out,tickets.write.available" ||
+		lines[5] != "2024-03-09T10:00:04Z,140,1000000,4,2,126" {
+		t.Fatalf("unexpected CSV:\n%s", csvOut.String())
+	}
+
+	var jsonOut bytes.Buffer
+	if err := export.WriteJSON(&jsonOut); err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct{ Series []Series }
+	if err := json.Unmarshal(jsonOut.Bytes(), &decoded); err != nil || len(decoded.Series[0].Points) != 5 {
+		t.Fatalf("unexpected JSON: %s (%v)", jsonOut.String(), err)
+	}
+
+	var summary bytes.Buffer
+	if err := export.WriteSummary(&summary); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(summary.String(), "avg 10.0/s  peak 10.0/s") {
+		t.Fatalf("unexpected summary:\n%s", summary.String())
+	}
+
+	if _, err := SelectColumns(DefaultColumns(), []string{"nope"}); err == nil {
+		t.Fatal("unknown metric should be rejected")
+	}
+}
+
+func TestReplLagWithoutPrimary(t *testing.T) {
+	if v := replLag(&Chunk{}, nil, 0); !math.IsNaN(v) {
+		t.Fatalf("expected no lag without members, got %v", v)
+	}
+}
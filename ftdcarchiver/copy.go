@@ -0,0 +1,97 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftdcarchiver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dcrcli/fscopy"
+	"dcrcli/timewindow"
+)
+
+// copyDiagnosticDir copies the metrics files of diagDir overlapping the window into
+// diagnostic.data in tempDir with job, for local and remote nodes alike, so
+// mongos.diagnostic.data is archived like mongod's. list returns the files in diagDir;
+// when it fails, the whole dir is copied. Files that cannot be read are logged and the
+// others are archived.
+func copyDiagnosticDir(
+	job *fscopy.FSCopyJob,
+	diagDir string,
+	window timewindow.Window,
+	list func() ([]metricsFile, error),
+	tempDir string,
+) error {
+	err := copyDiagnosticFiles(job, diagDir, window, list, tempDir)
+	var unreadable *fscopy.UnreadableError
+	if errors.As(err, &unreadable) {
+		job.Dcrlog.Warn(fmt.Sprintf("archiving the readable FTDC files only: %s", err))
+		return nil
+	}
+	return err
+}
+
+func copyDiagnosticFiles(
+	job *fscopy.FSCopyJob,
+	diagDir string,
+	window timewindow.Window,
+	list func() ([]metricsFile, error),
+	tempDir string,
+) error {
+	dst := filepath.Join(tempDir, archiveDiagnosticDirName)
+	if !window.IsZero() {
+		files, err := list()
+		if err == nil {
+			return copyWindow(job, diagDir, window, files, dst)
+		}
+		job.Dcrlog.Warn(fmt.Sprintf("cannot list %s, copying every FTDC file: %s", diagDir, err))
+	}
+
+	job.Src.Path = []byte(diagDir + "/")
+	job.Dst.Path = []byte(dst)
+	return job.StartCopy()
+}
+
+// copyWindow copies only the metrics files overlapping the window.
+func copyWindow(job *fscopy.FSCopyJob, diagDir string, window timewindow.Window, files []metricsFile, dst string) error {
+	selected := selectMetricsFiles(files, window, time.Now())
+	job.Dcrlog.Info(
+		fmt.Sprintf("time window %s selects %d of %d FTDC file(s)", window, len(selected), len(files)),
+	)
+
+	patterns := make([]string, 0, len(selected))
+	for _, f := range selected {
+		patterns = append(patterns, f.Name)
+	}
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	err := os.MkdirAll(dst, 0755)
+	if err != nil {
+		return err
+	}
+	job.Src.Path = []byte(diagDir)
+	job.Dst.Path = []byte(dst)
+	patternJob := fscopy.FSCopyJobWithPattern{
+		CopyJobDetails: job,
+		Patterns:       patterns,
+		Dcrlog:         job.Dcrlog,
+	}
+	return patternJob.StartCopyWithPattern()
+}
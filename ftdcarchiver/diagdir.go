@@ -0,0 +1,74 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftdcarchiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"dcrcli/mongosh"
+)
+
+// archiveDiagnosticDirName is the dir name FTDC files of remote nodes are archived under,
+// whatever the dir is called on the node.
+const archiveDiagnosticDirName = "diagnostic.data"
+
+// mongosDiagnosticDataDirPath is where mongos writes FTDC when
+// diagnosticDataCollectionDirectoryPath is not set: next to its log file, with the
+// extension replaced, e.g. /var/log/mongodb/mongos.log -> /var/log/mongodb/mongos.diagnostic.data
+func mongosDiagnosticDataDirPath(logPath string) string {
+	return strings.TrimSuffix(logPath, filepath.Ext(logPath)) + "." + archiveDiagnosticDirName
+}
+
+// isUnsetParameter reports whether the shell printed no value for a parameter.
+func isUnsetParameter(value string) bool {
+	return value == "" || value == "undefined" || value == "null"
+}
+
+// diagnosticDataDirPath returns the FTDC dir of the node. mongos often has no
+// diagnosticDataCollectionDirectoryPath, in which case the dir is derived from the log path.
+func diagnosticDataDirPath(mongo *mongosh.CaptureGetMongoData) (string, error) {
+	err := mongo.RunGetCommandDiagnosticDataCollectionDirectoryPath()
+	if err != nil {
+		return "", fmt.Errorf("Error in getDiagnosticDataDirPath: %w", err)
+	}
+	path := trimQuote(mongo.Getparsedjsonoutput.String())
+	if !isUnsetParameter(path) {
+		return path, nil
+	}
+
+	err = mongo.RunGetMongoDLogDetails()
+	if err != nil {
+		return "", fmt.Errorf("Error in getDiagnosticDataDirPath: %w", err)
+	}
+	var systemLog struct {
+		Destination string `json:"destination"`
+		Path        string `json:"path"`
+	}
+	err = json.Unmarshal(mongo.Getparsedjsonoutput.Bytes(), &systemLog)
+	if err != nil || systemLog.Destination != "file" || systemLog.Path == "" {
+		// without a log file mongos does not write FTDC
+		return "", nil
+	}
+	if !filepath.IsAbs(systemLog.Path) {
+		return "", fmt.Errorf(
+			"diagnosticDataCollectionDirectoryPath is empty and log path %s is relative: %w",
+			systemLog.Path, ErrFTDCUnavailable,
+		)
+	}
+	return mongosDiagnosticDataDirPath(systemLog.Path), nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftdcarchiver
+
+import "testing"
+
+func TestMongosDiagnosticDataDirPath(t *testing.T) {
+	for logPath, want := range map[string]string{
+		"/var/log/mongodb/mongos.log": "/var/log/mongodb/mongos.diagnostic.data",
+		"/var/log/mongodb/mongos":     "/var/log/mongodb/mongos.diagnostic.data",
+		"/data/router/router.1.log":   "/data/router/router.1.diagnostic.data",
+	} {
+		if got := mongosDiagnosticDataDirPath(logPath); got != want {
+			t.Errorf("mongosDiagnosticDataDirPath(%q) = %q, want %q", logPath, got, want)
+		}
+	}
+	for _, value := range []string{"", "undefined", "null"} {
+		if !isUnsetParameter(value) {
+			t.Errorf("%q should count as unset", value)
+		}
+	}
+}
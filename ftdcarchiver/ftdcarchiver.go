@@ -15,31 +15,61 @@
 package ftdcarchiver
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 
-	"dcrcli/archiver"
+	"dcrcli/budget"
 	"dcrcli/dcroutdir"
+	"dcrcli/fscopy"
 	"dcrcli/mongosh"
+	"dcrcli/timewindow"
 )
 
+// ErrFTDCUnavailable is returned when the node has FTDC disabled or its diagnostic.data
+// directory cannot be read. Callers can fall back to a MetricsSampler.
+var ErrFTDCUnavailable = errors.New("FTDC data is not available for this node")
+
 type FTDCarchive struct {
 	Mongo             mongosh.CaptureGetMongoData
 	DiagnosticDirPath string
 	FTDCArchiveFile   *os.File
 	Outputdir         *dcroutdir.DCROutputDir
+	Window            timewindow.Window // zero archives every metrics file
+	SizeBudget        *budget.Set       // nil archives every metrics file
+	TempOutputdir     *dcroutdir.DCROutputDir
+	CopyJob           *fscopy.FSCopyJob // local copy of diagnostic.data to TempOutputdir
 }
 
 func (fa *FTDCarchive) getDiagnosticDataDirPath() error {
-	err := fa.Mongo.RunGetCommandDiagnosticDataCollectionDirectoryPath()
+	var err error
+	fa.DiagnosticDirPath, err = diagnosticDataDirPath(&fa.Mongo)
+	return err
+}
+
+// checkFTDCEnabled reports ErrFTDCUnavailable when diagnosticDataCollectionEnabled is false.
+// Nodes that cannot answer the getParameter are assumed to have FTDC enabled.
+func (fa *FTDCarchive) checkFTDCEnabled() error {
+	enabled, err := isFTDCEnabled(&fa.Mongo)
 	if err != nil {
-		return fmt.Errorf("Error in getDiagnosticDataDirPath: %w", err)
+		return nil
 	}
+	if !enabled {
+		return fmt.Errorf("diagnosticDataCollectionEnabled is false: %w", ErrFTDCUnavailable)
+	}
+	return nil
+}
 
-	fa.DiagnosticDirPath = fa.Mongo.Getparsedjsonoutput.String()
-	fa.DiagnosticDirPath = trimQuote(fa.DiagnosticDirPath)
-
+// checkDiagnosticDirReadable reports ErrFTDCUnavailable when the diagnostic.data directory
+// is unknown or cannot be listed from this host.
+func (fa *FTDCarchive) checkDiagnosticDirReadable() error {
+	if fa.DiagnosticDirPath == "" {
+		return fmt.Errorf("diagnosticDataCollectionDirectoryPath is empty: %w", ErrFTDCUnavailable)
+	}
+	if _, err := os.ReadDir(fa.DiagnosticDirPath); err != nil {
+		return fmt.Errorf("cannot read %s: %w: %w", fa.DiagnosticDirPath, ErrFTDCUnavailable, err)
+	}
 	return nil
 }
 
@@ -52,13 +82,19 @@ func (fa *FTDCarchive) createFTDCTarArchiveFile() error {
 	return nil
 }
 
+// copyFTDCfilesToTemp copies the metrics files to the temp dir like for remote nodes, so
+// the archive is made from copies mongod does not write to and has the same layout.
+func (fa *FTDCarchive) copyFTDCfilesToTemp() error {
+	list := func() ([]metricsFile, error) { return listMetricsFiles(fa.DiagnosticDirPath) }
+	err := copyDiagnosticDir(fa.CopyJob, fa.DiagnosticDirPath, fa.Window, list, fa.TempOutputdir.Path())
+	if err != nil {
+		return fmt.Errorf("Error in copyFTDCfilesToTemp %w: %w", ErrFTDCUnavailable, err)
+	}
+	return nil
+}
+
 func (fa *FTDCarchive) archiveMetricsFiles() error {
-	metricsFileSearchPatternString := `^metrics.*`
-	err := archiver.TarWithPatternMatch(
-		fa.DiagnosticDirPath,
-		metricsFileSearchPatternString,
-		fa.FTDCArchiveFile,
-	)
+	err := archiveMetricsFileSet(fa.TempOutputdir.Path(), fa.Window, fa.SizeBudget, fa.FTDCArchiveFile)
 	if err != nil {
 		return fmt.Errorf("Error in archiveMetricsFiles %w", err)
 	}
@@ -66,7 +102,7 @@ func (fa *FTDCarchive) archiveMetricsFiles() error {
 }
 
 func (fa *FTDCarchive) Start() error {
-	err := fa.createFTDCTarArchiveFile()
+	err := fa.checkFTDCEnabled()
 	if err != nil {
 		return fmt.Errorf("Error in FTDCarchive.Start: %w", err)
 	}
@@ -76,6 +112,21 @@ func (fa *FTDCarchive) Start() error {
 		return fmt.Errorf("Error in FTDCarchive.Start: %w", err)
 	}
 
+	err = fa.checkDiagnosticDirReadable()
+	if err != nil {
+		return fmt.Errorf("Error in FTDCarchive.Start: %w", err)
+	}
+
+	err = fa.copyFTDCfilesToTemp()
+	if err != nil {
+		return fmt.Errorf("Error in FTDCarchive.Start: %w", err)
+	}
+
+	err = fa.createFTDCTarArchiveFile()
+	if err != nil {
+		return fmt.Errorf("Error in FTDCarchive.Start: %w", err)
+	}
+
 	err = fa.archiveMetricsFiles()
 	if err != nil {
 		return fmt.Errorf("Error in FTDCarchive.Start: %w", err)
@@ -84,6 +135,14 @@ func (fa *FTDCarchive) Start() error {
 	return nil
 }
 
+func isFTDCEnabled(mongo *mongosh.CaptureGetMongoData) (bool, error) {
+	err := mongo.RunGetCommandDiagnosticDataCollectionEnabled()
+	if err != nil {
+		return true, err
+	}
+	return trimQuote(mongo.Getparsedjsonoutput.String()) != "false", nil
+}
+
 func trimQuote(s string) string {
 	s = strings.TrimSpace(s)
 	if len(s) > 0 && s[0] == '"' {
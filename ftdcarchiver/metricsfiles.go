@@ -0,0 +1,237 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftdcarchiver
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"dcrcli/archiver"
+	"dcrcli/budget"
+	"dcrcli/fscopy"
+	"dcrcli/timewindow"
+)
+
+// metricsFile is an FTDC file found under a diagnostic.data dir.
+type metricsFile struct {
+	Name string // path relative to the searched dir, used inside the archive
+	Path string
+	Size int64
+	Time time.Time
+}
+
+// metricsFileTimeLayout is the start time mongod puts in FTDC file names,
+// e.g. metrics.2024-03-09T10-00-00Z-00000
+const metricsFileTimeLayout = "2006-01-02T15-04-05Z"
+
+// metricsFileTime reads the start time of an FTDC file from its name. metrics.interim
+// and names without a time fall back to the modification time.
+func metricsFileTime(name string, modTime time.Time) time.Time {
+	suffix := strings.TrimPrefix(name, "metrics.")
+	if len(suffix) >= len(metricsFileTimeLayout) {
+		t, err := time.Parse(metricsFileTimeLayout, suffix[:len(metricsFileTimeLayout)])
+		if err == nil {
+			return t
+		}
+	}
+	return modTime
+}
+
+// listMetricsFiles walks dir for metrics.* files. Files removed by mongod while walking
+// are ignored.
+func listMetricsFiles(dir string) ([]metricsFile, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("Unable to tar files - %w", err)
+	}
+
+	var files []metricsFile
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() && d.Name() == fscopy.PartialDir {
+			// files whose copy was interrupted
+			return filepath.SkipDir
+		}
+		if !d.Type().IsRegular() || !strings.HasPrefix(d.Name(), "metrics.") {
+			return nil
+		}
+		fi, err := d.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, metricsFile{
+			Name: rel,
+			Path: path,
+			Size: fi.Size(),
+			Time: metricsFileTime(d.Name(), fi.ModTime()),
+		})
+		return nil
+	})
+	return files, err
+}
+
+// metricsInterimFileName holds the samples mongod has not yet written to the current
+// metrics file.
+const metricsInterimFileName = "metrics.interim"
+
+// selectMetricsFiles returns the files overlapping the window, in their original order.
+// A metrics file covers the time from its start to the start of the next file; the newest
+// one is still being written to. metrics.interim is only kept when the window reaches now.
+func selectMetricsFiles(files []metricsFile, window timewindow.Window, now time.Time) []metricsFile {
+	if window.IsZero() {
+		return files
+	}
+
+	byTime := make([]int, 0, len(files))
+	for i, f := range files {
+		if filepath.Base(f.Name) != metricsInterimFileName {
+			byTime = append(byTime, i)
+		}
+	}
+	sort.SliceStable(byTime, func(a, b int) bool {
+		return files[byTime[a]].Time.Before(files[byTime[b]].Time)
+	})
+
+	keep := make([]bool, len(files))
+	for n, i := range byTime {
+		var end time.Time
+		if n+1 < len(byTime) {
+			end = files[byTime[n+1]].Time
+		}
+		keep[i] = window.Overlaps(files[i].Time, end)
+	}
+	reachesNow := window.Until.IsZero() || !window.Until.Before(now)
+	for i, f := range files {
+		if filepath.Base(f.Name) == metricsInterimFileName {
+			keep[i] = reachesNow
+		}
+	}
+
+	selected := make([]metricsFile, 0, len(files))
+	for i, f := range files {
+		if keep[i] {
+			selected = append(selected, f)
+		}
+	}
+	return selected
+}
+
+// fitMetricsFiles returns the newest files that fit in sizes.
+func fitMetricsFiles(files []metricsFile, sizes *budget.Set) []metricsFile {
+	candidates := make([]budget.File, len(files))
+	byName := make(map[string]metricsFile, len(files))
+	for i, f := range files {
+		candidates[i] = budget.File{Name: f.Name, Size: f.Size, Time: f.Time}
+		byName[f.Name] = f
+	}
+	kept := sizes.Select(candidates)
+	if len(kept) < len(files) {
+		fmt.Printf("WARNING: leaving out %d of %d FTDC file(s): %s\n", len(files)-len(kept), len(files), sizes.Reason())
+	}
+	fitting := make([]metricsFile, 0, len(kept))
+	for _, k := range kept {
+		fitting = append(fitting, byName[k.Name])
+	}
+	return fitting
+}
+
+// archiveMetricsFileSet tars the metrics files under dir overlapping the window into out,
+// keeping the newest files that fit in sizes. dir holds copies mongod does not write to.
+func archiveMetricsFileSet(
+	dir string,
+	window timewindow.Window,
+	sizes *budget.Set,
+	out io.Writer,
+) error {
+	files, err := listMetricsFiles(dir)
+	if err != nil {
+		return err
+	}
+	files = fitMetricsFiles(selectMetricsFiles(files, window, time.Now()), sizes)
+
+	entries := make([]archiver.FileEntry, 0, len(files))
+	for _, f := range files {
+		entries = append(entries, archiver.FileEntry{Name: f.Name, Path: f.Path})
+	}
+	return archiver.TarFiles(entries, out)
+}
+
+// streamMetricsFileSet tars the metrics files of dir on the node overlapping the window
+// into out as job streams them, keeping the newest files that fit in sizes. outDir must
+// have room for the selected files at their size on the node, which the compressed
+// archive does not exceed.
+func streamMetricsFileSet(
+	job *fscopy.FSCopyJob,
+	dir string,
+	files []metricsFile,
+	window timewindow.Window,
+	sizes *budget.Set,
+	outDir string,
+	out io.Writer,
+) error {
+	files = fitMetricsFiles(selectMetricsFiles(files, window, time.Now()), sizes)
+	if !window.IsZero() {
+		job.Dcrlog.Info(fmt.Sprintf("time window %s selects %d FTDC file(s)", window, len(files)))
+	}
+
+	names := make([]string, 0, len(files))
+	var need int64
+	for _, f := range files {
+		names = append(names, f.Name)
+		need += f.Size
+	}
+	err := fscopy.CheckFreeSpace(outDir, need)
+	if err != nil {
+		return err
+	}
+
+	ts := archiver.NewTarStream(out)
+	streamed := 0
+	err = job.StreamFiles(dir, names, func(hdr *tar.Header, r io.Reader) error {
+		hdr.Name = path.Join(archiveDiagnosticDirName, hdr.Name)
+		streamed++
+		return ts.Add(hdr, r, false)
+	})
+	if cerr := ts.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if streamed < len(names) {
+		job.Dcrlog.Warn(fmt.Sprintf("%d of %d FTDC file(s) could not be read from %s", len(names)-streamed, len(names), dir))
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftdcarchiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dcrcli/budget"
+	"dcrcli/dcrlogger"
+	"dcrcli/fscopy"
+	"dcrcli/timewindow"
+)
+
+func TestArchiveMetricsFileSetKeepsNewestFiles(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "diagnostic.data")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// names sort the same way lexically and by time except for the interim file
+	for _, name := range []string{
+		"metrics.2024-03-01T10-00-00Z-00000",
+		"metrics.2024-03-02T10-00-00Z-00000",
+		"metrics.interim",
+		"unrelated.txt",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), bytes.Repeat([]byte("x"), 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	record := &budget.Record{}
+	sizes := &budget.Set{Kind: "ftdc", Budgets: []*budget.Budget{{Name: "ftdc_per_node", Limit: 200}}, Record: record}
+	var out bytes.Buffer
+	if err := archiveMetricsFileSet(filepath.Dir(dir), timewindow.Window{}, sizes, &out); err != nil {
+		t.Fatal(err)
+	}
+
+	gzr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	want := []string{"diagnostic.data/metrics.2024-03-02T10-00-00Z-00000", "diagnostic.data/metrics.interim"}
+	if len(names) != 2 || names[0] != want[0] || names[1] != want[1] {
+		t.Fatalf("archived %v, want %v", names, want)
+	}
+	if len(record.Dropped) != 1 || record.Dropped[0].Name != "diagnostic.data/metrics.2024-03-01T10-00-00Z-00000" {
+		t.Fatalf("unexpected record: %+v", record.Dropped)
+	}
+}
+
+func TestSelectMetricsFilesInWindow(t *testing.T) {
+	files := []metricsFile{
+		{Name: "metrics.2024-03-01T00-00-00Z-00000"},
+		{Name: "metrics.2024-03-05T00-00-00Z-00000"},
+		{Name: "metrics.2024-03-08T00-00-00Z-00000"},
+		{Name: "metrics.interim"},
+	}
+	for i := range files {
+		files[i].Time = metricsFileTime(files[i].Name, time.Time{})
+	}
+	now := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	names := func(files []metricsFile) string {
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	// the file started on the 1st runs until the 5th, so it overlaps the 3rd
+	window := timewindow.Window{
+		Since: time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC),
+		Until: time.Date(2024, 3, 6, 0, 0, 0, 0, time.UTC),
+	}
+	got := names(selectMetricsFiles(files, window, now))
+	if got != "metrics.2024-03-01T00-00-00Z-00000,metrics.2024-03-05T00-00-00Z-00000" {
+		t.Fatalf("unexpected selection: %s", got)
+	}
+
+	// the newest file and the interim file are kept while the window reaches now
+	window = timewindow.Window{Since: time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)}
+	got = names(selectMetricsFiles(files, window, now))
+	if got != "metrics.2024-03-08T00-00-00Z-00000,metrics.interim" {
+		t.Fatalf("unexpected selection: %s", got)
+	}
+}
+
+func TestStreamMetricsFileSetInWindow(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"metrics.2024-03-01T10-00-00Z-00000",
+		"metrics.2024-03-02T10-00-00Z-00000",
+		"metrics.2024-03-03T10-00-00Z-00000",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dcrlog := dcrlogger.DCRLogger{OutputPrefix: t.TempDir() + "/", FileName: "metricsfiles_test"}
+	if err := dcrlog.Create(); err != nil {
+		t.Fatal(err)
+	}
+	fa := RemoteFTDCarchive{
+		DiagnosticDirPath: dir,
+		RemoteCopyJob:     &fscopy.FSCopyJob{Src: fscopy.SourceDir{IsLocal: true}, Dcrlog: &dcrlog},
+	}
+	files, err := fa.listRemoteMetricsFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	window := timewindow.Window{Since: time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)}
+	var out bytes.Buffer
+	if err := streamMetricsFileSet(fa.RemoteCopyJob, dir, files, window, nil, t.TempDir(), &out); err != nil {
+		t.Fatal(err)
+	}
+	gzr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	want := "diagnostic.data/metrics.2024-03-02T10-00-00Z-00000,diagnostic.data/metrics.2024-03-03T10-00-00Z-00000"
+	if strings.Join(names, ",") != want {
+		t.Fatalf("unexpected archive entries %v", names)
+	}
+}
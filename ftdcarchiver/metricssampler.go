@@ -0,0 +1,141 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftdcarchiver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"dcrcli/dcrlogger"
+	"dcrcli/dcroutdir"
+	"dcrcli/mongosh"
+)
+
+// MetricsSampler is the fallback for nodes where FTDC is disabled or unreadable.
+// It polls serverStatus and replSetGetStatus every Interval for Duration and writes the
+// samples to metricssamples.json in the node output directory.
+type MetricsSampler struct {
+	Mongo     mongosh.CaptureGetMongoData
+	Interval  time.Duration
+	Duration  time.Duration
+	Reason    string // why FTDC could not be used, recorded in the output
+	Outputdir *dcroutdir.DCROutputDir
+	Dcrlog    *dcrlogger.DCRLogger
+}
+
+// metricsTimeSeries is the on-disk layout: one shared timestamp column and one value
+// column per metric, so a sample costs a number per metric instead of a repeated key.
+type metricsTimeSeries struct {
+	Source          string                `json:"source"`
+	Reason          string                `json:"reason,omitempty"`
+	IntervalSeconds float64               `json:"intervalSeconds"`
+	Timestamps      []int64               `json:"timestamps"`
+	Metrics         map[string][]*float64 `json:"metrics"`
+}
+
+func (ms *MetricsSampler) sampleCount() int {
+	if ms.Interval <= 0 {
+		return 1
+	}
+	count := int(ms.Duration / ms.Interval)
+	if count < 1 {
+		count = 1
+	}
+	return count
+}
+
+// buildMetricsTimeSeries converts row-oriented samples from the shell into columns.
+// Metrics missing from a sample are stored as null to keep columns aligned.
+func buildMetricsTimeSeries(samples []map[string]*float64, interval time.Duration) metricsTimeSeries {
+	ts := metricsTimeSeries{
+		Source:          "serverStatus/replSetGetStatus sampler",
+		IntervalSeconds: interval.Seconds(),
+		Timestamps:      make([]int64, 0, len(samples)),
+		Metrics:         make(map[string][]*float64),
+	}
+
+	names := make(map[string]bool)
+	for _, sample := range samples {
+		for name := range sample {
+			if name != "ts" {
+				names[name] = true
+			}
+		}
+	}
+	sortedNames := make([]string, 0, len(names))
+	for name := range names {
+		sortedNames = append(sortedNames, name)
+	}
+	sort.Strings(sortedNames)
+
+	for _, name := range sortedNames {
+		ts.Metrics[name] = make([]*float64, 0, len(samples))
+	}
+
+	for _, sample := range samples {
+		var t int64
+		if v := sample["ts"]; v != nil {
+			t = int64(*v)
+		}
+		ts.Timestamps = append(ts.Timestamps, t)
+		for _, name := range sortedNames {
+			ts.Metrics[name] = append(ts.Metrics[name], sample[name])
+		}
+	}
+
+	return ts
+}
+
+func (ms *MetricsSampler) Start() error {
+	count := ms.sampleCount()
+	ms.Dcrlog.Info(
+		fmt.Sprintf(
+			"FTDC fallback sampler: collecting %d sample(s) every %s", count, ms.Interval,
+		),
+	)
+
+	err := ms.Mongo.RunMetricsSamplerWithEval(ms.Interval, count)
+	if err != nil {
+		return fmt.Errorf("Error in MetricsSampler.Start: %w", err)
+	}
+
+	output, err := mongosh.UnwrapJSONString(ms.Mongo.Getparsedjsonoutput.Bytes())
+	if err != nil {
+		return fmt.Errorf("Error in MetricsSampler.Start: %w", err)
+	}
+
+	var samples []map[string]*float64
+	if err := json.Unmarshal(output, &samples); err != nil {
+		return fmt.Errorf("Error in MetricsSampler.Start parsing samples: %w", err)
+	}
+
+	series := buildMetricsTimeSeries(samples, ms.Interval)
+	series.Reason = ms.Reason
+
+	data, err := json.Marshal(series)
+	if err != nil {
+		return fmt.Errorf("Error in MetricsSampler.Start: %w", err)
+	}
+
+	err = os.WriteFile(ms.Outputdir.Path()+"/metricssamples.json", data, 0644)
+	if err != nil {
+		return fmt.Errorf("Error in MetricsSampler.Start writing samples: %w", err)
+	}
+	ms.Dcrlog.Info(fmt.Sprintf("FTDC fallback sampler: wrote %d sample(s)", len(samples)))
+	return nil
+}
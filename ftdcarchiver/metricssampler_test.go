@@ -0,0 +1,65 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ftdcarchiver
+
+import (
+	"testing"
+	"time"
+)
+
+func f(v float64) *float64 { return &v }
+
+func TestBuildMetricsTimeSeriesAlignsMissingMetrics(t *testing.T) {
+	samples := []map[string]*float64{
+		{"ts": f(1000), "opcounters.insert": f(5), "repl.lagSeconds": f(2)},
+		{"ts": f(2000), "opcounters.insert": f(7)},
+	}
+
+	series := buildMetricsTimeSeries(samples, 10*time.Second)
+
+	if series.IntervalSeconds != 10 {
+		t.Fatalf("IntervalSeconds = %v want 10", series.IntervalSeconds)
+	}
+	if len(series.Timestamps) != 2 || series.Timestamps[0] != 1000 || series.Timestamps[1] != 2000 {
+		t.Fatalf("unexpected timestamps %v", series.Timestamps)
+	}
+	if _, ok := series.Metrics["ts"]; ok {
+		t.Fatal("ts should not be stored as a metric column")
+	}
+	lag := series.Metrics["repl.lagSeconds"]
+	if len(lag) != 2 || lag[0] == nil || *lag[0] != 2 || lag[1] != nil {
+		t.Fatalf("missing samples should be null and keep columns aligned: %v", lag)
+	}
+	inserts := series.Metrics["opcounters.insert"]
+	if len(inserts) != 2 || *inserts[1] != 7 {
+		t.Fatalf("unexpected opcounters.insert column %v", inserts)
+	}
+}
+
+func TestMetricsSamplerSampleCount(t *testing.T) {
+	for _, tc := range []struct {
+		interval, duration time.Duration
+		want               int
+	}{
+		{10 * time.Second, time.Minute, 6},
+		{10 * time.Second, 5 * time.Second, 1},
+		{0, time.Minute, 1},
+	} {
+		ms := MetricsSampler{Interval: tc.interval, Duration: tc.duration}
+		if got := ms.sampleCount(); got != tc.want {
+			t.Fatalf("sampleCount(%s, %s) = %d want %d", tc.interval, tc.duration, got, tc.want)
+		}
+	}
+}
@@ -15,13 +15,16 @@
 package ftdcarchiver
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
-	"dcrcli/archiver"
+	"dcrcli/budget"
 	"dcrcli/dcroutdir"
 	"dcrcli/fscopy"
 	"dcrcli/mongosh"
+	"dcrcli/timewindow"
 )
 
 type RemoteFTDCarchive struct {
@@ -29,22 +32,49 @@ type RemoteFTDCarchive struct {
 	DiagnosticDirPath string
 	FTDCArchiveFile   *os.File
 	Outputdir         *dcroutdir.DCROutputDir
+	Window            timewindow.Window // zero archives every metrics file
+	SizeBudget        *budget.Set       // nil archives every metrics file
 	TempOutputdir     *dcroutdir.DCROutputDir
 	RemoteCopyJob     *fscopy.FSCopyJob
 }
 
 func (fa *RemoteFTDCarchive) getDiagnosticDataDirPath() error {
-	err := fa.Mongo.RunGetCommandDiagnosticDataCollectionDirectoryPath()
+	var err error
+	fa.DiagnosticDirPath, err = diagnosticDataDirPath(&fa.Mongo)
+	return err
+}
+
+func (fa *RemoteFTDCarchive) checkFTDCEnabled() error {
+	enabled, err := isFTDCEnabled(&fa.Mongo)
 	if err != nil {
-		return fmt.Errorf("Error in getDiagnosticDataDirPath: %w", err)
+		return nil
+	}
+	if !enabled {
+		return fmt.Errorf("diagnosticDataCollectionEnabled is false: %w", ErrFTDCUnavailable)
+	}
+	if fa.DiagnosticDirPath == "" {
+		return fmt.Errorf("diagnosticDataCollectionDirectoryPath is empty: %w", ErrFTDCUnavailable)
 	}
-
-	fa.DiagnosticDirPath = fa.Mongo.Getparsedjsonoutput.String()
-	fa.DiagnosticDirPath = trimQuote(fa.DiagnosticDirPath)
-
 	return nil
 }
 
+// DiagnosticDir returns the diagnostic.data dir of the node, or "" when the node does not
+// write FTDC.
+func (fa *RemoteFTDCarchive) DiagnosticDir() (string, error) {
+	err := fa.getDiagnosticDataDirPath()
+	if err != nil {
+		return "", err
+	}
+	err = fa.checkFTDCEnabled()
+	if errors.Is(err, ErrFTDCUnavailable) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fa.DiagnosticDirPath, nil
+}
+
 func (fa *RemoteFTDCarchive) createFTDCTarArchiveFile() error {
 	var err error
 	fa.FTDCArchiveFile, err = os.Create(fa.Outputdir.Path() + "/ftdcarchive.tar.gz")
@@ -54,44 +84,91 @@ func (fa *RemoteFTDCarchive) createFTDCTarArchiveFile() error {
 	return nil
 }
 
+// archiveMetricsFiles archives the copies in the temp dir, which mongod does not change.
 func (fa *RemoteFTDCarchive) archiveMetricsFiles() error {
-	metricsFileSearchPatternString := `^metrics.*`
-	err := archiver.TarWithPatternMatch(
-		fa.TempOutputdir.Path(),
-		metricsFileSearchPatternString,
-		fa.FTDCArchiveFile,
-	)
+	err := archiveMetricsFileSet(fa.TempOutputdir.Path(), fa.Window, fa.SizeBudget, fa.FTDCArchiveFile)
 	if err != nil {
 		return fmt.Errorf("Error in archiveMetricsFiles %w", err)
 	}
 	return nil
 }
 
+// listRemoteMetricsFiles lists the metrics files in the diagnostic.data dir of the node.
+func (fa *RemoteFTDCarchive) listRemoteMetricsFiles() ([]metricsFile, error) {
+	listed, err := fa.RemoteCopyJob.ListFiles(fa.DiagnosticDirPath)
+	if err != nil {
+		return nil, err
+	}
+	var files []metricsFile
+	for _, f := range listed {
+		if strings.HasPrefix(f.Name, "metrics.") {
+			files = append(files, metricsFile{Name: f.Name, Size: f.Size, Time: metricsFileTime(f.Name, f.ModTime)})
+		}
+	}
+	return files, nil
+}
+
 func (fa *RemoteFTDCarchive) remoteCopyFTDCfilesToTemp() error {
-	// we need to setup remote copy job and then put it in motion
-	fa.RemoteCopyJob.Src.Path = []byte(fa.DiagnosticDirPath)
-	err := fa.RemoteCopyJob.StartCopy()
+	err := copyDiagnosticDir(fa.RemoteCopyJob, fa.DiagnosticDirPath, fa.Window, fa.listRemoteMetricsFiles, fa.TempOutputdir.Path())
 	if err != nil {
-		return fmt.Errorf("Error in remoteCopyFTDCfilesToTemp %w", err)
+		// the directory could not be copied over SSH, e.g. missing or unreadable
+		return fmt.Errorf("Error in remoteCopyFTDCfilesToTemp %w: %w", ErrFTDCUnavailable, err)
+	}
+	return nil
+}
+
+// streamMetricsFiles archives the metrics files straight from a tar stream of the node,
+// without copying them to the temp dir first.
+func (fa *RemoteFTDCarchive) streamMetricsFiles() error {
+	files, err := fa.listRemoteMetricsFiles()
+	if err != nil {
+		// the directory could not be listed over SSH, e.g. missing or unreadable
+		return fmt.Errorf("Error in streamMetricsFiles %w: %w", ErrFTDCUnavailable, err)
+	}
+
+	err = fa.createFTDCTarArchiveFile()
+	if err != nil {
+		return err
+	}
+
+	err = streamMetricsFileSet(
+		fa.RemoteCopyJob, fa.DiagnosticDirPath, files, fa.Window, fa.SizeBudget, fa.Outputdir.Path(), fa.FTDCArchiveFile,
+	)
+	if err != nil {
+		return fmt.Errorf("Error in streamMetricsFiles %w", err)
 	}
 	return nil
 }
 
 func (fa *RemoteFTDCarchive) Start() error {
-	err := fa.createFTDCTarArchiveFile()
+	err := fa.getDiagnosticDataDirPath()
 	if err != nil {
 		return fmt.Errorf("Error in RemoteFTDCarchive.Start: %w", err)
 	}
-	err = fa.getDiagnosticDataDirPath()
+
+	err = fa.checkFTDCEnabled()
 	if err != nil {
 		return fmt.Errorf("Error in RemoteFTDCarchive.Start: %w", err)
 	}
 
+	if fa.RemoteCopyJob.Transfer.Stream {
+		err = fa.streamMetricsFiles()
+		if err != nil {
+			return fmt.Errorf("Error in RemoteFTDCarchive.Start: %w", err)
+		}
+		return nil
+	}
+
 	err = fa.remoteCopyFTDCfilesToTemp()
 	if err != nil {
 		return fmt.Errorf("Error in RemoteFTDCarchive.Start: %w", err)
 	}
 
+	err = fa.createFTDCTarArchiveFile()
+	if err != nil {
+		return fmt.Errorf("Error in RemoteFTDCarchive.Start: %w", err)
+	}
+
 	err = fa.archiveMetricsFiles()
 	if err != nil {
 		return fmt.Errorf("Error in RemoteFTDCarchive.Start: %w", err)
@@ -3,11 +3,16 @@ module dcrcli
 go 1.21.4
 
 require (
-	github.com/briandowns/spinner v1.23.1 // indirect
+	github.com/briandowns/spinner v1.23.1
+	github.com/pkg/sftp v1.13.6
+	golang.org/x/crypto v0.17.0
+	golang.org/x/term v0.15.0
+)
+
+require (
 	github.com/fatih/color v1.7.0 // indirect
-	github.com/google/uuid v1.5.0 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/mattn/go-colorable v0.1.2 // indirect
 	github.com/mattn/go-isatty v0.0.8 // indirect
 	golang.org/x/sys v0.15.0 // indirect
-	golang.org/x/term v0.15.0 // indirect
 )
@@ -0,0 +1,178 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loganalyzer
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dcrcli/dcrlogger"
+	"dcrcli/dcroutdir"
+)
+
+const (
+	ReportJSONFileName     = "loganalysis.json"
+	ReportMarkdownFileName = "loganalysis.md"
+)
+
+// logArchives are the archives in a node output dir whose log files are analysed.
+var logArchives = []string{"logarchive.tar.gz", "ramlogarchive_partial.tar.gz"}
+
+// ErrNoLogs is returned by Start when the node has no collected log archive.
+var ErrNoLogs = errors.New("no collected logs to analyse")
+
+// AddLines reads every line of r.
+func (a *Analyzer) AddLines(r io.Reader) error {
+	reader := bufio.NewReaderSize(r, 1024*1024)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			a.AddLine(line)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// AddTarGz reads the log files in a .tar.gz archive and returns their names. Entries
+// compressed with gzip are decompressed; other compressed entries and text notes are
+// skipped.
+func (a *Analyzer) AddTarGz(r io.Reader) ([]string, error) {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gzr.Close()
+	tr := tar.NewReader(gzr)
+
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return names, err
+		}
+		if header.Typeflag != tar.TypeReg || !isLogEntry(header.Name) {
+			continue
+		}
+
+		var in io.Reader = tr
+		if strings.HasSuffix(header.Name, ".gz") {
+			entry, err := gzip.NewReader(tr)
+			if err != nil {
+				return names, fmt.Errorf("error reading %s: %w", header.Name, err)
+			}
+			in = entry
+		}
+		if err := a.AddLines(in); err != nil {
+			return names, fmt.Errorf("error reading %s: %w", header.Name, err)
+		}
+		names = append(names, header.Name)
+	}
+}
+
+func isLogEntry(name string) bool {
+	switch filepath.Ext(name) {
+	case ".txt", ".md", ".json", ".bz2", ".xz", ".zst", ".lz4", ".zip":
+		return false
+	}
+	return true
+}
+
+// LogAnalysis analyses the logs collected for one node and writes loganalysis.json and
+// loganalysis.md to its output dir.
+type LogAnalysis struct {
+	Outputdir *dcroutdir.DCROutputDir
+	Report    Report
+	Dcrlog    *dcrlogger.DCRLogger
+}
+
+func (la *LogAnalysis) analyseArchives(a *Analyzer) ([]string, error) {
+	var files []string
+	for _, name := range logArchives {
+		f, err := os.Open(filepath.Join(la.Outputdir.Path(), name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return files, err
+		}
+		entries, err := a.AddTarGz(f)
+		f.Close()
+		for _, e := range entries {
+			files = append(files, name+":"+e)
+		}
+		if err != nil {
+			return files, fmt.Errorf("error analysing %s: %w", name, err)
+		}
+	}
+	return files, nil
+}
+
+func (la *LogAnalysis) writeReport() error {
+	jsonFile, err := os.Create(filepath.Join(la.Outputdir.Path(), ReportJSONFileName))
+	if err != nil {
+		return err
+	}
+	defer jsonFile.Close()
+	err = la.Report.WriteJSON(jsonFile)
+	if err != nil {
+		return err
+	}
+
+	mdFile, err := os.Create(filepath.Join(la.Outputdir.Path(), ReportMarkdownFileName))
+	if err != nil {
+		return err
+	}
+	defer mdFile.Close()
+	return la.Report.WriteMarkdown(mdFile, la.Outputdir.Hostname+":"+la.Outputdir.Port)
+}
+
+func (la *LogAnalysis) Start() error {
+	a := New()
+	files, err := la.analyseArchives(a)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return ErrNoLogs
+	}
+
+	la.Report = a.Report()
+	la.Report.Files = files
+	la.Dcrlog.Debug(
+		fmt.Sprintf("analysed %d log line(s) from %d file(s), %d slow op group(s)",
+			la.Report.Lines, len(files), len(la.Report.SlowOps)),
+	)
+
+	err = la.writeReport()
+	if err != nil {
+		return fmt.Errorf("error writing log analysis report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package loganalyzer summarises structured (4.4+) mongod logs: slow operations by
+// namespace and query shape, error codes, connection churn and replica set elections.
+package loganalyzer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Log message ids used by the analysis.
+const (
+	idSlowQuery          = 51803
+	idConnectionAccepted = 22943
+	idConnectionEnded    = 22944
+	idStateTransition    = 21358
+)
+
+// maxEvents caps the election and step-down events kept in the report.
+const maxEvents = 1000
+
+// logLine is the part of a structured log line the analysis reads.
+type logLine struct {
+	T struct {
+		Date string `json:"$date"`
+	} `json:"t"`
+	S    string         `json:"s"`
+	C    string         `json:"c"`
+	ID   int            `json:"id"`
+	Msg  string         `json:"msg"`
+	Attr map[string]any `json:"attr"`
+}
+
+type slowOpKey struct {
+	Namespace string
+	Op        string
+	Shape     string
+}
+
+type slowOpGroup struct {
+	durations   []int64
+	planSummary map[string]int
+	docsExam    int64
+	keysExam    int64
+	nreturned   int64
+}
+
+type errorKey struct {
+	Code int
+	Name string
+}
+
+// Analyzer accumulates statistics from log lines. Use AddLine for every line, then Report.
+type Analyzer struct {
+	lines       int64
+	parsed      int64
+	first, last time.Time
+
+	slowOps    map[slowOpKey]*slowOpGroup
+	errorCodes map[errorKey]int
+	errorMsgs  map[string]int
+	severities map[string]int
+
+	accepted       int64
+	ended          int64
+	maxConnections int64
+	remotes        map[string]int
+	perMinute      map[time.Time]int
+
+	events []Event
+}
+
+func New() *Analyzer {
+	return &Analyzer{
+		slowOps:    make(map[slowOpKey]*slowOpGroup),
+		errorCodes: make(map[errorKey]int),
+		errorMsgs:  make(map[string]int),
+		severities: make(map[string]int),
+		remotes:    make(map[string]int),
+		perMinute:  make(map[time.Time]int),
+	}
+}
+
+// AddLine reads one log line. Lines that are not structured JSON, such as legacy text
+// logs, are counted but otherwise ignored. A prefix before the JSON object, as added by
+// syslog, is skipped.
+func (a *Analyzer) AddLine(line []byte) {
+	a.lines++
+	start := bytes.IndexByte(line, '{')
+	if start < 0 {
+		return
+	}
+	var l logLine
+	if err := json.Unmarshal(bytes.TrimSpace(line[start:]), &l); err != nil || l.Msg == "" {
+		return
+	}
+	a.parsed++
+
+	t, err := time.Parse(time.RFC3339Nano, l.T.Date)
+	if err == nil {
+		if a.first.IsZero() || t.Before(a.first) {
+			a.first = t
+		}
+		if t.After(a.last) {
+			a.last = t
+		}
+	}
+	a.severities[l.S]++
+
+	switch l.ID {
+	case idSlowQuery:
+		a.addSlowOp(l.Attr)
+	case idConnectionAccepted:
+		a.accepted++
+		if remote, ok := l.Attr["remote"].(string); ok {
+			a.remotes[remoteHost(remote)]++
+		}
+		if !t.IsZero() {
+			a.perMinute[t.Truncate(time.Minute)]++
+		}
+		a.maxConnections = max(a.maxConnections, intAttr(l.Attr, "connectionCount"))
+	case idConnectionEnded:
+		a.ended++
+	}
+
+	a.addErrors(l)
+	if isElectionEvent(l) && len(a.events) < maxEvents {
+		a.events = append(a.events, newEvent(t, l))
+	}
+}
+
+func (a *Analyzer) addSlowOp(attr map[string]any) {
+	ns, _ := attr["ns"].(string)
+	op, shape := queryShape(attr)
+	key := slowOpKey{Namespace: ns, Op: op, Shape: shape}
+	g, ok := a.slowOps[key]
+	if !ok {
+		g = &slowOpGroup{planSummary: make(map[string]int)}
+		a.slowOps[key] = g
+	}
+	g.durations = append(g.durations, intAttr(attr, "durationMillis"))
+	if plan, ok := attr["planSummary"].(string); ok {
+		g.planSummary[plan]++
+	}
+	g.docsExam += intAttr(attr, "docsExamined")
+	g.keysExam += intAttr(attr, "keysExamined")
+	g.nreturned += intAttr(attr, "nreturned")
+}
+
+// addErrors counts error codes reported in attr.errCode, attr.code or attr.error, and
+// the messages of lines logged with error or fatal severity.
+func (a *Analyzer) addErrors(l logLine) {
+	if code := intAttr(l.Attr, "errCode"); code != 0 {
+		name, _ := l.Attr["errName"].(string)
+		a.errorCodes[errorKey{Code: int(code), Name: name}]++
+	} else if code := intAttr(l.Attr, "code"); code != 0 {
+		name, _ := l.Attr["codeName"].(string)
+		a.errorCodes[errorKey{Code: int(code), Name: name}]++
+	} else if e, ok := l.Attr["error"].(map[string]any); ok {
+		if code := intAttr(e, "code"); code != 0 {
+			name, _ := e["codeName"].(string)
+			a.errorCodes[errorKey{Code: int(code), Name: name}]++
+		}
+	}
+	if l.S == "E" || l.S == "F" {
+		a.errorMsgs[fmt.Sprintf("%d %s", l.ID, l.Msg)]++
+	}
+}
+
+// isElectionEvent matches state transitions, elections and step-downs.
+func isElectionEvent(l logLine) bool {
+	if l.ID == idStateTransition {
+		return true
+	}
+	if l.C != "REPL" && l.C != "ELECTION" && l.C != "COMMAND" {
+		return false
+	}
+	msg := strings.ToLower(l.Msg)
+	for _, s := range []string{"election", "stepping down", "step down", "stepdown"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func intAttr(attr map[string]any, key string) int64 {
+	switch v := attr[key].(type) {
+	case float64:
+		return int64(v)
+	case map[string]any:
+		// extended JSON, e.g. {"$numberLong": "123"}
+		if s, ok := v["$numberLong"].(string); ok {
+			n, _ := strconv.ParseInt(s, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
+
+func remoteHost(remote string) string {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		return remote
+	}
+	return host
+}
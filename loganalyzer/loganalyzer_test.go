@@ -0,0 +1,139 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loganalyzer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dcrcli/dcrlogger"
+	"dcrcli/dcroutdir"
+)
+
+func slowQuery(minute int, value int, millis int) string {
+	return fmt.Sprintf(`{"t":{"$date":"2024-03-09T10:%02d:00.000+00:00"},"s":"I","c":"COMMAND","id":51803,"ctx":"conn1",`+
+		`"msg":"Slow query","attr":{"type":"command","ns":"shop.users","command":{"find":"users","filter":{"age":{"$gt":%d}},"$db":"shop"},`+
+		`"planSummary":"COLLSCAN","docsExamined":1000,"nreturned":1,"durationMillis":%d}}`, minute, value, millis)
+}
+
+var sampleLog = strings.Join([]string{
+	slowQuery(0, 1, 100),
+	slowQuery(1, 2, 200),
+	slowQuery(2, 3, 300),
+	slowQuery(3, 4, 4000),
+	`{"t":{"$date":"2024-03-09T10:04:00.000+00:00"},"s":"I","c":"NETWORK","id":22943,"msg":"Connection accepted","attr":{"remote":"10.0.0.5:51000","connectionCount":7}}`,
+	`{"t":{"$date":"2024-03-09T10:04:10.000+00:00"},"s":"I","c":"NETWORK","id":22943,"msg":"Connection accepted","attr":{"remote":"10.0.0.5:51001","connectionCount":8}}`,
+	`{"t":{"$date":"2024-03-09T10:05:00.000+00:00"},"s":"I","c":"NETWORK","id":22944,"msg":"Connection ended","attr":{"remote":"10.0.0.5:51000","connectionCount":7}}`,
+	`{"t":{"$date":"2024-03-09T10:06:00.000+00:00"},"s":"I","c":"COMMAND","id":51803,"msg":"Slow query","attr":{"ns":"shop.users","command":{"insert":"users"},"errCode":11000,"errName":"DuplicateKey","durationMillis":5}}`,
+	`{"t":{"$date":"2024-03-09T10:07:00.000+00:00"},"s":"I","c":"REPL","id":21358,"msg":"Replica set state transition","attr":{"newState":"SECONDARY","oldState":"PRIMARY"}}`,
+	`{"t":{"$date":"2024-03-09T10:08:00.000+00:00"},"s":"E","c":"STORAGE","id":1234,"msg":"Disk full"}`,
+	`2024-03-09T10:09:00.000+0000 I NETWORK  [conn1] legacy line`,
+}, "\n") + "\n"
+
+func TestAnalyzerReport(t *testing.T) {
+	a := New()
+	if err := a.AddLines(strings.NewReader(sampleLog)); err != nil {
+		t.Fatal(err)
+	}
+	r := a.Report()
+
+	if r.Lines != 11 || r.ParsedLines != 10 {
+		t.Fatalf("lines %d parsed %d", r.Lines, r.ParsedLines)
+	}
+	if len(r.SlowOps) != 2 {
+		t.Fatalf("expected find and insert groups: %+v", r.SlowOps)
+	}
+	find := r.SlowOps[0]
+	if find.Op != "find" || find.Count != 4 || find.Shape != `{"age":{"$gt":1}}` {
+		t.Fatalf("values were not grouped into one shape: %+v", find)
+	}
+	if find.P50Millis != 200 || find.P95Millis != 4000 || find.MaxMillis != 4000 || find.TotalMillis != 4600 {
+		t.Fatalf("unexpected percentiles: %+v", find)
+	}
+	if len(r.ErrorCodes) != 1 || r.ErrorCodes[0].Name != "DuplicateKey" || len(r.ErrorMsgs) != 1 {
+		t.Fatalf("unexpected errors: %+v %+v", r.ErrorCodes, r.ErrorMsgs)
+	}
+	c := r.Connections
+	if c.Accepted != 2 || c.Ended != 1 || c.MaxOpen != 8 || c.TopClients[0].Message != "10.0.0.5" || c.PeakMinuteCount != 2 {
+		t.Fatalf("unexpected connections: %+v", c)
+	}
+	if len(r.Events) != 1 || r.Events[0].NewState != "SECONDARY" {
+		t.Fatalf("unexpected events: %+v", r.Events)
+	}
+}
+
+func TestLogAnalysisWritesReports(t *testing.T) {
+	outputdir := dcroutdir.DCROutputDir{OutputPrefix: t.TempDir() + "/", Hostname: "db1", Port: "27017"}
+	if err := outputdir.CreateDCROutputDir(); err != nil {
+		t.Fatal(err)
+	}
+
+	var rotated bytes.Buffer
+	gzw := gzip.NewWriter(&rotated)
+	gzw.Write([]byte(slowQuery(0, 9, 50) + "\n"))
+	gzw.Close()
+
+	var archive bytes.Buffer
+	agz := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(agz)
+	for _, entry := range []struct {
+		name string
+		data []byte
+	}{{"mongod.log.1.gz", rotated.Bytes()}, {"mongod.log", []byte(sampleLog)}} {
+		tw.WriteHeader(&tar.Header{Name: entry.name, Mode: 0644, Size: int64(len(entry.data)), Typeflag: tar.TypeReg})
+		tw.Write(entry.data)
+	}
+	tw.Close()
+	agz.Close()
+	if err := os.WriteFile(filepath.Join(outputdir.Path(), "logarchive.tar.gz"), archive.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dcrlog := dcrlogger.DCRLogger{OutputPrefix: t.TempDir() + "/", FileName: "loganalyzer_test"}
+	if err := dcrlog.Create(); err != nil {
+		t.Fatal(err)
+	}
+	analysis := LogAnalysis{Outputdir: &outputdir, Dcrlog: &dcrlog}
+	if err := analysis.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(outputdir.Path(), ReportJSONFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil || len(r.Files) != 2 || r.SlowOps[0].Count != 5 {
+		t.Fatalf("unexpected report: %s (%v)", data, err)
+	}
+	md, _ := os.ReadFile(filepath.Join(outputdir.Path(), ReportMarkdownFileName))
+	if !bytes.Contains(md, []byte("| shop.users | find |")) || !bytes.Contains(md, []byte("PRIMARY → SECONDARY")) {
+		t.Fatalf("unexpected markdown:\n%s", md)
+	}
+
+	empty := dcroutdir.DCROutputDir{OutputPrefix: t.TempDir() + "/", Hostname: "db2", Port: "27017"}
+	empty.CreateDCROutputDir()
+	analysis = LogAnalysis{Outputdir: &empty, Dcrlog: &dcrlog}
+	if err := analysis.Start(); err != ErrNoLogs {
+		t.Fatalf("expected ErrNoLogs, got %v", err)
+	}
+}
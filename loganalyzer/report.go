@@ -0,0 +1,305 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loganalyzer
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Report is the result of the analysis, written as loganalysis.json and loganalysis.md.
+type Report struct {
+	Files       []string          `json:"files"`
+	Lines       int64             `json:"lines"`
+	ParsedLines int64             `json:"parsedLines"`
+	From        string            `json:"from,omitempty"`
+	To          string            `json:"to,omitempty"`
+	Severities  map[string]int    `json:"severities"`
+	SlowOps     []SlowOp          `json:"slowOps"`
+	ErrorCodes  []ErrorCode       `json:"errorCodes"`
+	ErrorMsgs   []CountedMessage  `json:"errorMessages"`
+	Connections ConnectionSummary `json:"connections"`
+	Events      []Event           `json:"electionEvents"`
+}
+
+// SlowOp is a group of slow operations with the same namespace, operation and query shape.
+type SlowOp struct {
+	Namespace    string         `json:"ns"`
+	Op           string         `json:"op"`
+	Shape        string         `json:"shape,omitempty"`
+	Count        int            `json:"count"`
+	TotalMillis  int64          `json:"totalMillis"`
+	P50Millis    int64          `json:"p50Millis"`
+	P95Millis    int64          `json:"p95Millis"`
+	P99Millis    int64          `json:"p99Millis"`
+	MaxMillis    int64          `json:"maxMillis"`
+	DocsExamined int64          `json:"docsExamined"`
+	KeysExamined int64          `json:"keysExamined"`
+	NReturned    int64          `json:"nreturned"`
+	PlanSummary  map[string]int `json:"planSummary,omitempty"`
+}
+
+type ErrorCode struct {
+	Code  int    `json:"code"`
+	Name  string `json:"name,omitempty"`
+	Count int    `json:"count"`
+}
+
+type CountedMessage struct {
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+// ConnectionSummary describes connection churn: how many connections were opened and
+// closed, the busiest minute and the clients opening the most connections.
+type ConnectionSummary struct {
+	Accepted          int64            `json:"accepted"`
+	Ended             int64            `json:"ended"`
+	MaxOpen           int64            `json:"maxOpen"`
+	AcceptedPerMinute float64          `json:"acceptedPerMinute"`
+	PeakMinute        string           `json:"peakMinute,omitempty"`
+	PeakMinuteCount   int              `json:"peakMinuteAccepted,omitempty"`
+	TopClients        []CountedMessage `json:"topClients"`
+}
+
+// Event is a replica set state transition, election or step-down.
+type Event struct {
+	Time     string `json:"t"`
+	ID       int    `json:"id"`
+	Message  string `json:"msg"`
+	OldState string `json:"oldState,omitempty"`
+	NewState string `json:"newState,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+func newEvent(t time.Time, l logLine) Event {
+	e := Event{ID: l.ID, Message: l.Msg}
+	if !t.IsZero() {
+		e.Time = t.UTC().Format(time.RFC3339Nano)
+	}
+	e.OldState, _ = l.Attr["oldState"].(string)
+	e.NewState, _ = l.Attr["newState"].(string)
+	if reason, ok := l.Attr["reason"].(string); ok {
+		e.Reason = reason
+	}
+	return e
+}
+
+// topN limits the lists in the report.
+const topN = 50
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []int64, p float64) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.999999) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+func topCounted(counts map[string]int) []CountedMessage {
+	list := make([]CountedMessage, 0, len(counts))
+	for msg, n := range counts {
+		list = append(list, CountedMessage{Message: msg, Count: n})
+	}
+	slices.SortFunc(list, func(a, b CountedMessage) int {
+		if a.Count != b.Count {
+			return cmp.Compare(b.Count, a.Count)
+		}
+		return cmp.Compare(a.Message, b.Message)
+	})
+	return list[:min(len(list), topN)]
+}
+
+// Report builds the report from the lines added so far.
+func (a *Analyzer) Report() Report {
+	r := Report{
+		Lines:       a.lines,
+		ParsedLines: a.parsed,
+		Severities:  a.severities,
+		SlowOps:     make([]SlowOp, 0, len(a.slowOps)),
+		ErrorCodes:  make([]ErrorCode, 0, len(a.errorCodes)),
+		ErrorMsgs:   topCounted(a.errorMsgs),
+		Events:      a.events,
+	}
+	if r.Events == nil {
+		r.Events = []Event{}
+	}
+	if !a.first.IsZero() {
+		r.From = a.first.UTC().Format(time.RFC3339)
+		r.To = a.last.UTC().Format(time.RFC3339)
+	}
+
+	for key, g := range a.slowOps {
+		slices.Sort(g.durations)
+		op := SlowOp{
+			Namespace:    key.Namespace,
+			Op:           key.Op,
+			Shape:        key.Shape,
+			Count:        len(g.durations),
+			P50Millis:    percentile(g.durations, 50),
+			P95Millis:    percentile(g.durations, 95),
+			P99Millis:    percentile(g.durations, 99),
+			MaxMillis:    g.durations[len(g.durations)-1],
+			DocsExamined: g.docsExam,
+			KeysExamined: g.keysExam,
+			NReturned:    g.nreturned,
+			PlanSummary:  g.planSummary,
+		}
+		for _, d := range g.durations {
+			op.TotalMillis += d
+		}
+		r.SlowOps = append(r.SlowOps, op)
+	}
+	slices.SortFunc(r.SlowOps, func(a, b SlowOp) int {
+		if a.TotalMillis != b.TotalMillis {
+			return cmp.Compare(b.TotalMillis, a.TotalMillis)
+		}
+		if a.Namespace != b.Namespace {
+			return cmp.Compare(a.Namespace, b.Namespace)
+		}
+		return cmp.Compare(a.Op+a.Shape, b.Op+b.Shape)
+	})
+	r.SlowOps = r.SlowOps[:min(len(r.SlowOps), topN)]
+
+	for key, n := range a.errorCodes {
+		r.ErrorCodes = append(r.ErrorCodes, ErrorCode{Code: key.Code, Name: key.Name, Count: n})
+	}
+	slices.SortFunc(r.ErrorCodes, func(a, b ErrorCode) int {
+		if a.Count != b.Count {
+			return cmp.Compare(b.Count, a.Count)
+		}
+		return cmp.Compare(a.Code, b.Code)
+	})
+	r.ErrorCodes = r.ErrorCodes[:min(len(r.ErrorCodes), topN)]
+
+	r.Connections = ConnectionSummary{
+		Accepted:   a.accepted,
+		Ended:      a.ended,
+		MaxOpen:    a.maxConnections,
+		TopClients: topCounted(a.remotes),
+	}
+	for minute, n := range a.perMinute {
+		if n > r.Connections.PeakMinuteCount ||
+			(n == r.Connections.PeakMinuteCount && minute.UTC().Format(time.RFC3339) < r.Connections.PeakMinute) {
+			r.Connections.PeakMinute = minute.UTC().Format(time.RFC3339)
+			r.Connections.PeakMinuteCount = n
+		}
+	}
+	if minutes := a.last.Sub(a.first).Minutes(); minutes >= 1 {
+		r.Connections.AcceptedPerMinute = float64(a.accepted) / minutes
+	}
+	return r
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// mdTableRows limits the rows of each Markdown table; the JSON report has the full lists.
+const mdTableRows = 20
+
+func mdEscape(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
+
+// WriteMarkdown writes a readable summary of the report.
+func (r Report) WriteMarkdown(w io.Writer, title string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Log analysis: %s\n\n", title)
+	fmt.Fprintf(&b, "%d of %d lines parsed from %s", r.ParsedLines, r.Lines, strings.Join(r.Files, ", "))
+	if r.From != "" {
+		fmt.Fprintf(&b, ", covering %s to %s", r.From, r.To)
+	}
+	b.WriteString(".\n")
+	if r.ParsedLines == 0 && r.Lines > 0 {
+		b.WriteString("\nNo structured (4.4+) log lines were found; legacy text logs are not analysed.\n")
+	}
+
+	b.WriteString("\n## Slow operations\n\n")
+	if len(r.SlowOps) == 0 {
+		b.WriteString("No slow operations logged.\n")
+	} else {
+		b.WriteString("| Namespace | Op | Shape | Count | Total ms | p50 | p95 | p99 | Max | Plan |\n")
+		b.WriteString("|---|---|---|---:|---:|---:|---:|---:|---:|---|\n")
+		for _, op := range r.SlowOps[:min(len(r.SlowOps), mdTableRows)] {
+			plans := make([]string, 0, len(op.PlanSummary))
+			for plan := range op.PlanSummary {
+				plans = append(plans, plan)
+			}
+			slices.Sort(plans)
+			fmt.Fprintf(&b, "| %s | %s | `%s` | %d | %d | %d | %d | %d | %d | %s |\n",
+				mdEscape(op.Namespace), op.Op, mdEscape(op.Shape), op.Count, op.TotalMillis,
+				op.P50Millis, op.P95Millis, op.P99Millis, op.MaxMillis, mdEscape(strings.Join(plans, ", ")))
+		}
+	}
+
+	b.WriteString("\n## Errors\n\n")
+	if len(r.ErrorCodes) == 0 && len(r.ErrorMsgs) == 0 {
+		b.WriteString("No errors logged.\n")
+	}
+	if len(r.ErrorCodes) > 0 {
+		b.WriteString("| Code | Name | Count |\n|---:|---|---:|\n")
+		for _, e := range r.ErrorCodes[:min(len(r.ErrorCodes), mdTableRows)] {
+			fmt.Fprintf(&b, "| %d | %s | %d |\n", e.Code, e.Name, e.Count)
+		}
+	}
+	if len(r.ErrorMsgs) > 0 {
+		b.WriteString("\n| Error/fatal message | Count |\n|---|---:|\n")
+		for _, m := range r.ErrorMsgs[:min(len(r.ErrorMsgs), mdTableRows)] {
+			fmt.Fprintf(&b, "| %s | %d |\n", mdEscape(m.Message), m.Count)
+		}
+	}
+
+	c := r.Connections
+	b.WriteString("\n## Connections\n\n")
+	fmt.Fprintf(&b, "- Accepted: %d, ended: %d, most open at once: %d\n", c.Accepted, c.Ended, c.MaxOpen)
+	fmt.Fprintf(&b, "- Accepted per minute: %.1f", c.AcceptedPerMinute)
+	if c.PeakMinute != "" {
+		fmt.Fprintf(&b, ", peak %d in the minute starting %s", c.PeakMinuteCount, c.PeakMinute)
+	}
+	b.WriteString("\n")
+	if len(c.TopClients) > 0 {
+		b.WriteString("\n| Client | Connections |\n|---|---:|\n")
+		for _, m := range c.TopClients[:min(len(c.TopClients), mdTableRows)] {
+			fmt.Fprintf(&b, "| %s | %d |\n", mdEscape(m.Message), m.Count)
+		}
+	}
+
+	b.WriteString("\n## Elections and step-downs\n\n")
+	if len(r.Events) == 0 {
+		b.WriteString("None logged.\n")
+	} else {
+		b.WriteString("| Time | Message | Transition | Reason |\n|---|---|---|---|\n")
+		for _, e := range r.Events {
+			transition := ""
+			if e.NewState != "" {
+				transition = e.OldState + " → " + e.NewState
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", e.Time, mdEscape(e.Message), transition, mdEscape(e.Reason))
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loganalyzer
+
+import (
+	"encoding/json"
+)
+
+// commandNames are checked in order to find the operation of a logged command, since the
+// command name is its first field and field order is not kept after parsing.
+var commandNames = []string{
+	"find", "aggregate", "count", "distinct", "findAndModify", "getMore",
+	"update", "delete", "insert", "mapReduce", "createIndexes", "geoNear",
+}
+
+// queryShape returns the operation and the shape of its filter for a slow query line.
+// Values are replaced with 1 so queries differing only in their values share a shape.
+func queryShape(attr map[string]any) (string, string) {
+	cmd, _ := attr["command"].(map[string]any)
+	op, _ := attr["type"].(string)
+	if op == "command" || op == "" {
+		op = "command"
+		for _, name := range commandNames {
+			if _, ok := cmd[name]; ok {
+				op = name
+				break
+			}
+		}
+	}
+	if op == "getMore" {
+		if orig, ok := attr["originatingCommand"].(map[string]any); ok {
+			cmd = orig
+		}
+	}
+
+	filter := findFilter(cmd)
+	if filter == nil {
+		return op, ""
+	}
+	data, err := json.Marshal(normalizeShape(filter))
+	if err != nil {
+		return op, ""
+	}
+	return op, string(data)
+}
+
+// findFilter picks the query predicate of a command.
+func findFilter(cmd map[string]any) any {
+	for _, key := range []string{"filter", "query", "q"} {
+		if f, ok := cmd[key]; ok {
+			return f
+		}
+	}
+	for _, key := range []string{"updates", "deletes"} {
+		if list, ok := cmd[key].([]any); ok && len(list) > 0 {
+			if first, ok := list[0].(map[string]any); ok {
+				return first["q"]
+			}
+		}
+	}
+	if pipeline, ok := cmd["pipeline"].([]any); ok && len(pipeline) > 0 {
+		if stage, ok := pipeline[0].(map[string]any); ok {
+			if match, ok := stage["$match"]; ok {
+				return match
+			}
+		}
+		return map[string]any{"pipeline": len(pipeline)}
+	}
+	return nil
+}
+
+// normalizeShape keeps field names and operators and replaces every value with 1.
+// Arrays of values, such as the argument of $in, become [1].
+func normalizeShape(v any) any {
+	switch val := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(val))
+		for k, child := range val {
+			out[k] = normalizeShape(child)
+		}
+		return out
+	case []any:
+		out := make([]any, 0, len(val))
+		for _, child := range val {
+			n := normalizeShape(child)
+			if _, scalar := n.(int); scalar {
+				return []any{1}
+			}
+			out = append(out, n)
+		}
+		return out
+	default:
+		return 1
+	}
+}
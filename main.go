@@ -19,10 +19,12 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -31,15 +33,22 @@ import (
 	"github.com/briandowns/spinner"
 	"golang.org/x/term"
 
+	"dcrcli/anonymizer"
+	"dcrcli/archiver"
+	"dcrcli/budget"
 	"dcrcli/collectnodes"
 	"dcrcli/dcrconfig"
 	"dcrcli/dcrlogger"
 	"dcrcli/dcroutdir"
 	"dcrcli/fscopy"
+	"dcrcli/ftdc"
 	"dcrcli/ftdcarchiver"
+	"dcrcli/loganalyzer"
 	"dcrcli/mongocredentials"
 	"dcrcli/mongologarchiver"
 	"dcrcli/mongosh"
+	"dcrcli/redactor"
+	"dcrcli/timewindow"
 	"dcrcli/topologyfinder"
 )
 
@@ -179,6 +188,258 @@ func abortIfAnyNodeUnhealthy(
 	os.Exit(1)
 }
 
+// abortIfBastionCheckFails runs `true` over SSH on every remote collection target before
+// collection starts, and terminates the run when a node cannot be reached through the
+// bastion. Without a bastion nothing is checked.
+func abortIfBastionCheckFails(
+	targets []topologyfinder.ClusterNode,
+	remoteCred *fscopy.RemoteCred,
+	dcrlog *dcrlogger.DCRLogger,
+) {
+	if remoteCred.Bastion == nil || !remoteCred.Available || remoteCred.Kube != nil {
+		return
+	}
+	dcrlog.Info(fmt.Sprintf("Bastion check: reaching remote target node(s) through %s", remoteCred.Bastion))
+
+	var unreachable []string
+	for _, n := range targets {
+		isLocal, err := isHostnameALocalHost(n.Hostname)
+		if err == nil && isLocal {
+			continue
+		}
+		username := remoteCred.UsernameFor(n.Hostname)
+		if username == "" {
+			continue
+		}
+		job := fscopy.FSCopyJob{Hosts: remoteCred.Hosts, SSH: remoteCred.SSH, Bastion: remoteCred.Bastion, Dcrlog: dcrlog}
+		job.Src.Hostname = []byte(n.Hostname)
+		job.Src.Username = []byte(username)
+		err = job.RunCommand([]string{"true"}, io.Discard)
+		if err != nil {
+			dcrlog.Error(fmt.Sprintf("Bastion check: %s is not reachable through %s: %v", n.Hostname, remoteCred.Bastion, err))
+			unreachable = append(unreachable, fmt.Sprintf("%s: %v", n.Hostname, err))
+			continue
+		}
+		dcrlog.Debug(fmt.Sprintf("Bastion check: %s is reachable", n.Hostname))
+	}
+	if len(unreachable) == 0 {
+		return
+	}
+
+	fmt.Println()
+	fmt.Printf("Cannot reach the following node(s) over SSH through bastion %s:\n", remoteCred.Bastion)
+	for _, u := range unreachable {
+		fmt.Printf("  - %s\n", u)
+	}
+	fmt.Println("Check the bastion settings in the config file and that the bastion can reach the nodes.")
+	fmt.Println()
+	dcrlog.Error(fmt.Sprintf("Terminating DCR-CLI execution: %d node(s) unreachable through the bastion", len(unreachable)))
+	os.Exit(1)
+}
+
+// reportUnreadablePaths checks, before collection starts, that the log and FTDC
+// directories of every remote or containerized target can be read the way its files are
+// read, and lists the paths that cannot. With sudo enabled and such paths on nodes read
+// over SSH the collection stops, since those files are what sudo was enabled for;
+// otherwise they are left out of the archives.
+func reportUnreadablePaths(
+	targets []topologyfinder.ClusterNode,
+	cred *mongocredentials.Mongocredentials,
+	remoteCred *fscopy.RemoteCred,
+	settings *collectionSettings,
+	dcrlog *dcrlogger.DCRLogger,
+) {
+	if !remoteCred.Available && remoteCred.Kube == nil && remoteCred.Containers == nil {
+		return
+	}
+	readingAs := "the SSH user"
+	switch {
+	case remoteCred.Kube != nil:
+		readingAs = "the mongod container"
+	case remoteCred.Sudo != nil:
+		readingAs = remoteCred.Sudo.String()
+	}
+	dcrlog.Info(fmt.Sprintf("Read check: checking the log and FTDC paths of the remote target node(s) are readable by %s", readingAs))
+
+	var problems []string
+	// only paths read over SSH can be helped with sudo
+	sudoProblems := false
+	for _, n := range targets {
+		isLocal, err := isHostnameALocalHost(n.Hostname)
+		var container *fscopy.Container
+		if err == nil && isLocal {
+			container = nodeContainer(remoteCred, n.Hostname, n.Port, dcrlog)
+			if container == nil {
+				continue
+			}
+		} else if !remoteCred.CanReach(n.Hostname) {
+			continue
+		}
+		reader := readingAs
+		if container != nil {
+			reader = container.String()
+		}
+		overSSH := container == nil && remoteCred.Kube == nil
+		node := net.JoinHostPort(n.Hostname, strconv.Itoa(n.Port))
+		cred.Currentmongodhost = n.Hostname
+		cred.Currentmongodport = strconv.Itoa(n.Port)
+		cred.SetMongoURI()
+
+		var dirs []string
+		ftdc := ftdcarchiver.RemoteFTDCarchive{}
+		ftdc.Mongo.S = cred
+		dir, err := ftdc.DiagnosticDir()
+		if err != nil {
+			dcrlog.Warn(fmt.Sprintf("Read check: cannot find the diagnostic.data dir of %s: %v", node, err))
+		} else if dir != "" {
+			dirs = append(dirs, dir)
+		}
+		logs := mongologarchiver.RemoteMongoDLogarchive{Rotation: settings.logRotation, Dcrlog: dcrlog}
+		logs.Mongo.S = cred
+		logDirs, err := logs.LogDirs()
+		if err != nil {
+			dcrlog.Warn(fmt.Sprintf("Read check: cannot find the log dir of %s: %v", node, err))
+		}
+		dirs = append(dirs, logDirs...)
+		if len(dirs) == 0 {
+			continue
+		}
+
+		job := fscopy.FSCopyJob{
+			Hosts:     remoteCred.Hosts,
+			SSH:       remoteCred.SSH,
+			Bastion:   remoteCred.Bastion,
+			Sudo:      remoteCred.Sudo,
+			Kube:      remoteCred.Kube,
+			Container: container,
+			Dcrlog:    dcrlog,
+		}
+		job.Src.Hostname = []byte(n.Hostname)
+		job.Src.Username = []byte(remoteCred.UsernameFor(n.Hostname))
+		paths, err := job.UnreadablePaths(dirs)
+		if err != nil {
+			dcrlog.Error(fmt.Sprintf("Read check: cannot check the paths of %s: %v", node, err))
+			problems = append(problems, fmt.Sprintf("%s: %v", node, err))
+			sudoProblems = sudoProblems || overSSH
+			continue
+		}
+		for _, p := range paths {
+			dcrlog.Warn(fmt.Sprintf("Read check: %s cannot read %s on %s", reader, p, node))
+			problems = append(problems, fmt.Sprintf("%s: %s (read by %s)", node, p, reader))
+			sudoProblems = sudoProblems || overSSH
+		}
+	}
+	if len(problems) == 0 {
+		return
+	}
+
+	fmt.Println()
+	fmt.Println("The following path(s) on the target nodes are missing or cannot be read:")
+	for _, p := range problems {
+		fmt.Printf("  - %s\n", p)
+	}
+	if !sudoProblems {
+		fmt.Println("Their files will be left out. Check the kubernetes and containers settings and the file permissions in the pods or containers.")
+		fmt.Println()
+		return
+	}
+	if remoteCred.Sudo == nil {
+		fmt.Println("Their files will be left out. Use -sudo or -sudo-user=mongod to read them through sudo.")
+		fmt.Println()
+		return
+	}
+	fmt.Printf("Check that %s works without a password on these nodes and can read the paths.\n", remoteCred.Sudo)
+	fmt.Println()
+	dcrlog.Error(fmt.Sprintf("Terminating DCR-CLI execution: %d path(s) unreadable with %s", len(problems), remoteCred.Sudo))
+	os.Exit(1)
+}
+
+// nodeContainer returns the container the node hostname:port on this host runs in, or nil
+// when containers are not enabled or the node runs on this host directly.
+func nodeContainer(
+	remoteCred *fscopy.RemoteCred,
+	hostname string,
+	port int,
+	dcrlog *dcrlogger.DCRLogger,
+) *fscopy.Container {
+	if remoteCred.Containers == nil {
+		return nil
+	}
+	container, err := remoteCred.Containers.Lookup(hostname, port)
+	if err != nil {
+		dcrlog.Warn(fmt.Sprintf("cannot find the container of %s:%d, reading its files from this host: %v", hostname, port, err))
+		return nil
+	}
+	return container
+}
+
+// collectionSettings are the collection options resolved from CLI flags and the config file.
+type collectionSettings struct {
+	ftdcSamplerInterval time.Duration
+	ftdcSamplerDuration time.Duration
+	syslogIdentifier    string
+	syslogFiles         []string
+	window              timewindow.Window
+	redactor            *redactor.Redactor     // nil when redaction is off
+	anonymizer          *anonymizer.Anonymizer // nil when anonymization is off
+	logRotation         mongologarchiver.LogRotation
+	skipLogAnalysis     bool
+	collectAuditLog     bool
+	keepTemp            bool  // keep the copies in ./outputs/temp after archiving
+	logsNodeLimit       int64 // size_budget.logs_per_node, 0 is unlimited
+	ftdcNodeLimit       int64
+	logsBundleBudget    *budget.Budget
+	ftdcBundleBudget    *budget.Budget
+}
+
+// nodeSizeBudgets are the size budgets the files of one node are charged to. Logs and
+// audit logs share the log budgets.
+type nodeSizeBudgets struct {
+	logs   *budget.Set
+	audit  *budget.Set
+	ftdc   *budget.Set
+	record *budget.Record
+}
+
+// parseSizeBudgets reads the size_budget config and creates the bundle budgets.
+func (s *collectionSettings) parseSizeBudgets(cfg dcrconfig.SizeBudgetConfig) error {
+	var logsPerBundle, ftdcPerBundle int64
+	for _, size := range []struct {
+		name  string
+		value string
+		dst   *int64
+	}{
+		{"logs_per_node", cfg.LogsPerNode, &s.logsNodeLimit},
+		{"ftdc_per_node", cfg.FTDCPerNode, &s.ftdcNodeLimit},
+		{"logs_per_bundle", cfg.LogsPerBundle, &logsPerBundle},
+		{"ftdc_per_bundle", cfg.FTDCPerBundle, &ftdcPerBundle},
+	} {
+		n, err := budget.ParseSize(size.value)
+		if err != nil {
+			return fmt.Errorf("size_budget.%s: %w", size.name, err)
+		}
+		*size.dst = n
+	}
+	s.logsBundleBudget = &budget.Budget{Name: "logs_per_bundle", Limit: logsPerBundle}
+	s.ftdcBundleBudget = &budget.Budget{Name: "ftdc_per_bundle", Limit: ftdcPerBundle}
+	return nil
+}
+
+// newNodeSizeBudgets starts the per-node budgets for the next node. The bundle budgets
+// carry over from the nodes collected before.
+func (s *collectionSettings) newNodeSizeBudgets() nodeSizeBudgets {
+	nodeLogs := &budget.Budget{Name: "logs_per_node", Limit: s.logsNodeLimit}
+	nodeFTDC := &budget.Budget{Name: "ftdc_per_node", Limit: s.ftdcNodeLimit}
+
+	record := &budget.Record{}
+	return nodeSizeBudgets{
+		logs:   &budget.Set{Kind: "logs", Budgets: []*budget.Budget{nodeLogs, s.logsBundleBudget}, Record: record},
+		audit:  &budget.Set{Kind: "audit", Budgets: []*budget.Budget{nodeLogs, s.logsBundleBudget}, Record: record},
+		ftdc:   &budget.Set{Kind: "ftdc", Budgets: []*budget.Budget{nodeFTDC, s.ftdcBundleBudget}, Record: record},
+		record: record,
+	}
+}
+
 func main() {
 	var err error
 
@@ -197,6 +458,96 @@ func main() {
 		"",
 		"Write a sample config file to the given path and exit. Example: ./dcrcli -generate-config dcrcli.config.json",
 	)
+	ftdcSamplerDurationFlag := flag.String(
+		"ftdc-sampler-duration",
+		"",
+		`When FTDC is disabled or unreadable on a node, sample serverStatus/replSetGetStatus for this long instead, e.g. "5m". Empty skips sampling.`,
+	)
+	ftdcSamplerIntervalFlag := flag.String(
+		"ftdc-sampler-interval",
+		"",
+		`Time between FTDC fallback sampler polls, e.g. "10s" (default 10s).`,
+	)
+	sinceFlag := flag.String(
+		"since",
+		"",
+		`Only collect mongod log lines and FTDC files from this time on: RFC3339 ("2024-03-09T10:00:00Z"), a date ("2024-03-09") or a duration before now ("36h"). Times without an offset are UTC.`,
+	)
+	redactFlag := flag.Bool(
+		"redact",
+		false,
+		"Redact query values, client addresses and user names from mongod logs and getMongoData before archiving. Rules can be customised in the config file.",
+	)
+	anonymizeFlag := flag.Bool(
+		"anonymize",
+		false,
+		"Replace hostnames, IP addresses, replica set, database and collection names with tokens in the outputs. The token mapping is written to a local file that is not part of the outputs.",
+	)
+	auditLogFlag := flag.Bool(
+		"audit-log",
+		false,
+		"Also collect the audit log of Enterprise nodes with auditLog.destination: file into auditarchive.tar.gz. With -redact, JSON audit logs are redacted and BSON ones are not collected.",
+	)
+	skipLogAnalysisFlag := flag.Bool(
+		"skip-log-analysis",
+		false,
+		"Do not write the slow query and error report (loganalysis.json/.md) for each node.",
+	)
+	keepTempFlag := flag.Bool(
+		"keep-temp",
+		false,
+		"Keep the copies of each node's logs and FTDC in ./outputs/temp instead of removing them once its archives are verified.",
+	)
+	exportFTDCFlag := flag.String(
+		"export-ftdc",
+		"",
+		"Decode an ftdcarchive.tar.gz, a diagnostic.data directory or a metrics file, export opcounters, cache usage, replication lag and tickets, print a summary and exit.",
+	)
+	exportFTDCFormatFlag := flag.String(
+		"export-ftdc-format",
+		"csv",
+		`Format of the -export-ftdc output: "csv" or "json".`,
+	)
+	exportFTDCOutFlag := flag.String(
+		"export-ftdc-out",
+		"",
+		"File written by -export-ftdc. Defaults to ftdc_export.<format> next to the archive, or in the working directory for a diagnostic.data directory.",
+	)
+	exportFTDCMetricsFlag := flag.String(
+		"export-ftdc-metrics",
+		"",
+		`Comma separated metrics exported by -export-ftdc, e.g. "opcounters,cache,replication,tickets.write". Empty exports all of them.`,
+	)
+	bwlimitFlag := flag.String(
+		"bwlimit",
+		"",
+		`Cap remote file copies at this many bytes per second, e.g. "20MB". Empty is unlimited.`,
+	)
+	lowPriorityFlag := flag.Bool(
+		"low-priority",
+		false,
+		"Read remote files at the lowest CPU and I/O priority (nice/ionice with rsync, one block at a time with the native SSH transport).",
+	)
+	streamFlag := flag.Bool(
+		"stream",
+		false,
+		"Archive the logs and FTDC files of remote nodes straight from a tar stream over SSH instead of copying them to ./outputs/temp first.",
+	)
+	sudoFlag := flag.Bool(
+		"sudo",
+		false,
+		"Read the logs and FTDC files of remote nodes through sudo -n, for files only the mongod user can read.",
+	)
+	sudoUserFlag := flag.String(
+		"sudo-user",
+		"",
+		`Read remote files through sudo -n as this user, e.g. "mongod". Implies -sudo; empty is root.`,
+	)
+	untilFlag := flag.String(
+		"until",
+		"",
+		`Only collect mongod log lines and FTDC files up to this time; same formats as -since. Empty means now.`,
+	)
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Discover MongoDB cluster nodes from a seed and collect diagnostic data (getMongoData, FTDC, logs).\n")
@@ -206,6 +557,14 @@ func main() {
 	}
 	flag.Parse()
 
+	if *exportFTDCFlag != "" {
+		err = exportFTDC(*exportFTDCFlag, *exportFTDCFormatFlag, *exportFTDCOutFlag, *exportFTDCMetricsFlag)
+		if err != nil {
+			log.Fatal("FTDC export failed: ", err)
+		}
+		os.Exit(0)
+	}
+
 	if *generateConfig != "" {
 		if err := dcrconfig.GenerateSample(*generateConfig); err != nil {
 			log.Fatal("Failed to write sample config file:", err)
@@ -220,7 +579,25 @@ func main() {
 		fmt.Println("  password       — MongoDB admin password (blank = no auth)")
 		fmt.Println("  uri_options    — extra URI options e.g. tls=true (no replicaSet)")
 		fmt.Println("  ssh_username   — OS user for passwordless SSH to remote nodes (blank = all local)")
+		fmt.Println("  ssh            — transport: openssh (default) | native; host_key_policy, known_hosts_file, identity_file, port")
+		fmt.Println("  ssh_hosts      — per-host SSH settings: match (hostname or glob), user, port, identity_file")
+		fmt.Println("  bastion        — jump host for remote nodes: host, port, user, identity_file (blank = connect directly)")
+		fmt.Println("  transfer       — remote copy limits: bandwidth_limit (e.g. 20MB per second), low_priority, retries (default 3), stream")
+		fmt.Println("  sudo           — read remote files through sudo -n: enabled, user (blank = root), sftp_server")
+		fmt.Println("  kubernetes     — read remote files from pods with kubectl exec: enabled, kubeconfig, context, namespace, container, pods")
+		fmt.Println("  containers     — read files of local nodes from their containers: enabled, runtime (default docker), mappings (match, port, container)")
 		fmt.Println("  collect_nodes  — one-secondary | all-secondaries | all-nodes (blank = prompt)")
+		fmt.Println("  ftdc_sampler_duration — sample serverStatus this long when FTDC is unavailable (blank = skip)")
+		fmt.Println("  ftdc_sampler_interval — time between sampler polls (default 10s)")
+		fmt.Println("  since / until  — only collect mongod log lines and FTDC files in this time window (blank = everything)")
+		fmt.Println("  redaction      — enabled: true redacts logs and getMongoData; rules / text_patterns override the defaults")
+		fmt.Println("  anonymize      — true replaces infrastructure names with tokens (default false)")
+		fmt.Println("  rotated_log_patterns — globs matching rotated mongod logs (default [\"{logname}*\"])")
+		fmt.Println("  rotated_log_dirs     — extra dirs searched for rotated logs, relative to the log dir")
+		fmt.Println("  skip_log_analysis    — true skips the per-node slow query and error report (default false)")
+		fmt.Println("  keep_temp            — true keeps the copies in ./outputs/temp after archiving (default false)")
+		fmt.Println("  collect_audit_log    — true also collects the audit log of Enterprise nodes (default false)")
+		fmt.Println("  size_budget          — logs/ftdc _per_node and _per_bundle caps e.g. \"500MB\", newest files kept (blank = unlimited)")
 		os.Exit(0)
 	}
 
@@ -254,6 +631,19 @@ func main() {
 	// collectModeStr merges the -collect-nodes flag with any value from the config file.
 	// A CLI flag always wins; config value is used when no flag is given.
 	collectModeStr := *collectNodesFlag
+	ftdcSamplerDurationStr := *ftdcSamplerDurationFlag
+	ftdcSamplerIntervalStr := *ftdcSamplerIntervalFlag
+	sinceStr := *sinceFlag
+	untilStr := *untilFlag
+	redactionConfig := dcrconfig.RedactionConfig{Enabled: *redactFlag}
+	anonymize := *anonymizeFlag
+	settings := collectionSettings{}
+	var sizeBudgetConfig dcrconfig.SizeBudgetConfig
+	transferConfig := dcrconfig.TransferConfig{BandwidthLimit: *bwlimitFlag, LowPriority: *lowPriorityFlag, Stream: *streamFlag}
+	sudoConfig := dcrconfig.SudoConfig{Enabled: *sudoFlag || *sudoUserFlag != "", User: *sudoUserFlag}
+	settings.skipLogAnalysis = *skipLogAnalysisFlag
+	settings.collectAuditLog = *auditLogFlag
+	settings.keepTemp = *keepTempFlag
 
 	if *configFile != "" {
 		cfg, err := dcrconfig.Load(*configFile)
@@ -291,6 +681,78 @@ func main() {
 		} else {
 			fmt.Println("  collect_nodes: (will prompt interactively)")
 		}
+		if cfg.FTDCSamplerDuration != "" {
+			fmt.Printf("  ftdc_sampler_duration: %s\n", cfg.FTDCSamplerDuration)
+		}
+		if cfg.FTDCSamplerInterval != "" {
+			fmt.Printf("  ftdc_sampler_interval: %s\n", cfg.FTDCSamplerInterval)
+		}
+		if cfg.Since != "" || cfg.Until != "" {
+			fmt.Printf("  since/until:   %s / %s\n", cfg.Since, cfg.Until)
+		}
+		if cfg.Redaction.Enabled {
+			fmt.Println("  redaction:     enabled")
+		}
+		if cfg.Anonymize {
+			fmt.Println("  anonymize:     enabled")
+		}
+		if len(cfg.RotatedLogPatterns) > 0 {
+			fmt.Printf("  rotated_log_patterns: %s\n", strings.Join(cfg.RotatedLogPatterns, ", "))
+		}
+		if len(cfg.RotatedLogDirs) > 0 {
+			fmt.Printf("  rotated_log_dirs: %s\n", strings.Join(cfg.RotatedLogDirs, ", "))
+		}
+		if cfg.SkipLogAnalysis {
+			fmt.Println("  skip_log_analysis: true")
+		}
+		if cfg.KeepTemp {
+			fmt.Println("  keep_temp: true")
+		}
+		if cfg.CollectAuditLog {
+			fmt.Println("  collect_audit_log: true")
+		}
+		if cfg.SSH.Transport != "" {
+			fmt.Printf("  ssh:           transport %s", cfg.SSH.Transport)
+			if cfg.SSH.HostKeyPolicy != "" {
+				fmt.Printf(", host_key_policy %s", cfg.SSH.HostKeyPolicy)
+			}
+			fmt.Println()
+		}
+		if len(cfg.SSHHosts) > 0 {
+			matches := make([]string, 0, len(cfg.SSHHosts))
+			for _, h := range cfg.SSHHosts {
+				matches = append(matches, h.Match)
+			}
+			fmt.Printf("  ssh_hosts:     %s\n", strings.Join(matches, ", "))
+		}
+		if cfg.Transfer != (dcrconfig.TransferConfig{}) {
+			fmt.Printf(
+				"  transfer:      bandwidth_limit %s, low_priority %v, retries %d, stream %v\n",
+				cfg.Transfer.BandwidthLimit, cfg.Transfer.LowPriority, cfg.Transfer.Retries, cfg.Transfer.Stream,
+			)
+		}
+		if cfg.Bastion.Host != "" {
+			fmt.Printf("  bastion:       %s\n", cfg.Bastion.Host)
+		}
+		if cfg.Sudo.Enabled {
+			fmt.Printf("  sudo:          user %q\n", cfg.Sudo.User)
+		}
+		if cfg.Containers.Enabled {
+			fmt.Printf("  containers:    runtime %q, %d mappings\n", cfg.Containers.Runtime, len(cfg.Containers.Mappings))
+		}
+		if cfg.Kubernetes.Enabled {
+			fmt.Printf(
+				"  kubernetes:    context %q, container %q, %d pods entries\n",
+				cfg.Kubernetes.Context, cfg.Kubernetes.Container, len(cfg.Kubernetes.Pods),
+			)
+		}
+		if cfg.SizeBudget != (dcrconfig.SizeBudgetConfig{}) {
+			fmt.Printf(
+				"  size_budget:   logs %s/node %s/bundle, ftdc %s/node %s/bundle\n",
+				cfg.SizeBudget.LogsPerNode, cfg.SizeBudget.LogsPerBundle,
+				cfg.SizeBudget.FTDCPerNode, cfg.SizeBudget.FTDCPerBundle,
+			)
+		}
 		fmt.Println()
 
 		if err := cred.GetFromConfig(cfg); err != nil {
@@ -301,11 +763,52 @@ func main() {
 			os.Exit(1)
 		}
 
-		remoteCred.GetFromConfig(cfg)
+		if err := remoteCred.GetFromConfig(cfg); err != nil {
+			dcrlog.Error(err.Error())
+			fmt.Println()
+			fmt.Println("Config validation failed:", err)
+			fmt.Println("Fix the value in", *configFile, "and re-run.")
+			os.Exit(1)
+		}
+		defer remoteCred.SSH.Close()
 
 		if collectModeStr == "" {
 			collectModeStr = cfg.CollectNodes
 		}
+		if ftdcSamplerDurationStr == "" {
+			ftdcSamplerDurationStr = cfg.FTDCSamplerDuration
+		}
+		if ftdcSamplerIntervalStr == "" {
+			ftdcSamplerIntervalStr = cfg.FTDCSamplerInterval
+		}
+		if sinceStr == "" {
+			sinceStr = cfg.Since
+		}
+		if untilStr == "" {
+			untilStr = cfg.Until
+		}
+		redactionConfig.Config = cfg.Redaction.Config
+		redactionConfig.Enabled = redactionConfig.Enabled || cfg.Redaction.Enabled
+		anonymize = anonymize || cfg.Anonymize
+		settings.syslogIdentifier = strings.TrimSpace(cfg.SyslogIdentifier)
+		settings.syslogFiles = cfg.SyslogFiles
+		settings.logRotation.Patterns = cfg.RotatedLogPatterns
+		settings.logRotation.Dirs = cfg.RotatedLogDirs
+		settings.skipLogAnalysis = settings.skipLogAnalysis || cfg.SkipLogAnalysis
+		settings.collectAuditLog = settings.collectAuditLog || cfg.CollectAuditLog
+		settings.keepTemp = settings.keepTemp || cfg.KeepTemp
+		sizeBudgetConfig = cfg.SizeBudget
+		if transferConfig.BandwidthLimit == "" {
+			transferConfig.BandwidthLimit = cfg.Transfer.BandwidthLimit
+		}
+		transferConfig.LowPriority = transferConfig.LowPriority || cfg.Transfer.LowPriority
+		transferConfig.Retries = cfg.Transfer.Retries
+		transferConfig.Stream = transferConfig.Stream || cfg.Transfer.Stream
+		if sudoConfig.User == "" {
+			sudoConfig.User = cfg.Sudo.User
+		}
+		sudoConfig.Enabled = sudoConfig.Enabled || cfg.Sudo.Enabled
+		sudoConfig.SFTPServer = cfg.Sudo.SFTPServer
 	} else {
 		err = cred.Get()
 		if err != nil {
@@ -315,11 +818,82 @@ func main() {
 		remoteCred.Get()
 	}
 
+	settings.ftdcSamplerDuration, err = parseDurationSetting("ftdc_sampler_duration", ftdcSamplerDurationStr, 0)
+	if err != nil {
+		dcrlog.Error(err.Error())
+		log.Fatal(err)
+	}
+	settings.ftdcSamplerInterval, err = parseDurationSetting("ftdc_sampler_interval", ftdcSamplerIntervalStr, 10*time.Second)
+	if err != nil {
+		dcrlog.Error(err.Error())
+		log.Fatal(err)
+	}
+	settings.window, err = timewindow.Parse(sinceStr, untilStr, time.Now())
+	if err != nil {
+		dcrlog.Error(err.Error())
+		log.Fatal("Invalid -since/-until: ", err)
+	}
+	if !settings.window.IsZero() {
+		dcrlog.Info(fmt.Sprintf("collecting mongod logs and FTDC in time window %s", settings.window))
+	}
+	err = settings.parseSizeBudgets(sizeBudgetConfig)
+	if err != nil {
+		dcrlog.Error(err.Error())
+		log.Fatal("Invalid size_budget: ", err)
+	}
+	remoteCred.Transfer, err = fscopy.NewTransfer(transferConfig)
+	if err != nil {
+		dcrlog.Error(err.Error())
+		log.Fatal("Invalid transfer settings: ", err)
+	}
+	if remoteCred.SSH != nil {
+		remoteCred.SSH.Transfer = remoteCred.Transfer
+	}
+	dcrlog.Info(fmt.Sprintf("remote transfers: %s", remoteCred.Transfer))
+	remoteCred.Sudo, err = fscopy.NewSudo(sudoConfig)
+	if err != nil {
+		dcrlog.Error(err.Error())
+		log.Fatal("Invalid sudo settings: ", err)
+	}
+	if remoteCred.Sudo != nil {
+		dcrlog.Info(fmt.Sprintf("remote files are read with %s", remoteCred.Sudo))
+		if remoteCred.SSH != nil {
+			remoteCred.SSH.Sudo = remoteCred.Sudo
+		}
+	}
+	if remoteCred.Kube != nil {
+		dcrlog.Info(fmt.Sprintf("remote files are read from the pods with %s", remoteCred.Kube))
+		if remoteCred.Sudo != nil {
+			dcrlog.Warn("sudo is ignored for nodes read with kubectl exec")
+		}
+	}
+	err = settings.logRotation.Validate()
+	if err != nil {
+		dcrlog.Error(err.Error())
+		log.Fatal("Invalid rotated_log_patterns: ", err)
+	}
+	if redactionConfig.Enabled {
+		settings.redactor, err = redactor.New(redactionConfig.Rules())
+		if err != nil {
+			dcrlog.Error(err.Error())
+			log.Fatal("Invalid redaction config: ", err)
+		}
+		dcrlog.Info("redaction of logs and getMongoData is enabled")
+	}
+	if anonymize {
+		settings.anonymizer = anonymizer.New()
+		dcrlog.Info("anonymization of outputs is enabled")
+	}
+
 	s := spinner.New(spinner.CharSets[11], 100*time.Millisecond)
 	s.Start()
 
 	outputdir := dcroutdir.DCROutputDir{}
-	outputdir.OutputPrefix = checkEmptyDirectory("./outputs/" + cred.Clustername + "/")
+	outputClusterName := cred.Clustername
+	if settings.anonymizer != nil {
+		outputClusterName = settings.anonymizer.Token(anonymizer.KindCluster, cred.Clustername)
+	}
+	outputdir.OutputPrefix = checkEmptyDirectory("./outputs/" + outputClusterName + "/")
 
 	dcrlog.Info(
 		fmt.Sprintf(
@@ -364,6 +938,14 @@ func main() {
 		dcrlog.Warn(fmt.Sprintf("Could not fully resolve replica roles (collection may be limited): %s", err.Error()))
 	}
 
+	if settings.anonymizer != nil {
+		// register every discovered host so tokens follow topology order
+		settings.anonymizer.Host(cred.Seedmongodhost)
+		for _, node := range clustertopology.Allnodes.Nodes {
+			settings.anonymizer.Host(node.Hostname)
+		}
+	}
+
 	// Stop spinner so terminal echo and the collect-nodes prompt are visible (spinner redraw would hide input).
 	s.Stop()
 	fmt.Println()
@@ -437,9 +1019,13 @@ func main() {
 	// against live (typically production) clusters, so taking on additional risk while
 	// a node is down is unacceptable.
 	abortIfAnyNodeUnhealthy(clustertopology.Allnodes.Nodes, "pre-collection", &dcrlog)
+	abortIfBastionCheckFails(collectTargets, &remoteCred, &dcrlog)
+	reportUnreadablePaths(collectTargets, &cred, &remoteCred, &settings, &dcrlog)
 
 	s.Start()
 
+	// the most a node's copies took in the temp dir, and what is left there
+	var peakTempUsage, keptTempUsage int64
 	for _, host := range collectTargets {
 
 		// Per-iteration cluster-wide health gate: re-probe every node before moving on
@@ -449,9 +1035,42 @@ func main() {
 
 		dcrlog.Info(fmt.Sprintf("Collecting logs for MongoDB node - host: %s, port: %d", host.Hostname, host.Port))
 		fmt.Printf("\nCollecting logs for MongoDB node %s:%d\n", host.Hostname, host.Port)
+		isLocalHost := false
+		var errtest error
+
+		hostname := host.Hostname
+		isLocalHost, errtest = isHostnameALocalHost(hostname)
+		if errtest != nil {
+			dcrlog.Error(
+				fmt.Sprintf(
+					"Error determining if Hostname is a LocalHost or not. Assuming Remote node: %v",
+					errtest,
+				),
+			)
+			// log.Fatal("Error determining if Hostname is a LocalHost or not :", errtest)
+		}
+
+		// a node in a container on this host is copied like a remote one, through the runtime
+		var container *fscopy.Container
+		if isLocalHost {
+			container = nodeContainer(&remoteCred, hostname, host.Port, &dcrlog)
+			if container != nil {
+				dcrlog.Info(fmt.Sprintf("%s:%d runs in %s", hostname, host.Port, container))
+				isLocalHost = false
+			}
+		}
+
 		// determine if the data collection should abort due to not enough free space
-		// we keep approx 1GB as limit
-		fsHasFreeSpace, err := hasFreeSpace()
+		// we keep approx 1GB as limit for the copies in the temp dir, or room for as much as
+		// the largest node copied so far took there, since the nodes of a cluster are alike;
+		// streamed nodes only need room for getMongoData here, their archives are checked
+		// against the sizes of the files on the node before they are streamed; redacted logs
+		// are still copied to the temp dir
+		neededGB := max(1.1, 0.1+float64(peakTempUsage)/(1024*1024*1024))
+		if !isLocalHost && mongologarchiver.StreamsLogs(remoteCred.Transfer, settings.redactor) {
+			neededGB = 0.1
+		}
+		fsHasFreeSpace, err := hasFreeSpace(neededGB)
 		if err != nil {
 			dcrlog.Warn("Warning cannot check free space for data collection.")
 			fmt.Println(
@@ -468,6 +1087,9 @@ func main() {
 		cred.SetMongoURI()
 
 		outputdir.Hostname = cred.Currentmongodhost
+		if settings.anonymizer != nil {
+			outputdir.Hostname = settings.anonymizer.Host(cred.Currentmongodhost)
+		}
 		outputdir.Port = cred.Currentmongodport
 		err = outputdir.CreateDCROutputDir()
 		if err != nil {
@@ -488,6 +1110,11 @@ func main() {
 		err = c.RunMongoShellWithEval()
 		if err != nil {
 			dcrlog.Error(fmt.Sprintf("Error Running getMongoData %v", err))
+		} else if settings.redactor != nil {
+			err = settings.redactor.RedactGetMongoDataFile(c.FilePathOnDisk)
+			if err != nil {
+				dcrlog.Error(fmt.Sprintf("Error redacting getMongoData %v", err))
+			}
 		}
 
 		isAliveAfter, err := isMongoNodeAlive(host.Hostname, host.Port)
@@ -508,52 +1135,76 @@ func main() {
 			dcrlog.Info(fmt.Sprintf("MongoDB node %s:%d is reachable after collecting getMongoData...", host.Hostname, host.Port))
 		}
 
-		isLocalHost := false
-		var errtest error
-
-		hostname := host.Hostname
-		isLocalHost, errtest = isHostnameALocalHost(hostname)
-		if errtest != nil {
-			dcrlog.Error(
-				fmt.Sprintf(
-					"Error determining if Hostname is a LocalHost or not. Assuming Remote node: %v",
-					errtest,
-				),
-			)
-			// log.Fatal("Error determining if Hostname is a LocalHost or not :", errtest)
-		}
+		sizeBudgets := settings.newNodeSizeBudgets()
+		var nodeTempdir *dcroutdir.DCROutputDir // nil when nothing was copied to the temp dir
 
 		if isLocalHost {
 			dcrlog.Info(
 				fmt.Sprintf("%s is a local hostname. Performing Local Copying.", hostname),
 			)
 
+			tempdir := createTempOutputDir(&cred, &dcrlog)
+			nodeTempdir = &tempdir
+			localJob := fscopy.FSCopyJob{}
+			localJob.Src.IsLocal = true
+			localJob.Dcrlog = &dcrlog
+			localJobWithPattern := fscopy.FSCopyJobWithPattern{}
+			localJobWithPattern.Dcrlog = &dcrlog
+			localJobWithPattern.CopyJobDetails = &localJob
+
 			dcrlog.Info("Running FTDC Archiving")
 			ftdcarchive := ftdcarchiver.FTDCarchive{}
 			ftdcarchive.Mongo.S = &cred
 			ftdcarchive.Outputdir = &outputdir
+			ftdcarchive.TempOutputdir = &tempdir
+			ftdcarchive.CopyJob = &localJob
+			ftdcarchive.Window = settings.window
+			ftdcarchive.SizeBudget = sizeBudgets.ftdc
 			err = ftdcarchive.Start()
 			if err != nil {
 				dcrlog.Error(fmt.Sprintf("Error in FTDCArchive: %v", err))
 				// log.Fatal("Error in FTDCArchive: ", err)
+				runMetricsSamplerIfFTDCUnavailable(err, &cred, &outputdir, &settings, &dcrlog)
 			}
 
 			dcrlog.Info("Running mongo log Archiving")
 			logarchive := mongologarchiver.MongoDLogarchive{}
 			logarchive.Mongo.S = &cred
 			logarchive.Outputdir = &outputdir
+			logarchive.TempOutputdir = &tempdir
+			logarchive.CopyJob = &localJobWithPattern
+			logarchive.Window = settings.window
+			logarchive.Redactor = settings.redactor
+			logarchive.Rotation = settings.logRotation
+			logarchive.SizeBudget = sizeBudgets.logs
 			logarchive.Dcrlog = &dcrlog
 			err = logarchive.Start()
 			if err != nil {
 				dcrlog.Error(fmt.Sprintf("Error in LogArchive: %v", err))
 				// log.Fatal("Error in LogArchive:", err)
+				archiveSyslogOrRAMLog(err, &localJob, &cred, &outputdir, &settings, &dcrlog)
+			}
+
+			if settings.collectAuditLog {
+				archiveAuditLog(&localJobWithPattern, &tempdir, &cred, &outputdir, &settings, sizeBudgets.audit, &dcrlog)
 			}
 
 		} else {
-			if remoteCred.Available {
-				dcrlog.Info(fmt.Sprintf("%s is not a local hostname. Proceeding with remote Copier.", hostname))
+			if container != nil || remoteCred.CanReach(hostname) {
+				if container != nil {
+					dcrlog.Info(fmt.Sprintf("Copying the files of %s from %s.", hostname, container))
+				} else {
+					dcrlog.Info(fmt.Sprintf("%s is not a local hostname. Proceeding with remote Copier.", hostname))
+				}
 
 				remotecopyJob := fscopy.FSCopyJob{}
+				remotecopyJob.Hosts = remoteCred.Hosts
+				remotecopyJob.SSH = remoteCred.SSH
+				remotecopyJob.Bastion = remoteCred.Bastion
+				remotecopyJob.Transfer = remoteCred.Transfer
+				remotecopyJob.Sudo = remoteCred.Sudo
+				remotecopyJob.Kube = remoteCred.Kube
+				remotecopyJob.Container = container
 				remotecopyJob.Dcrlog = &dcrlog
 
 				dcrlog.Info("Running FTDC Archiving")
@@ -561,24 +1212,15 @@ func main() {
 				remoteFTDCArchiver.RemoteCopyJob = &remotecopyJob
 				remoteFTDCArchiver.Mongo.S = &cred
 				remoteFTDCArchiver.Outputdir = &outputdir
+				remoteFTDCArchiver.Window = settings.window
+				remoteFTDCArchiver.SizeBudget = sizeBudgets.ftdc
 
-				tempdir := dcroutdir.DCROutputDir{}
-				tempdir.OutputPrefix = "./outputs/temp/" + cred.Clustername + "/"
-				tempdir.Hostname = cred.Currentmongodhost
-				tempdir.Port = cred.Currentmongodport
-				err = tempdir.CreateDCROutputDir()
-				if err != nil {
-					dcrlog.Error(
-						"Error creating temp output Directory for storing remote DCR outputs",
-					)
-					log.Fatal(
-						"Error creating temp output Directory for storing remote DCR outputs",
-					)
-				}
+				tempdir := createTempOutputDir(&cred, &dcrlog)
+				nodeTempdir = &tempdir
 
 				remoteFTDCArchiver.TempOutputdir = &tempdir
 				remoteFTDCArchiver.RemoteCopyJob.Src.IsLocal = false
-				remoteFTDCArchiver.RemoteCopyJob.Src.Username = []byte(remoteCred.Username)
+				remoteFTDCArchiver.RemoteCopyJob.Src.Username = []byte(remoteCred.UsernameFor(hostname))
 				remoteFTDCArchiver.RemoteCopyJob.Src.Hostname = []byte(cred.Currentmongodhost)
 
 				var buffer bytes.Buffer
@@ -592,6 +1234,7 @@ func main() {
 				if err != nil {
 					dcrlog.Error(fmt.Sprintf("Error in Remote FTDC Archive for this node: %v", err))
 					// log.Fatal("Error in Remote FTDC Archive: ", err)
+					runMetricsSamplerIfFTDCUnavailable(err, &cred, &outputdir, &settings, &dcrlog)
 				}
 
 				dcrlog.Debug(fmt.Sprintf("remote copy job output %s:", buffer.String()))
@@ -607,15 +1250,55 @@ func main() {
 				remoteLogArchiver.Mongo.S = &cred
 				remoteLogArchiver.Outputdir = &outputdir
 				remoteLogArchiver.TempOutputdir = &tempdir
+				remoteLogArchiver.Window = settings.window
+				remoteLogArchiver.Redactor = settings.redactor
+				remoteLogArchiver.Rotation = settings.logRotation
+				remoteLogArchiver.SizeBudget = sizeBudgets.logs
 				remoteLogArchiver.Dcrlog = &dcrlog
 
 				err = remoteLogArchiver.Start()
 				if err != nil {
 					dcrlog.Error(fmt.Sprintf("Error in Remote Log Archive for this node: %v", err))
 					// log.Fatal("Error in Remote Log Archive: ", err)
+					archiveSyslogOrRAMLog(err, &remotecopyJob, &cred, &outputdir, &settings, &dcrlog)
 				}
 				dcrlog.Debug(fmt.Sprintf("remote copy job output %s:", buffer.String()))
 				remotecopyJob.Output.Reset()
+
+				if settings.collectAuditLog {
+					archiveAuditLog(&remotecopyJobWithPattern, &tempdir, &cred, &outputdir, &settings, sizeBudgets.audit, &dcrlog)
+				}
+			} else {
+				dcrlog.Warn(fmt.Sprintf("%s is not a local hostname and no SSH username is set; log and FTDC files cannot be copied", hostname))
+				runMetricsSamplerIfFTDCUnavailable(
+					fmt.Errorf("no SSH username configured for remote node: %w", ftdcarchiver.ErrFTDCUnavailable),
+					&cred, &outputdir, &settings, &dcrlog,
+				)
+				runRAMLogFallback("remote node and no SSH username configured", &cred, &outputdir, &settings, &dcrlog)
+			}
+		}
+
+		if nodeTempdir != nil {
+			usage, kept := cleanUpTempDir(nodeTempdir, &outputdir, settings.keepTemp, &dcrlog)
+			peakTempUsage = max(peakTempUsage, usage)
+			if kept {
+				keptTempUsage += usage
+			}
+		}
+
+		err = sizeBudgets.record.Write(outputdir.Path())
+		if err != nil {
+			dcrlog.Error(fmt.Sprintf("Error writing size budget record: %v", err))
+		}
+
+		if !settings.skipLogAnalysis {
+			analyzeNodeLogs(&outputdir, &dcrlog)
+		}
+
+		if settings.redactor != nil {
+			err = settings.redactor.WriteRecord(outputdir.Path())
+			if err != nil {
+				dcrlog.Error(fmt.Sprintf("Error writing redaction record: %v", err))
 			}
 		}
 
@@ -623,11 +1306,361 @@ func main() {
 
 	s.Stop()
 
+	tempPrefix := "./outputs/temp/" + cred.Clustername
+	if keptTempUsage > 0 {
+		fmt.Printf("Copies of the collected files (%s) are kept in %s\n", budget.FormatSize(keptTempUsage), tempPrefix)
+	} else {
+		// fails when other clusters or kept nodes still have data there
+		if os.Remove(tempPrefix) == nil {
+			os.Remove("./outputs/temp")
+		}
+	}
+
+	if settings.anonymizer != nil {
+		anonymizeOutputs(settings.anonymizer, outputdir.OutputPrefix, &dcrlog)
+	}
+
 	fmt.Println("Data collection completed outputs directory location: ", outputdir.OutputPrefix)
 	dcrlog.Info("---End of Script Execution----")
 }
 
-func hasFreeSpace() (bool, error) {
+// exportFTDC decodes the FTDC files at path, writes the selected metrics to out as CSV
+// or JSON and prints a summary.
+func exportFTDC(path string, format string, out string, metrics string) error {
+	if format != "csv" && format != "json" {
+		return fmt.Errorf("invalid -export-ftdc-format %q: use csv or json", format)
+	}
+	var prefixes []string
+	for _, m := range strings.Split(metrics, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			prefixes = append(prefixes, m)
+		}
+	}
+	columns, err := ftdc.SelectColumns(ftdc.DefaultColumns(), prefixes)
+	if err != nil {
+		return err
+	}
+
+	export := ftdc.NewExport(columns)
+	files, err := ftdc.ReadPath(path, export.Add)
+	if err != nil {
+		return err
+	}
+	export.Sort()
+
+	if out == "" {
+		out = "ftdc_export." + format
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			out = filepath.Join(filepath.Dir(path), out)
+		}
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if format == "json" {
+		err = export.WriteJSON(f)
+	} else {
+		err = export.WriteCSV(f)
+	}
+	if err != nil {
+		return fmt.Errorf("error writing %s: %w", out, err)
+	}
+
+	fmt.Printf("Read %d FTDC file(s) from %s\n", len(files), path)
+	err = export.WriteSummary(os.Stdout)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Time series written to:", out)
+	return nil
+}
+
+// analyzeNodeLogs writes the slow query and error report for the logs collected from a node.
+func analyzeNodeLogs(outputdir *dcroutdir.DCROutputDir, dcrlog *dcrlogger.DCRLogger) {
+	dcrlog.Info("Running log analysis")
+	analysis := loganalyzer.LogAnalysis{}
+	analysis.Outputdir = outputdir
+	analysis.Dcrlog = dcrlog
+	err := analysis.Start()
+	if errors.Is(err, loganalyzer.ErrNoLogs) {
+		dcrlog.Info("No collected logs to analyse for this node")
+		return
+	}
+	if err != nil {
+		dcrlog.Error(fmt.Sprintf("Error in LogAnalysis: %v", err))
+	}
+}
+
+// anonymizeOutputs replaces infrastructure names in everything collected under dir once all
+// nodes are done, so names first seen on a later node are also replaced in earlier ones.
+// The token mapping is written to the working directory, outside ./outputs.
+func anonymizeOutputs(anon *anonymizer.Anonymizer, dir string, dcrlog *dcrlogger.DCRLogger) {
+	dcrlog.Info("Anonymizing outputs")
+	err := anon.LearnDir(dir)
+	if err != nil {
+		dcrlog.Warn(fmt.Sprintf("Some names could not be learned from getMongoData: %v", err))
+	}
+
+	stamp := time.Now().Format("2006-01-02T15-04-05")
+	// FTDC holds hostnames and replica set names in binary form; keep it out of the bundle
+	withheldDir := fmt.Sprintf("./dcrcli-ftdc-not-anonymized_%s", stamp)
+	withheld, err := anonymizer.WithholdBinaryArchives(dir, withheldDir)
+	if err != nil {
+		dcrlog.Error(fmt.Sprintf("Error moving FTDC archives out of the outputs: %v", err))
+		fmt.Printf("\nWARNING: some FTDC archives could not be moved out of %s and are not anonymized; remove them before sharing: %v\n", dir, err)
+	}
+	for _, path := range withheld {
+		dcrlog.Warn(fmt.Sprintf("not anonymized, moved out of the outputs: %s", path))
+	}
+	if len(withheld) > 0 {
+		fmt.Printf(
+			"\nWARNING: FTDC cannot be anonymized. %d FTDC archive(s) were moved out of the outputs to %s; "+
+				"add them to the bundle only if sharing real hostnames is acceptable.\n",
+			len(withheld), withheldDir,
+		)
+	}
+
+	skipped, err := anon.AnonymizeDir(dir)
+	if err != nil {
+		dcrlog.Error(fmt.Sprintf("Error anonymizing outputs: %v", err))
+		fmt.Printf("\nWARNING: anonymization failed for some files, review them before sharing: %v\n", err)
+	}
+	for _, path := range skipped {
+		dcrlog.Warn(fmt.Sprintf("not anonymized (binary data): %s", path))
+	}
+	if len(skipped) > 0 {
+		fmt.Printf("\nWARNING: %d file(s) in the outputs could not be anonymized and are listed in the dcrcli log; review them before sharing.\n", len(skipped))
+	}
+
+	mappingFile := fmt.Sprintf("./dcrcli-anonymization-map_%s.json", stamp)
+	err = anon.WriteMapping(mappingFile)
+	if err != nil {
+		dcrlog.Error(fmt.Sprintf("Error writing anonymization mapping: %v", err))
+		return
+	}
+	fmt.Printf("\nAnonymization mapping written to %s. Keep it private; it is not part of the outputs.\n", mappingFile)
+}
+
+// parseDurationSetting parses a duration flag or config value such as "5m".
+// An empty value yields fallback; errors name the config field so the user knows what to fix.
+func parseDurationSetting(field string, value string, fallback time.Duration) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("config field %q: invalid duration %q: %w", field, value, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("config field %q: duration %q must not be negative", field, value)
+	}
+	return d, nil
+}
+
+// runMetricsSamplerIfFTDCUnavailable collects serverStatus/replSetGetStatus samples in place of
+// FTDC when ftdcErr says FTDC is disabled or unreadable on the current node.
+// The sampler only runs when a sampler duration was configured; otherwise a hint is printed.
+func runMetricsSamplerIfFTDCUnavailable(
+	ftdcErr error,
+	cred *mongocredentials.Mongocredentials,
+	outputdir *dcroutdir.DCROutputDir,
+	settings *collectionSettings,
+	dcrlog *dcrlogger.DCRLogger,
+) {
+	if !errors.Is(ftdcErr, ftdcarchiver.ErrFTDCUnavailable) {
+		return
+	}
+	interval, duration := settings.ftdcSamplerInterval, settings.ftdcSamplerDuration
+
+	node := cred.Currentmongodhost + ":" + cred.Currentmongodport
+	if duration == 0 {
+		dcrlog.Warn(fmt.Sprintf("FTDC unavailable for %s and no sampler duration configured", node))
+		fmt.Printf(
+			"\nWARNING: FTDC is not available for %s. Re-run with -ftdc-sampler-duration (e.g. 5m) to collect serverStatus samples instead.\n",
+			node,
+		)
+		return
+	}
+
+	fmt.Printf("\nFTDC is not available for %s; sampling serverStatus for %s\n", node, duration)
+	sampler := ftdcarchiver.MetricsSampler{}
+	sampler.Mongo.S = cred
+	sampler.Interval = interval
+	sampler.Duration = duration
+	sampler.Reason = ftdcErr.Error()
+	sampler.Outputdir = outputdir
+	sampler.Dcrlog = dcrlog
+	err := sampler.Start()
+	if err != nil {
+		dcrlog.Error(fmt.Sprintf("Error in FTDC fallback sampler: %v", err))
+	}
+}
+
+// archiveSyslogOrRAMLog handles a failed mongod log archive. Nodes logging to syslog are
+// collected from journald or the syslog files through commandJob; when that is not possible
+// the getLog RAM log is captured instead.
+func archiveSyslogOrRAMLog(
+	logErr error,
+	commandJob *fscopy.FSCopyJob,
+	cred *mongocredentials.Mongocredentials,
+	outputdir *dcroutdir.DCROutputDir,
+	settings *collectionSettings,
+	dcrlog *dcrlogger.DCRLogger,
+) {
+	if !errors.Is(logErr, mongologarchiver.ErrSyslogDestination) {
+		runRAMLogFallback(logErr.Error(), cred, outputdir, settings, dcrlog)
+		return
+	}
+
+	dcrlog.Info("Running syslog/journald log Archiving")
+	syslogarchive := mongologarchiver.SyslogArchive{}
+	syslogarchive.Mongo.S = cred
+	syslogarchive.CommandJob = commandJob
+	syslogarchive.Identifier = settings.syslogIdentifier
+	syslogarchive.SyslogFiles = settings.syslogFiles
+	syslogarchive.Since = settings.window.Since
+	syslogarchive.Until = settings.window.Until
+	syslogarchive.Redactor = settings.redactor
+	syslogarchive.Outputdir = outputdir
+	syslogarchive.Dcrlog = dcrlog
+	err := syslogarchive.Start()
+	if err != nil {
+		dcrlog.Error(fmt.Sprintf("Error in Syslog Archive: %v", err))
+		runRAMLogFallback(err.Error(), cred, outputdir, settings, dcrlog)
+	}
+}
+
+// createTempOutputDir creates the ./outputs/temp/<cluster>/<host>_<port> dir the files of
+// the current node are copied to before they are archived.
+func createTempOutputDir(cred *mongocredentials.Mongocredentials, dcrlog *dcrlogger.DCRLogger) dcroutdir.DCROutputDir {
+	tempdir := dcroutdir.DCROutputDir{}
+	tempdir.OutputPrefix = "./outputs/temp/" + cred.Clustername + "/"
+	tempdir.Hostname = cred.Currentmongodhost
+	tempdir.Port = cred.Currentmongodport
+	err := tempdir.CreateDCROutputDir()
+	if err != nil {
+		dcrlog.Error("Error creating temp output Directory for storing DCR outputs")
+		log.Fatal("Error creating temp output Directory for storing DCR outputs")
+	}
+	return tempdir
+}
+
+// cleanUpTempDir measures the copies of a node in tempdir and removes them once every
+// archive of the node in outputdir verifies, unless keep is set. It returns the bytes the
+// copies took and whether they were kept.
+func cleanUpTempDir(
+	tempdir *dcroutdir.DCROutputDir,
+	outputdir *dcroutdir.DCROutputDir,
+	keep bool,
+	dcrlog *dcrlogger.DCRLogger,
+) (int64, bool) {
+	usage, err := tempdir.Usage()
+	if err != nil {
+		dcrlog.Warn(fmt.Sprintf("cannot measure temp dir %s: %v", tempdir.Path(), err))
+	}
+	dcrlog.Info(fmt.Sprintf("temp dir %s holds %s", tempdir.Path(), budget.FormatSize(usage)))
+	if keep {
+		dcrlog.Info(fmt.Sprintf("keeping temp dir %s (-keep-temp)", tempdir.Path()))
+		return usage, true
+	}
+
+	archives, err := filepath.Glob(filepath.Join(outputdir.Path(), "*.tar.gz"))
+	if err != nil {
+		dcrlog.Warn(fmt.Sprintf("keeping temp dir %s: cannot list the archives: %v", tempdir.Path(), err))
+		return usage, true
+	}
+	for _, archive := range archives {
+		entries, err := archiver.Verify(archive)
+		if err != nil {
+			dcrlog.Warn(fmt.Sprintf("keeping temp dir %s: archive does not verify: %v", tempdir.Path(), err))
+			fmt.Printf("WARNING: %s could not be verified; the copies it was made from are kept in %s\n", archive, tempdir.Path())
+			return usage, true
+		}
+		dcrlog.Debug(fmt.Sprintf("verified %s: %d entries", archive, entries))
+	}
+
+	err = tempdir.Remove()
+	if err != nil {
+		dcrlog.Warn(fmt.Sprintf("cannot remove temp dir %s: %v", tempdir.Path(), err))
+		return usage, true
+	}
+	dcrlog.Info(fmt.Sprintf("removed temp dir %s after verifying %d archive(s)", tempdir.Path(), len(archives)))
+	return usage, false
+}
+
+// archiveAuditLog collects the audit log of the current node into auditarchive.tar.gz.
+// Local, remote and containerized nodes alike are copied with copyJob into tempdir first.
+func archiveAuditLog(
+	copyJob *fscopy.FSCopyJobWithPattern,
+	tempdir *dcroutdir.DCROutputDir,
+	cred *mongocredentials.Mongocredentials,
+	outputdir *dcroutdir.DCROutputDir,
+	settings *collectionSettings,
+	sizes *budget.Set,
+	dcrlog *dcrlogger.DCRLogger,
+) {
+	dcrlog.Info("Running audit log Archiving")
+	auditarchive := mongologarchiver.AuditLogarchive{}
+	auditarchive.Mongo.S = cred
+	auditarchive.CopyJob = copyJob
+	auditarchive.TempOutputdir = tempdir
+	auditarchive.Window = settings.window
+	auditarchive.Rotation = settings.logRotation
+	auditarchive.SizeBudget = sizes
+	auditarchive.Redactor = settings.redactor
+	auditarchive.Outputdir = outputdir
+	auditarchive.Dcrlog = dcrlog
+	err := auditarchive.Start()
+	if errors.Is(err, mongologarchiver.ErrNoAuditLog) {
+		dcrlog.Info(fmt.Sprintf("Audit log not collected: %v", err))
+		return
+	}
+	if errors.Is(err, mongologarchiver.ErrAuditLogNotRedactable) {
+		dcrlog.Warn(fmt.Sprintf("Audit log not collected: %v", err))
+		fmt.Printf(
+			"WARNING: the audit log of %s:%s is BSON and cannot be redacted, so it was not collected; run without -redact to collect it\n",
+			cred.Currentmongodhost, cred.Currentmongodport,
+		)
+		return
+	}
+	if err != nil {
+		dcrlog.Error(fmt.Sprintf("Error in Audit Log Archive: %v", err))
+	}
+}
+
+// runRAMLogFallback captures the in-memory RAM log with getLog when the mongod log file of the
+// current node could not be archived, so the bundle always contains some log data.
+func runRAMLogFallback(
+	reason string,
+	cred *mongocredentials.Mongocredentials,
+	outputdir *dcroutdir.DCROutputDir,
+	settings *collectionSettings,
+	dcrlog *dcrlogger.DCRLogger,
+) {
+	dcrlog.Info(fmt.Sprintf("Falling back to getLog RAM log capture: %s", reason))
+	ramlogarchive := mongologarchiver.RAMLogarchive{}
+	ramlogarchive.Mongo.S = cred
+	ramlogarchive.Reason = reason
+	ramlogarchive.Redactor = settings.redactor
+	ramlogarchive.Outputdir = outputdir
+	ramlogarchive.Dcrlog = dcrlog
+	err := ramlogarchive.Start()
+	if err != nil {
+		dcrlog.Error(fmt.Sprintf("Error in RAM log fallback: %v", err))
+		return
+	}
+	fmt.Printf(
+		"\nWARNING: mongod log file not collected for %s:%s; saved partial RAM log (getLog) to %s\n",
+		cred.Currentmongodhost, cred.Currentmongodport, mongologarchiver.RAMLogArchiveFileName,
+	)
+}
+
+// hasFreeSpace reports whether the file system of the working directory has neededGB
+// available.
+func hasFreeSpace(neededGB float64) (bool, error) {
 	processwd, err := os.Getwd()
 	if err != nil {
 		return false, err
@@ -640,7 +1673,7 @@ func hasFreeSpace() (bool, error) {
 
 	freeSpaceOnFSInGB := float64(fsstat.Bavail*uint64(fsstat.Bsize)) / (1024 * 1024 * 1024)
 
-	if freeSpaceOnFSInGB < 1.1 {
+	if freeSpaceOnFSInGB < neededGB {
 		return false, nil
 	}
 
@@ -0,0 +1,252 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongologarchiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"dcrcli/budget"
+	"dcrcli/dcrlogger"
+	"dcrcli/dcroutdir"
+	"dcrcli/fscopy"
+	"dcrcli/mongosh"
+	"dcrcli/redactor"
+	"dcrcli/timewindow"
+)
+
+const (
+	AuditArchiveFileName  = "auditarchive.tar.gz"
+	AuditManifestFileName = "auditarchive_manifest.json"
+)
+
+// ErrNoAuditLog is returned by AuditLogarchive when auditing is not enabled on the node,
+// or the audit log is not written to a file.
+var ErrNoAuditLog = errors.New("no audit log file on this node")
+
+// ErrAuditLogNotRedactable is returned by AuditLogarchive when redaction is on and the
+// audit log is BSON, which cannot be redacted; the audit log is not archived.
+var ErrAuditLogNotRedactable = errors.New("BSON audit logs cannot be redacted")
+
+// auditLogOptions is the auditLog section of getCmdLineOpts.
+type auditLogOptions struct {
+	Destination string `json:"destination"`
+	Format      string `json:"format"`
+	Path        string `json:"path"`
+}
+
+// AuditLogarchive archives the audit log of an Enterprise node and its rotated files into
+// auditarchive.tar.gz. The files are copied through CopyJob into a temp dir first; with
+// a nil CopyJob they are read in place.
+type AuditLogarchive struct {
+	Mongo              mongosh.CaptureGetMongoData
+	AuditLogPath       string // full path to the current audit log file
+	AuditLogDir        string
+	CurrentLogFileName string
+	Format             string                       // JSON or BSON
+	Window             timewindow.Window            // zero archives every audit log file
+	Rotation           LogRotation                  // only Dirs is used; files must start with the audit log name
+	SizeBudget         *budget.Set                  // nil archives every selected file
+	Redactor           *redactor.Redactor           // nil archives the audit logs unredacted
+	CopyJob            *fscopy.FSCopyJobWithPattern // nil reads the files in place
+	TempOutputdir      *dcroutdir.DCROutputDir      // temp dir for the copies
+	ArchiveFile        *os.File
+	Outputdir          *dcroutdir.DCROutputDir
+	Dcrlog             *dcrlogger.DCRLogger
+}
+
+func (aa *AuditLogarchive) getDiagnosticDataDirPath() string {
+	err := aa.Mongo.RunGetCommandDiagnosticDataCollectionDirectoryPath()
+	if err != nil {
+		aa.Dcrlog.Debug(fmt.Sprintf("error in getDiagnosticDataDirPath: %v", err))
+		return ""
+	}
+	return trimQuote(aa.Mongo.Getparsedjsonoutput.String())
+}
+
+func (aa *AuditLogarchive) getAuditLogPath() error {
+	err := aa.Mongo.RunGetAuditLogOptions()
+	if err != nil {
+		return err
+	}
+	raw, err := mongosh.UnwrapJSONString(aa.Mongo.Getparsedjsonoutput.Bytes())
+	if err != nil {
+		return err
+	}
+	var opts auditLogOptions
+	err = json.Unmarshal(raw, &opts)
+	if err != nil {
+		return fmt.Errorf("error parsing auditLog options: %w", err)
+	}
+
+	aa.Dcrlog.Debug(fmt.Sprintf("audit log destination: %q format: %q", opts.Destination, opts.Format))
+	if opts.Destination != "file" || opts.Path == "" {
+		if opts.Destination == "" {
+			return fmt.Errorf("auditing is not enabled: %w", ErrNoAuditLog)
+		}
+		return fmt.Errorf("audit log destination is %s: %w", opts.Destination, ErrNoAuditLog)
+	}
+
+	lp := LogPathEstimator{}
+	lp.Dcrlog = aa.Dcrlog
+	lp.CurrentLogPath = opts.Path
+	lp.DiagDirPath = aa.getDiagnosticDataDirPath()
+	lp.ProcessLogPath()
+
+	aa.AuditLogPath = lp.PreparedLogPath
+	aa.AuditLogDir = filepath.Dir(aa.AuditLogPath)
+	aa.CurrentLogFileName = filepath.Base(aa.AuditLogPath)
+	aa.Format = strings.ToUpper(opts.Format)
+	aa.Dcrlog.Debug(fmt.Sprintf("audit log file: %s", aa.AuditLogPath))
+	return nil
+}
+
+// lineTime returns how to read timestamps from the audit log, or nil for BSON audit logs,
+// which cannot be trimmed to the time window line by line.
+func (aa *AuditLogarchive) lineTime() lineTimeFunc {
+	if aa.Format == "BSON" {
+		return nil
+	}
+	return parseAuditLineTime
+}
+
+func (aa *AuditLogarchive) archiveAuditFiles() error {
+	if aa.Redactor != nil && aa.Format == "BSON" {
+		return fmt.Errorf("not archiving %s: %w", aa.AuditLogPath, ErrAuditLogNotRedactable)
+	}
+
+	rotation := DefaultLogRotation()
+	rotation.Dirs = aa.Rotation.Dirs
+	dirs := rotation.searchDirs(aa.AuditLogDir)
+	patterns := rotation.filePatterns(aa.CurrentLogFileName)
+	manifest := newLogManifest(aa.AuditLogDir, aa.CurrentLogFileName, patterns, dirs, aa.Window)
+
+	var err error
+	if aa.CopyJob != nil {
+		dirs, err = copyLogDirs(
+			aa.CopyJob,
+			filepath.Join(aa.TempOutputdir.Path(), "audit"),
+			dirs,
+			patterns,
+			aa.CurrentLogFileName,
+			manifest,
+			aa.Dcrlog,
+		)
+		if err != nil {
+			return fmt.Errorf("error copying audit logs: %w", err)
+		}
+	}
+
+	files, err := discoverLogFiles(dirs, patterns, aa.CurrentLogFileName, manifest)
+	if err != nil {
+		return fmt.Errorf("error in archiveAuditFiles: %w", err)
+	}
+
+	err = archiveLogFileSet(files, aa.Window, aa.lineTime(), aa.Redactor, aa.SizeBudget, aa.ArchiveFile, manifest, aa.Dcrlog)
+	if merr := manifest.write(aa.Outputdir.Path(), AuditManifestFileName); merr != nil {
+		aa.Dcrlog.Warn(fmt.Sprintf("error writing audit archive manifest: %s", merr))
+	}
+	if err != nil {
+		return fmt.Errorf("error in archiveAuditFiles: %w", err)
+	}
+	return nil
+}
+
+func (aa *AuditLogarchive) Start() error {
+	err := aa.getAuditLogPath()
+	if err != nil {
+		return err
+	}
+
+	aa.ArchiveFile, err = os.Create(filepath.Join(aa.Outputdir.Path(), AuditArchiveFileName))
+	if err != nil {
+		return fmt.Errorf("error creating audit archive file in outputs folder %w", err)
+	}
+	defer aa.ArchiveFile.Close()
+
+	err = aa.archiveAuditFiles()
+	if err != nil {
+		aa.ArchiveFile.Close()
+		os.Remove(aa.ArchiveFile.Name())
+		return err
+	}
+	return nil
+}
+
+// parseAuditLineTime reads the "ts" field of a JSON audit log line. Depending on the
+// server version it is {"$date":"<iso8601>"} or {"$date":{"$numberLong":"<millis>"}},
+// with or without spaces around the separators.
+func parseAuditLineTime(line []byte) (time.Time, bool) {
+	i := bytes.Index(line, []byte(`"ts"`))
+	if i < 0 {
+		return time.Time{}, false
+	}
+	rest, ok := skipJSONKey(line[i+len(`"ts"`):], "")
+	if !ok {
+		return time.Time{}, false
+	}
+	rest, ok = skipJSONKey(rest, "$date")
+	if !ok {
+		return time.Time{}, false
+	}
+
+	if rest[0] == '"' {
+		end := bytes.IndexByte(rest[1:], '"')
+		if end < 0 {
+			return time.Time{}, false
+		}
+		t, err := time.Parse(time.RFC3339Nano, string(rest[1:1+end]))
+		return t, err == nil
+	}
+
+	rest, ok = skipJSONKey(rest, "$numberLong")
+	if !ok || rest[0] != '"' {
+		return time.Time{}, false
+	}
+	end := bytes.IndexByte(rest[1:], '"')
+	if end < 0 {
+		return time.Time{}, false
+	}
+	millis, err := strconv.ParseInt(string(rest[1:1+end]), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMilli(millis).UTC(), true
+}
+
+// skipJSONKey skips the separators after a key and, when key is set, the opening brace
+// and that key of the nested object. It returns the rest of b starting at the value.
+func skipJSONKey(b []byte, key string) ([]byte, bool) {
+	b = bytes.TrimLeft(b, " :")
+	if key != "" {
+		if len(b) == 0 || b[0] != '{' {
+			return nil, false
+		}
+		b = bytes.TrimLeft(b[1:], " ")
+		quoted := `"` + key + `"`
+		if !bytes.HasPrefix(b, []byte(quoted)) {
+			return nil, false
+		}
+		b = bytes.TrimLeft(b[len(quoted):], " :")
+	}
+	return b, len(b) > 0
+}
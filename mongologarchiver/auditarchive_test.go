@@ -0,0 +1,178 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongologarchiver
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dcrcli/dcrlogger"
+	"dcrcli/dcroutdir"
+	"dcrcli/redactor"
+	"dcrcli/timewindow"
+)
+
+func TestParseAuditLineTime(t *testing.T) {
+	want := time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC)
+	lines := []string{
+		`{ "atype" : "authenticate", "ts" : { "$date" : "2024-03-09T10:00:00.000+00:00" } }`,
+		`{"atype":"authenticate","ts":{"$date":"2024-03-09T10:00:00.000+00:00"},"local":{}}`,
+		`{"atype":"authCheck","ts":{"$date":{"$numberLong":"1709978400000"}},"local":{}}`,
+	}
+	for _, line := range lines {
+		got, ok := parseAuditLineTime([]byte(line))
+		if !ok || !got.Equal(want) {
+			t.Fatalf("%s: got %v %v", line, got, ok)
+		}
+	}
+	if _, ok := parseAuditLineTime([]byte(`{"atype":"authCheck"}`)); ok {
+		t.Fatal("line without ts parsed")
+	}
+}
+
+func TestAuditLogarchiveTrimsToWindow(t *testing.T) {
+	auditDir := t.TempDir()
+	content := `{"atype":"authenticate","ts":{"$date":"2024-03-08T10:00:00.000+00:00"},"param":{"user":"old"}}` + "\n" +
+		`{"atype":"authenticate","ts":{"$date":"2024-03-09T10:00:00.000+00:00"},"param":{"user":"new"}}` + "\n"
+	if err := os.WriteFile(filepath.Join(auditDir, "auditLog.json"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(auditDir, "mongod.log"), []byte("not audit\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outputdir := dcroutdir.DCROutputDir{OutputPrefix: t.TempDir() + "/", Hostname: "db1", Port: "27017"}
+	if err := outputdir.CreateDCROutputDir(); err != nil {
+		t.Fatal(err)
+	}
+	dcrlog := dcrlogger.DCRLogger{OutputPrefix: t.TempDir() + "/", FileName: "auditarchive_test"}
+	if err := dcrlog.Create(); err != nil {
+		t.Fatal(err)
+	}
+	archive, err := os.Create(filepath.Join(outputdir.Path(), AuditArchiveFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	aa := AuditLogarchive{
+		AuditLogDir:        auditDir,
+		CurrentLogFileName: "auditLog.json",
+		Format:             "JSON",
+		Window:             timewindow.Window{Since: time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)},
+		ArchiveFile:        archive,
+		Outputdir:          &outputdir,
+		Dcrlog:             &dcrlog,
+	}
+	if err := aa.archiveAuditFiles(); err != nil {
+		t.Fatal(err)
+	}
+	archive.Close()
+
+	f, err := os.Open(archive.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	header, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(tr)
+	if header.Name != "auditLog.json" || string(data) != `{"atype":"authenticate","ts":{"$date":"2024-03-09T10:00:00.000+00:00"},"param":{"user":"new"}}`+"\n" {
+		t.Fatalf("unexpected entry %s: %s", header.Name, data)
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Fatal("mongod log was archived with the audit log")
+	}
+	if _, err := os.Stat(filepath.Join(outputdir.Path(), AuditManifestFileName)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuditLogarchiveRedacts(t *testing.T) {
+	auditDir := t.TempDir()
+	content := `{"atype":"authenticate","ts":{"$date":"2024-03-09T10:00:00.000+00:00"},` +
+		`"remote":{"ip":"10.1.2.3","port":51234},"users":[{"user":"alice","db":"admin"}],"param":{"user":"alice"}}` + "\n"
+	if err := os.WriteFile(filepath.Join(auditDir, "auditLog.json"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outputdir := dcroutdir.DCROutputDir{OutputPrefix: t.TempDir() + "/", Hostname: "db1", Port: "27017"}
+	if err := outputdir.CreateDCROutputDir(); err != nil {
+		t.Fatal(err)
+	}
+	dcrlog := dcrlogger.DCRLogger{OutputPrefix: t.TempDir() + "/", FileName: "auditarchive_test"}
+	if err := dcrlog.Create(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := redactor.New(redactor.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := os.Create(filepath.Join(outputdir.Path(), AuditArchiveFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aa := AuditLogarchive{
+		AuditLogPath:       filepath.Join(auditDir, "auditLog.json"),
+		AuditLogDir:        auditDir,
+		CurrentLogFileName: "auditLog.json",
+		Format:             "JSON",
+		Redactor:           r,
+		ArchiveFile:        archive,
+		Outputdir:          &outputdir,
+		Dcrlog:             &dcrlog,
+	}
+	if err := aa.archiveAuditFiles(); err != nil {
+		t.Fatal(err)
+	}
+	archive.Close()
+
+	f, err := os.Open(archive.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gzr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	if _, err := tr.Next(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(tr)
+	if strings.Contains(string(data), "alice") || strings.Contains(string(data), "10.1.2.3") {
+		t.Fatalf("audit log archived unredacted: %s", data)
+	}
+
+	// BSON audit logs cannot be redacted and are refused
+	aa.Format = "BSON"
+	if err := aa.archiveAuditFiles(); !errors.Is(err, ErrAuditLogNotRedactable) {
+		t.Fatalf("a BSON audit log with redaction on should be refused, got %v", err)
+	}
+}
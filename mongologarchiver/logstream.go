@@ -0,0 +1,129 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongologarchiver
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"dcrcli/archiver"
+	"dcrcli/budget"
+	"dcrcli/dcrlogger"
+	"dcrcli/fscopy"
+	"dcrcli/timewindow"
+)
+
+// streamLogFileSet is archiveLogFileSet for files left on the node: it tars the log
+// files overlapping the window into out as job streams them. Files cannot be trimmed or
+// redacted on the way and are taken whole. outDir must have room for the selected files
+// at their size on the node, which the compressed archive does not exceed.
+func streamLogFileSet(
+	job *fscopy.FSCopyJob,
+	files []logFile,
+	window timewindow.Window,
+	sizes *budget.Set,
+	outDir string,
+	out io.Writer,
+	manifest *logManifest,
+	dcrlog *dcrlogger.DCRLogger,
+) error {
+	selected, err := selectLogFiles(files, window, manifest, dcrlog)
+	if err != nil {
+		return err
+	}
+
+	// charge the newest file first so the budget keeps the most recent logs
+	keep := make([]bool, len(selected))
+	for i := len(selected) - 1; i >= 0; i-- {
+		f := selected[i]
+		if sizes.Full() {
+			sizes.Drop(budget.File{Name: f.Source, Size: f.Size, Time: f.End})
+			manifest.skip(f, sizes.Reason())
+			continue
+		}
+		if !sizes.Take(f.Size) {
+			sizes.Drop(budget.File{Name: f.Source, Size: f.Size, Time: f.End})
+			manifest.skip(f, sizes.Reason())
+			dcrlog.Warn(fmt.Sprintf("leaving out %s and older log files: %s", f.Source, sizes.Reason()))
+			continue
+		}
+		keep[i] = true
+	}
+
+	kept := make([]logFile, 0, len(selected))
+	var need int64
+	for i, f := range selected {
+		if keep[i] {
+			kept = append(kept, f)
+			need += f.Size
+		}
+	}
+	if len(kept) == 0 && len(selected) > 0 && sizes.Full() {
+		return fmt.Errorf("no log files fit: %s", sizes.Reason())
+	}
+	err = fscopy.CheckFreeSpace(outDir, need)
+	if err != nil {
+		return err
+	}
+
+	ts := archiver.NewTarStream(out)
+	received := make([]bool, len(kept))
+	// one stream per run of files from the same directory keeps the archive order
+	for start, end := 0, 0; start < len(kept); start = end {
+		dir := filepath.Dir(kept[start].Source)
+		byName := make(map[string]int)
+		names := make([]string, 0)
+		for end = start; end < len(kept) && filepath.Dir(kept[end].Source) == dir; end++ {
+			name := filepath.Base(kept[end].Source)
+			byName[name] = end
+			names = append(names, name)
+		}
+
+		err = job.StreamFiles(dir, names, func(hdr *tar.Header, r io.Reader) error {
+			i, ok := byName[hdr.Name]
+			if !ok {
+				return fmt.Errorf("unexpected file %s in the stream", hdr.Name)
+			}
+			hdr.Name = kept[i].Name
+			kept[i].Size = hdr.Size
+			received[i] = true
+			return ts.Add(hdr, r, kept[i].Compressed)
+		})
+		if err != nil {
+			ts.Close()
+			return err
+		}
+	}
+	err = ts.Close()
+	if err != nil {
+		return err
+	}
+
+	for i, f := range kept {
+		if !received[i] {
+			manifest.skip(f, "could not be read on the node")
+			dcrlog.Warn(fmt.Sprintf("skipping %s: it could not be read on the node", f.Source))
+			continue
+		}
+		note := "streamed from the node"
+		if !window.IsZero() && !window.Covers(f.Start, f.End) {
+			note = "streamed from the node, included whole"
+		}
+		manifest.include(f, note)
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongologarchiver
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"dcrcli/archiver"
+	"dcrcli/budget"
+	"dcrcli/dcrlogger"
+	"dcrcli/redactor"
+	"dcrcli/timewindow"
+)
+
+// logFile is a candidate log file and the time range its lines cover.
+// A file covers (Start, End]; Start is zero for the oldest file.
+type logFile struct {
+	Name       string // name inside the archive
+	Path       string // where the file can be read
+	Source     string // path of the file on the node
+	Size       int64
+	Start      time.Time
+	End        time.Time
+	Current    bool
+	Compressed bool
+}
+
+// rotatedLogTimeLayout is the suffix mongod appends on logRotate: rename, e.g. mongod.log.2024-03-09T10-00-00
+const rotatedLogTimeLayout = "2006-01-02T15-04-05"
+
+// rotatedLogTime reads the rotation time from a rotated log file name. The rotation time
+// is when mongod stopped writing to that file.
+func rotatedLogTime(currentLogFileName string, name string) (time.Time, bool) {
+	suffix, ok := strings.CutPrefix(name, currentLogFileName+".")
+	if !ok || len(suffix) < len(rotatedLogTimeLayout) {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(rotatedLogTimeLayout, suffix[:len(rotatedLogTimeLayout)])
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// parseLogLineTime reads the timestamp of a mongod log line. It understands the structured
+// JSON format of 4.4+ ({"t":{"$date":"..."}) and the iso8601 text format of earlier versions.
+func parseLogLineTime(line []byte) (time.Time, bool) {
+	line = bytes.TrimLeft(line, " \t")
+	if len(line) > 0 && line[0] == '{' {
+		marker := []byte(`"$date":"`)
+		i := bytes.Index(line, marker)
+		if i < 0 || i > 32 {
+			return time.Time{}, false
+		}
+		rest := line[i+len(marker):]
+		j := bytes.IndexByte(rest, '"')
+		if j < 0 {
+			return time.Time{}, false
+		}
+		t, err := time.Parse(time.RFC3339Nano, string(rest[:j]))
+		return t, err == nil
+	}
+
+	// legacy text format: 2019-03-09T10:00:00.123+0000 I NETWORK ...
+	end := bytes.IndexByte(line, ' ')
+	if end < 0 {
+		return time.Time{}, false
+	}
+	for _, layout := range []string{"2006-01-02T15:04:05.000-0700", "2006-01-02T15:04:05.000Z07:00"} {
+		if t, err := time.Parse(layout, string(line[:end])); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// lineTimeFunc reads the timestamp of a log line.
+type lineTimeFunc func(line []byte) (time.Time, bool)
+
+// trimLogToWindow copies the lines of r that fall inside the window to w. Lines without a
+// timestamp, such as the continuation of a multi-line legacy message, follow the line before.
+func trimLogToWindow(r io.Reader, w io.Writer, window timewindow.Window, lineTime lineTimeFunc) (int, error) {
+	reader := bufio.NewReaderSize(r, 1024*1024)
+	writer := bufio.NewWriter(w)
+	kept := 0
+	keep := false
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			if t, ok := lineTime(line); ok {
+				keep = window.Contains(t)
+			}
+			if keep {
+				kept++
+				if _, werr := writer.Write(line); werr != nil {
+					return kept, werr
+				}
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return kept, err
+		}
+	}
+	return kept, writer.Flush()
+}
+
+// trimLogFileToTemp writes the lines of path inside the window to a new temporary file.
+func trimLogFileToTemp(path string, window timewindow.Window, lineTime lineTimeFunc) (string, error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp("", "dcrcli-log-*")
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	if _, err := trimLogToWindow(src, dst, window, lineTime); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
+
+// selectLogFiles returns the files overlapping the window and records the others in
+// manifest.
+func selectLogFiles(
+	files []logFile,
+	window timewindow.Window,
+	manifest *logManifest,
+	dcrlog *dcrlogger.DCRLogger,
+) ([]logFile, error) {
+	if window.IsZero() {
+		return files, nil
+	}
+	selected := make([]logFile, 0, len(files))
+	for _, f := range files {
+		if window.Overlaps(f.Start, f.End) {
+			selected = append(selected, f)
+		} else {
+			manifest.skip(f, "outside time window "+window.String())
+		}
+	}
+	dcrlog.Info(
+		fmt.Sprintf("time window %s selects %d of %d log file(s)", window, len(selected), len(files)),
+	)
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no log files overlap the time window %s", window)
+	}
+	return selected, nil
+}
+
+// archiveLogFileSet tars the log files overlapping the window into out and records the
+// choice in manifest. Files only partly inside the window are trimmed to the lines inside
+// it using lineTime; with a nil lineTime they are taken whole. Compressed files are taken
+// whole and stored without compressing them again. When redact is set every file is
+// redacted before it is archived. Files are charged to sizes newest first; older files
+// that no longer fit are left out.
+func archiveLogFileSet(
+	files []logFile,
+	window timewindow.Window,
+	lineTime lineTimeFunc,
+	redact *redactor.Redactor,
+	sizes *budget.Set,
+	out io.Writer,
+	manifest *logManifest,
+	dcrlog *dcrlogger.DCRLogger,
+) error {
+	selected, err := selectLogFiles(files, window, manifest, dcrlog)
+	if err != nil {
+		return err
+	}
+
+	// stage the newest file first so the budget keeps the most recent logs
+	staged := make([]*archiver.FileEntry, len(selected))
+	notes := make([]string, len(selected))
+	for i := len(selected) - 1; i >= 0; i-- {
+		f := selected[i]
+		if sizes.Full() {
+			sizes.Drop(budget.File{Name: f.Source, Size: f.Size, Time: f.End})
+			manifest.skip(f, sizes.Reason())
+			continue
+		}
+
+		path := f.Path
+		note := ""
+		if !window.IsZero() && !window.Covers(f.Start, f.End) {
+			if f.Compressed {
+				note = "compressed, included whole"
+			} else if lineTime == nil {
+				note = "cannot be trimmed, included whole"
+			} else {
+				trimmed, err := trimLogFileToTemp(path, window, lineTime)
+				if err != nil {
+					return fmt.Errorf("error trimming %s to time window: %w", f.Source, err)
+				}
+				defer os.Remove(trimmed)
+				path = trimmed
+				note = "trimmed to time window"
+				dcrlog.Debug(fmt.Sprintf("trimmed %s to time window %s", f.Name, window))
+			}
+		}
+		if redact != nil {
+			if f.Compressed && !strings.HasSuffix(f.Name, ".gz") {
+				manifest.skip(f, "cannot redact this compression format")
+				dcrlog.Warn(fmt.Sprintf("skipping %s: only .gz rotated logs can be redacted", f.Source))
+				continue
+			}
+			redacted, err := redact.RedactLogFile(path)
+			if err != nil {
+				return fmt.Errorf("error redacting %s: %w", f.Source, err)
+			}
+			defer os.Remove(redacted)
+			path = redacted
+			dcrlog.Debug(fmt.Sprintf("redacted %s", f.Name))
+		}
+
+		size := f.Size
+		if fi, err := os.Stat(path); err == nil {
+			size = fi.Size()
+		}
+		if !sizes.Take(size) {
+			sizes.Drop(budget.File{Name: f.Source, Size: size, Time: f.End})
+			manifest.skip(f, sizes.Reason())
+			dcrlog.Warn(fmt.Sprintf("leaving out %s and older log files: %s", f.Source, sizes.Reason()))
+			continue
+		}
+		staged[i] = &archiver.FileEntry{Name: f.Name, Path: path, Stored: f.Compressed}
+		notes[i] = note
+	}
+
+	entries := make([]archiver.FileEntry, 0, len(selected))
+	for i, entry := range staged {
+		if entry != nil {
+			manifest.include(selected[i], notes[i])
+			entries = append(entries, *entry)
+		}
+	}
+	if len(entries) == 0 && len(selected) > 0 && sizes.Full() {
+		return fmt.Errorf("no log files fit: %s", sizes.Reason())
+	}
+
+	return archiver.TarFiles(entries, out)
+}
@@ -0,0 +1,96 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongologarchiver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dcrcli/timewindow"
+)
+
+func TestParseLogLineTimeFormats(t *testing.T) {
+	want := time.Date(2024, 3, 9, 10, 0, 0, 123000000, time.UTC)
+
+	got, ok := parseLogLineTime([]byte(`{"t":{"$date":"2024-03-09T10:00:00.123+00:00"},"s":"I","c":"NETWORK","msg":"x"}`))
+	if !ok || !got.Equal(want) {
+		t.Fatalf("JSON log time parsed as %v, %v", got, ok)
+	}
+
+	got, ok = parseLogLineTime([]byte("2024-03-09T10:00:00.123+0000 I NETWORK  [conn1] end connection"))
+	if !ok || !got.Equal(want) {
+		t.Fatalf("legacy log time parsed as %v, %v", got, ok)
+	}
+
+	if _, ok := parseLogLineTime([]byte("  at mongo::foo() continuation")); ok {
+		t.Fatal("continuation line should not parse")
+	}
+}
+
+func TestTrimLogToWindow(t *testing.T) {
+	window := timewindow.Window{
+		Since: time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC),
+		Until: time.Date(2024, 3, 9, 11, 0, 0, 0, time.UTC),
+	}
+	input := `{"t":{"$date":"2024-03-09T09:59:59.000+00:00"},"msg":"before"}` + "\n" +
+		"2024-03-09T10:30:00.000+0000 I COMMAND  [conn1] inside\n" +
+		" continuation of inside\n" +
+		`{"t":{"$date":"2024-03-09T11:00:01.000+00:00"},"msg":"after"}` + "\n"
+
+	var out bytes.Buffer
+	kept, err := trimLogToWindow(strings.NewReader(input), &out, window, parseLogLineTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2024-03-09T10:30:00.000+0000 I COMMAND  [conn1] inside\n continuation of inside\n"
+	if kept != 2 || out.String() != want {
+		t.Fatalf("kept %d lines: %q", kept, out.String())
+	}
+}
+
+func TestDiscoverLogFilesOrder(t *testing.T) {
+	dir := t.TempDir()
+	names := []string{
+		"mongod.log",
+		"mongod.log.2024-03-07T00-00-00",
+		"mongod.log.2024-03-08T00-00-00",
+		"mongod.log.2024-03-09T00-00-00",
+		"other.log",
+	}
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rotation := DefaultLogRotation()
+	patterns := rotation.filePatterns("mongod.log")
+	dirs := rotation.searchDirs(dir)
+	manifest := newLogManifest(dir, "mongod.log", patterns, dirs, timewindow.Window{})
+	files, err := discoverLogFiles(dirs, patterns, "mongod.log", manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 4 || files[3].Name != "mongod.log" || files[0].Name != "mongod.log.2024-03-07T00-00-00" {
+		t.Fatalf("unexpected file order: %+v", files)
+	}
+	if !files[1].Start.Equal(files[0].End) || !files[0].Start.IsZero() {
+		t.Fatalf("unexpected time ranges: %+v", files)
+	}
+}
@@ -0,0 +1,122 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongologarchiver
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dcrcli/timewindow"
+)
+
+// LogManifestFileName is written next to logarchive.tar.gz and lists which log files
+// were archived and which were left out and why.
+const LogManifestFileName = "logarchive_manifest.json"
+
+type logManifest struct {
+	LogDir         string               `json:"logDir"`
+	CurrentLogFile string               `json:"currentLogFile"`
+	Patterns       []string             `json:"patterns"`
+	SearchedDirs   []string             `json:"searchedDirs"`
+	Window         string               `json:"window,omitempty"`
+	Included       []logManifestEntry   `json:"included"`
+	Skipped        []logManifestEntry   `json:"skipped"`
+	SkippedDirs    []logManifestSkipDir `json:"skippedDirs,omitempty"`
+}
+
+type logManifestEntry struct {
+	Name       string `json:"name,omitempty"`
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	Start      string `json:"start,omitempty"`
+	End        string `json:"end,omitempty"`
+	Compressed bool   `json:"compressed,omitempty"`
+	Note       string `json:"note,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+type logManifestSkipDir struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+func newLogManifest(
+	logDir string,
+	currentLogFileName string,
+	patterns []string,
+	dirs []logSearchDir,
+	window timewindow.Window,
+) *logManifest {
+	m := &logManifest{
+		LogDir:         logDir,
+		CurrentLogFile: currentLogFileName,
+		Patterns:       patterns,
+		Included:       []logManifestEntry{},
+		Skipped:        []logManifestEntry{},
+	}
+	for _, d := range dirs {
+		m.SearchedDirs = append(m.SearchedDirs, d.Source)
+	}
+	if !window.IsZero() {
+		m.Window = window.String()
+	}
+	return m
+}
+
+func manifestTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func manifestEntry(f logFile) logManifestEntry {
+	return logManifestEntry{
+		Name:       f.Name,
+		Path:       f.Source,
+		Size:       f.Size,
+		Start:      manifestTime(f.Start),
+		End:        manifestTime(f.End),
+		Compressed: f.Compressed,
+	}
+}
+
+func (m *logManifest) include(f logFile, note string) {
+	e := manifestEntry(f)
+	e.Note = note
+	m.Included = append(m.Included, e)
+}
+
+func (m *logManifest) skip(f logFile, reason string) {
+	e := manifestEntry(f)
+	e.Name = ""
+	e.Reason = reason
+	m.Skipped = append(m.Skipped, e)
+}
+
+func (m *logManifest) skipDir(path string, err error) {
+	m.SkippedDirs = append(m.SkippedDirs, logManifestSkipDir{Path: path, Reason: err.Error()})
+}
+
+// write stores the manifest in dir as name.
+func (m *logManifest) write(dir string, name string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, name), append(data, '\n'), 0644)
+}
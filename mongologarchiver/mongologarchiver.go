@@ -21,10 +21,13 @@ import (
 	"path/filepath"
 	"strings"
 
-	"dcrcli/archiver"
+	"dcrcli/budget"
 	"dcrcli/dcrlogger"
 	"dcrcli/dcroutdir"
+	"dcrcli/fscopy"
 	"dcrcli/mongosh"
+	"dcrcli/redactor"
+	"dcrcli/timewindow"
 )
 
 type MongoDLogarchive struct {
@@ -34,7 +37,13 @@ type MongoDLogarchive struct {
 	LogDir             string // derived base dir of latest mongod log file
 	CurrentLogFileName string // name of latest mongod log file
 	LogDestination     string
+	Window             timewindow.Window  // zero archives every log file
+	Redactor           *redactor.Redactor // nil archives the logs unredacted
+	Rotation           LogRotation        // zero uses DefaultLogRotation
+	SizeBudget         *budget.Set        // nil archives every selected file
 	Outputdir          *dcroutdir.DCROutputDir
+	TempOutputdir      *dcroutdir.DCROutputDir
+	CopyJob            *fscopy.FSCopyJobWithPattern // local copy of the log files to TempOutputdir
 	Dcrlog             *dcrlogger.DCRLogger
 }
 
@@ -64,8 +73,10 @@ func (la *MongoDLogarchive) getLogPath() error {
 
 	la.Dcrlog.Debug(fmt.Sprintf("mongod log destination: %s", systemLogOutput["destination"]))
 
-	if systemLogOutput["destination"] == "file" {
-		la.LogDestination = "file"
+	// destination is absent when mongod logs to stdout
+	la.LogDestination, _ = systemLogOutput["destination"].(string)
+
+	if la.LogDestination == "file" {
 
 		lp := LogPathEstimator{}
 		lp.Dcrlog = la.Dcrlog
@@ -98,19 +109,43 @@ func (la *MongoDLogarchive) createMongodTarArchiveFile() error {
 }
 
 func (la *MongoDLogarchive) archiveLogFiles() error {
-	var err error
-	// Define search pattern based on latest mongod log file name
-	fileSearchPatterString := `^` + la.CurrentLogFileName + `.*`
+	rotation := la.Rotation.orDefault()
+	dirs := rotation.searchDirs(la.LogDir)
+	patterns := rotation.filePatterns(la.CurrentLogFileName)
+	manifest := newLogManifest(la.LogDir, la.CurrentLogFileName, patterns, dirs, la.Window)
+
+	// copy first like for remote nodes, so the archive is made from files mongod does not
+	// write to and rotate away meanwhile
+	dirs, err := copyLogDirs(
+		la.CopyJob,
+		la.TempOutputdir.Path(),
+		dirs,
+		patterns,
+		la.CurrentLogFileName,
+		manifest,
+		la.Dcrlog,
+	)
+	if err != nil {
+		return fmt.Errorf("error in archiveLogFiles: %w", err)
+	}
 
-	// Debug messages
-	// fmt.Println("la.Logdir", la.LogDir)
-	// fmt.Println("fileSearchPatterString", fileSearchPatterString)
+	files, err := discoverLogFiles(dirs, patterns, la.CurrentLogFileName, manifest)
+	if err != nil {
+		return fmt.Errorf("error in archiveLogFiles: %w", err)
+	}
 
-	err = archiver.TarWithPatternMatch(
-		la.LogDir,
-		fileSearchPatterString,
-		la.LogArchiveFile,
-	)
+	// created only now, so a failed copy leaves no empty archive behind for the syslog or
+	// RAM log fallback
+	err = la.createMongodTarArchiveFile()
+	if err != nil {
+		return err
+	}
+	defer la.LogArchiveFile.Close()
+
+	err = archiveLogFileSet(files, la.Window, parseLogLineTime, la.Redactor, la.SizeBudget, la.LogArchiveFile, manifest, la.Dcrlog)
+	if merr := manifest.write(la.Outputdir.Path(), LogManifestFileName); merr != nil {
+		la.Dcrlog.Warn(fmt.Sprintf("error writing log archive manifest: %s", merr))
+	}
 	if err != nil {
 		la.Dcrlog.Debug(fmt.Sprintf("error in archiveLogFiles: %s", err))
 		return fmt.Errorf("error in archiveLogFiles: %w", err)
@@ -126,15 +161,13 @@ func (la *MongoDLogarchive) Start() error {
 		return err
 	}
 	// return early if the mongod log destination is not file
+	if la.LogDestination == "syslog" {
+		return fmt.Errorf("error: MongoDLogArchive only works for systemLog:file: %w", ErrSyslogDestination)
+	}
 	if la.LogDestination != "file" {
 		return fmt.Errorf("error: MongoDLogArchive only works for systemLog:file")
 	}
 
-	err = la.createMongodTarArchiveFile()
-	if err != nil {
-		return err
-	}
-
 	err = la.archiveLogFiles()
 	if err != nil {
 		return err
@@ -0,0 +1,166 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongologarchiver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"dcrcli/archiver"
+	"dcrcli/dcrlogger"
+	"dcrcli/dcroutdir"
+	"dcrcli/mongosh"
+	"dcrcli/redactor"
+)
+
+// RAMLogArchiveFileName is deliberately different from logarchive.tar.gz so a partial
+// capture is never mistaken for the full mongod log.
+const RAMLogArchiveFileName = "ramlogarchive_partial.tar.gz"
+
+// RAMLogarchive captures the in-memory log buffers with getLog when the mongod log file
+// itself is not reachable (non-file destination, no SSH access, copy failure).
+// The RAM log only holds the most recent lines, so the archive is labelled as partial.
+type RAMLogarchive struct {
+	Mongo          mongosh.CaptureGetMongoData
+	LogArchiveFile *os.File
+	Reason         string             // why the log file could not be collected
+	Redactor       *redactor.Redactor // nil captures the lines unredacted
+	Outputdir      *dcroutdir.DCROutputDir
+	Dcrlog         *dcrlogger.DCRLogger
+}
+
+type getLogResult struct {
+	Lines             []string `json:"lines"`
+	TotalLinesWritten *int64   `json:"totalLinesWritten"`
+	Error             string   `json:"error"`
+}
+
+type getLogOutput struct {
+	Global          getLogResult `json:"global"`
+	StartupWarnings getLogResult `json:"startupWarnings"`
+}
+
+func (rl *RAMLogarchive) getLogs() (getLogOutput, error) {
+	var out getLogOutput
+
+	err := rl.Mongo.RunGetLogWithEval()
+	if err != nil {
+		return out, err
+	}
+
+	raw, err := mongosh.UnwrapJSONString(rl.Mongo.Getparsedjsonoutput.Bytes())
+	if err != nil {
+		return out, err
+	}
+
+	err = json.Unmarshal(raw, &out)
+	if err != nil {
+		return out, fmt.Errorf("error parsing getLog output: %w", err)
+	}
+	return out, nil
+}
+
+// partialNote explains in the archive itself why only the RAM log is present.
+func (rl *RAMLogarchive) partialNote(out getLogOutput) string {
+	var b strings.Builder
+	b.WriteString("PARTIAL LOG DATA\n\n")
+	b.WriteString("The mongod log file could not be collected from this node, so dcrcli captured\n")
+	b.WriteString("the in-memory RAM log with getLog instead. It only holds the most recent lines\n")
+	b.WriteString("(at most 1024 per log) and is not a replacement for the full log file.\n\n")
+	if rl.Reason != "" {
+		fmt.Fprintf(&b, "Reason the log file was not collected: %s\n", rl.Reason)
+	}
+	fmt.Fprintf(&b, "getLog global lines captured: %d", len(out.Global.Lines))
+	if out.Global.TotalLinesWritten != nil {
+		fmt.Fprintf(&b, " (of %d written since startup)", *out.Global.TotalLinesWritten)
+	}
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "getLog startupWarnings lines captured: %d\n", len(out.StartupWarnings.Lines))
+	if out.Global.Error != "" {
+		fmt.Fprintf(&b, "getLog global error: %s\n", out.Global.Error)
+	}
+	if out.StartupWarnings.Error != "" {
+		fmt.Fprintf(&b, "getLog startupWarnings error: %s\n", out.StartupWarnings.Error)
+	}
+	return b.String()
+}
+
+func joinLogLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return []byte{}
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// redactLogLines returns data unchanged when no redactor is set.
+func (rl *RAMLogarchive) redactLogLines(data []byte) ([]byte, error) {
+	if rl.Redactor == nil {
+		return data, nil
+	}
+	var out bytes.Buffer
+	_, err := rl.Redactor.RedactLines(bytes.NewReader(data), &out)
+	return out.Bytes(), err
+}
+
+func (rl *RAMLogarchive) createRAMLogTarArchiveFile() error {
+	var err error
+	rl.LogArchiveFile, err = os.Create(rl.Outputdir.Path() + "/" + RAMLogArchiveFileName)
+	if err != nil {
+		return fmt.Errorf("error creating RAM log archive file in outputs folder %w", err)
+	}
+	return nil
+}
+
+func (rl *RAMLogarchive) Start() error {
+	out, err := rl.getLogs()
+	if err != nil {
+		rl.Dcrlog.Debug(fmt.Sprintf("error in RAMLogarchive getLogs: %s", err))
+		return fmt.Errorf("error in RAMLogarchive: %w", err)
+	}
+
+	global, err := rl.redactLogLines(joinLogLines(out.Global.Lines))
+	if err != nil {
+		return fmt.Errorf("error in RAMLogarchive redacting getLog output: %w", err)
+	}
+	startupWarnings, err := rl.redactLogLines(joinLogLines(out.StartupWarnings.Lines))
+	if err != nil {
+		return fmt.Errorf("error in RAMLogarchive redacting getLog output: %w", err)
+	}
+
+	err = rl.createRAMLogTarArchiveFile()
+	if err != nil {
+		return err
+	}
+	defer rl.LogArchiveFile.Close()
+
+	err = archiver.TarBuffers([]archiver.BufferEntry{
+		{Name: "PARTIAL_RAMLOG_README.txt", Data: []byte(rl.partialNote(out))},
+		{Name: "getLog_global.partial.log", Data: global},
+		{Name: "getLog_startupWarnings.partial.log", Data: startupWarnings},
+	}, rl.LogArchiveFile)
+	if err != nil {
+		return fmt.Errorf("error in RAMLogarchive: %w", err)
+	}
+
+	rl.Dcrlog.Info(
+		fmt.Sprintf(
+			"captured %d getLog global line(s) into %s", len(out.Global.Lines), RAMLogArchiveFileName,
+		),
+	)
+	return nil
+}
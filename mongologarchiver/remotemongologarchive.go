@@ -20,11 +20,13 @@ import (
 	"os"
 	"path/filepath"
 
-	"dcrcli/archiver"
+	"dcrcli/budget"
 	"dcrcli/dcrlogger"
 	"dcrcli/dcroutdir"
 	"dcrcli/fscopy"
 	"dcrcli/mongosh"
+	"dcrcli/redactor"
+	"dcrcli/timewindow"
 )
 
 type RemoteMongoDLogarchive struct {
@@ -34,6 +36,10 @@ type RemoteMongoDLogarchive struct {
 	LogDir             string // derived base dir of latest mongod log file
 	CurrentLogFileName string // name of latest mongod log file
 	LogDestination     string
+	Window             timewindow.Window  // zero archives every log file
+	Redactor           *redactor.Redactor // nil archives the logs unredacted
+	Rotation           LogRotation        // zero uses DefaultLogRotation
+	SizeBudget         *budget.Set        // nil archives every selected file
 	Outputdir          *dcroutdir.DCROutputDir
 	TempOutputdir      *dcroutdir.DCROutputDir
 	RemoteCopyJob      *fscopy.FSCopyJobWithPattern
@@ -67,8 +73,10 @@ func (rla *RemoteMongoDLogarchive) getLogPathAndSetCurrentLogFileName() error {
 
 	rla.Dcrlog.Debug(fmt.Sprintf("mongod log destination: %s", systemLogOutput["destination"]))
 
-	if systemLogOutput["destination"].(string) == "file" {
-		rla.LogDestination = "file"
+	// destination is absent when mongod logs to stdout
+	rla.LogDestination, _ = systemLogOutput["destination"].(string)
+
+	if rla.LogDestination == "file" {
 
 		lp := LogPathEstimator{}
 		lp.Dcrlog = rla.Dcrlog
@@ -91,6 +99,24 @@ func (rla *RemoteMongoDLogarchive) getLogPathAndSetCurrentLogFileName() error {
 	return nil
 }
 
+// LogDirs returns the directories the log files of the node are read from: the directory
+// of the current log followed by the rotated log directories. It returns none when mongod
+// does not log to a file.
+func (rla *RemoteMongoDLogarchive) LogDirs() ([]string, error) {
+	err := rla.getLogPathAndSetCurrentLogFileName()
+	if err != nil {
+		return nil, err
+	}
+	if rla.LogDestination != "file" {
+		return nil, nil
+	}
+	var dirs []string
+	for _, d := range rla.Rotation.orDefault().searchDirs(rla.LogDir) {
+		dirs = append(dirs, d.Source)
+	}
+	return dirs, nil
+}
+
 func (rla *RemoteMongoDLogarchive) createMongodTarArchiveFile() error {
 	var err error
 	rla.LogArchiveFile, err = os.Create(rla.Outputdir.Path() + "/logarchive.tar.gz")
@@ -101,35 +127,75 @@ func (rla *RemoteMongoDLogarchive) createMongodTarArchiveFile() error {
 	return nil
 }
 
+// StreamsLogs reports whether remote log files copied with transfer are archived straight
+// from a tar stream of the node rather than from copies in the temp dir. Redacting needs
+// the copies in the temp dir.
+func StreamsLogs(transfer fscopy.Transfer, r *redactor.Redactor) bool {
+	return transfer.Stream && r == nil
+}
+
+// streaming reports whether the log files are archived straight from a tar stream of the
+// node.
+func (rla *RemoteMongoDLogarchive) streaming() bool {
+	transfer := rla.RemoteCopyJob.CopyJobDetails.Transfer
+	if transfer.Stream && !StreamsLogs(transfer, rla.Redactor) {
+		rla.Dcrlog.Info("redacting the mongod logs needs a copy in the temp dir, not streaming them")
+	}
+	return StreamsLogs(transfer, rla.Redactor)
+}
+
 func (rla *RemoteMongoDLogarchive) archiveLogFiles() error {
+	rotation := rla.Rotation.orDefault()
+	patterns := rotation.filePatterns(rla.CurrentLogFileName)
+	dirs := rotation.searchDirs(rla.LogDir)
+	manifest := newLogManifest(rla.LogDir, rla.CurrentLogFileName, patterns, dirs, rla.Window)
+	stream := rla.streaming()
+
+	var files []logFile
 	var err error
-	// Define search pattern based on latest mongod log file name
-	fileSearchPatterString := `^` + rla.CurrentLogFileName + `.*`
-
-	// Debug messages
-	// fmt.Println("la.Logdir", la.LogDir)
-	// fmt.Println("fileSearchPatterString", fileSearchPatterString)
-
-	err = archiver.TarWithPatternMatch(
-		rla.TempOutputdir.Path(),
-		fileSearchPatterString,
-		rla.LogArchiveFile,
-	)
+	if stream {
+		files, err = discoverRemoteLogFiles(rla.RemoteCopyJob.CopyJobDetails, dirs, patterns, rla.CurrentLogFileName, manifest)
+	} else {
+		dirs, err = copyLogDirs(
+			rla.RemoteCopyJob,
+			rla.TempOutputdir.Path(),
+			dirs,
+			patterns,
+			rla.CurrentLogFileName,
+			manifest,
+			rla.Dcrlog,
+		)
+		if err != nil {
+			return err
+		}
+		files, err = discoverLogFiles(dirs, patterns, rla.CurrentLogFileName, manifest)
+	}
 	if err != nil {
-		rla.Dcrlog.Debug(fmt.Sprintf("error in archiveRemoteLogFiles: %s", err))
 		return fmt.Errorf("error in archiveRemoteLogFiles: %w", err)
 	}
-	return nil
-}
 
-func (rla *RemoteMongoDLogarchive) remoteCopyLogFilesToTemp() error {
-	rla.RemoteCopyJob.CopyJobDetails.Src.Path = []byte(rla.LogDir)
-	rla.RemoteCopyJob.CurrentFileName = rla.CurrentLogFileName
-
-	err := rla.RemoteCopyJob.StartCopyWithPattern()
+	// created once the files are found, see MongoDLogarchive.archiveLogFiles
+	err = rla.createMongodTarArchiveFile()
 	if err != nil {
 		return err
 	}
+	defer rla.LogArchiveFile.Close()
+
+	if stream {
+		err = streamLogFileSet(
+			rla.RemoteCopyJob.CopyJobDetails, files, rla.Window, rla.SizeBudget,
+			rla.Outputdir.Path(), rla.LogArchiveFile, manifest, rla.Dcrlog,
+		)
+	} else {
+		err = archiveLogFileSet(files, rla.Window, parseLogLineTime, rla.Redactor, rla.SizeBudget, rla.LogArchiveFile, manifest, rla.Dcrlog)
+	}
+	if merr := manifest.write(rla.Outputdir.Path(), LogManifestFileName); merr != nil {
+		rla.Dcrlog.Warn(fmt.Sprintf("error writing log archive manifest: %s", merr))
+	}
+	if err != nil {
+		rla.Dcrlog.Debug(fmt.Sprintf("error in archiveRemoteLogFiles: %s", err))
+		return fmt.Errorf("error in archiveRemoteLogFiles: %w", err)
+	}
 	return nil
 }
 
@@ -141,20 +207,13 @@ func (rla *RemoteMongoDLogarchive) Start() error {
 		return err
 	}
 	// return early if the mongod log destination is not file
+	if rla.LogDestination == "syslog" {
+		return fmt.Errorf("error: remote MongoDLogArchive only works for systemLog:file: %w", ErrSyslogDestination)
+	}
 	if rla.LogDestination != "file" {
 		return fmt.Errorf("error: remote MongoDLogArchive only works for systemLog:file")
 	}
 
-	err = rla.createMongodTarArchiveFile()
-	if err != nil {
-		return err
-	}
-
-	err = rla.remoteCopyLogFilesToTemp()
-	if err != nil {
-		return err
-	}
-
 	err = rla.archiveLogFiles()
 	if err != nil {
 		return err
@@ -0,0 +1,291 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongologarchiver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"dcrcli/dcrlogger"
+	"dcrcli/fscopy"
+)
+
+// LogNamePlaceholder in a rotation pattern stands for the current log file name.
+const LogNamePlaceholder = "{logname}"
+
+// LogRotation describes where rotated mongod logs are kept. Patterns are glob patterns
+// matched against file names, e.g. "{logname}*" or "mongod-*.log.gz". Dirs are searched
+// in addition to the directory of the current log; relative dirs such as "archive" are
+// relative to it. The current log file is always collected.
+type LogRotation struct {
+	Patterns []string
+	Dirs     []string
+}
+
+// DefaultLogRotation finds files next to the current log whose name starts with it,
+// which covers mongod's own logRotate (rename and reopen) and logrotate's numeric suffixes.
+func DefaultLogRotation() LogRotation {
+	return LogRotation{Patterns: []string{LogNamePlaceholder + "*"}}
+}
+
+// Validate checks that every pattern is a valid glob.
+func (lr LogRotation) Validate() error {
+	for _, p := range lr.Patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid rotated log pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+func (lr LogRotation) orDefault() LogRotation {
+	if len(lr.Patterns) == 0 {
+		lr.Patterns = DefaultLogRotation().Patterns
+	}
+	return lr
+}
+
+// filePatterns expands the placeholder for the given current log file name.
+func (lr LogRotation) filePatterns(currentLogFileName string) []string {
+	patterns := make([]string, 0, len(lr.Patterns))
+	for _, p := range lr.Patterns {
+		patterns = append(patterns, strings.ReplaceAll(p, LogNamePlaceholder, currentLogFileName))
+	}
+	return patterns
+}
+
+// logSearchDir is a directory searched for rotated logs. Path is where it can be read,
+// Source is the directory on the node, and Prefix is where its files go in the archive.
+type logSearchDir struct {
+	Path   string
+	Source string
+	Prefix string
+}
+
+// searchDirs returns the log directory first, followed by the extra directories.
+func (lr LogRotation) searchDirs(logDir string) []logSearchDir {
+	dirs := []logSearchDir{{Path: logDir, Source: logDir}}
+	seen := map[string]bool{filepath.Clean(logDir): true}
+	for _, d := range lr.Dirs {
+		path := d
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(logDir, path)
+		}
+		path = filepath.Clean(path)
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		prefix, err := filepath.Rel(logDir, path)
+		if err != nil || strings.HasPrefix(prefix, "..") {
+			prefix = filepath.Base(path)
+		}
+		dirs = append(dirs, logSearchDir{Path: path, Source: path, Prefix: filepath.ToSlash(prefix)})
+	}
+	return dirs
+}
+
+// compressedExtensions are rotated logs that are archived as they are.
+var compressedExtensions = []string{".gz", ".bz2", ".xz", ".zst", ".lz4", ".zip"}
+
+func isCompressedLogFile(name string) bool {
+	for _, ext := range compressedExtensions {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAnyPattern(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// discoverLogFiles finds the current log and the rotated logs matching patterns in dirs,
+// ordered oldest first, and works out the time range each one covers, see newLogFile.
+// Extra directories that cannot be read are recorded in the manifest.
+func discoverLogFiles(
+	dirs []logSearchDir,
+	patterns []string,
+	currentLogFileName string,
+	manifest *logManifest,
+) ([]logFile, error) {
+	files := make([]logFile, 0)
+	for i, dir := range dirs {
+		entries, err := os.ReadDir(dir.Path)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			manifest.skipDir(dir.Source, err)
+			continue
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+			isCurrent := i == 0 && name == currentLogFileName
+			if !entry.Type().IsRegular() || (!isCurrent && !matchesAnyPattern(name, patterns)) {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			f := newLogFile(dir, name, info.Size(), info.ModTime(), currentLogFileName, isCurrent)
+			f.Path = filepath.Join(dir.Path, name)
+			files = append(files, f)
+		}
+	}
+
+	sortLogFiles(files)
+	return files, nil
+}
+
+// newLogFile describes the file name of dir. The end of a rotated file is taken from its
+// name when mongod renamed it, otherwise from its modification time.
+func newLogFile(
+	dir logSearchDir,
+	name string,
+	size int64,
+	modTime time.Time,
+	currentLogFileName string,
+	isCurrent bool,
+) logFile {
+	end := modTime
+	if t, ok := rotatedLogTime(currentLogFileName, name); ok {
+		end = t
+	}
+	archiveName := name
+	if dir.Prefix != "" {
+		archiveName = dir.Prefix + "/" + name
+	}
+	return logFile{
+		Name:       archiveName,
+		Source:     filepath.Join(dir.Source, name),
+		Size:       size,
+		End:        end,
+		Current:    isCurrent,
+		Compressed: isCompressedLogFile(name),
+	}
+}
+
+// discoverRemoteLogFiles is discoverLogFiles for the directories on the node of job,
+// listed over SSH. The files have no Path; they are read with job.StreamFiles.
+func discoverRemoteLogFiles(
+	job *fscopy.FSCopyJob,
+	dirs []logSearchDir,
+	patterns []string,
+	currentLogFileName string,
+	manifest *logManifest,
+) ([]logFile, error) {
+	files := make([]logFile, 0)
+	for i, dir := range dirs {
+		listed, err := job.ListFiles(dir.Source)
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			manifest.skipDir(dir.Source, err)
+			continue
+		}
+		for _, rf := range listed {
+			isCurrent := i == 0 && rf.Name == currentLogFileName
+			if !isCurrent && !matchesAnyPattern(rf.Name, patterns) {
+				continue
+			}
+			files = append(files, newLogFile(dir, rf.Name, rf.Size, rf.ModTime, currentLogFileName, isCurrent))
+		}
+	}
+
+	sortLogFiles(files)
+	return files, nil
+}
+
+// sortLogFiles orders files oldest first, keeps the current log file last, and sets each
+// file's Start to the End of the file before it.
+func sortLogFiles(files []logFile) {
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].Current != files[j].Current {
+			return files[j].Current
+		}
+		return files[i].End.Before(files[j].End)
+	})
+	for i := range files {
+		files[i].Start = time.Time{}
+		if i > 0 {
+			files[i].Start = files[i-1].End
+		}
+	}
+}
+
+// copyLogDirs copies the files matching patterns from every search directory of a node
+// into tempDir and returns the directories pointed at the copies. The current log file is
+// always copied. An extra directory that cannot be copied is recorded in the manifest and
+// left out, and files that cannot be read are logged.
+func copyLogDirs(
+	job *fscopy.FSCopyJobWithPattern,
+	tempDir string,
+	dirs []logSearchDir,
+	patterns []string,
+	currentLogFileName string,
+	manifest *logManifest,
+	dcrlog *dcrlogger.DCRLogger,
+) ([]logSearchDir, error) {
+	copied := make([]logSearchDir, 0, len(dirs))
+	for i, dir := range dirs {
+		dir.Path = filepath.Join(tempDir, filepath.FromSlash(dir.Prefix))
+		err := os.MkdirAll(dir.Path, 0755)
+		if err != nil {
+			return nil, fmt.Errorf("error creating temp dir for logs: %w", err)
+		}
+
+		job.CopyJobDetails.Src.Path = []byte(dir.Source)
+		job.CopyJobDetails.Dst.Path = []byte(dir.Path)
+		job.CurrentFileName = currentLogFileName
+		job.Patterns = patterns
+		job.AppendOnly = true
+		if i == 0 && !matchesAnyPattern(currentLogFileName, patterns) {
+			job.Patterns = append([]string{currentLogFileName}, patterns...)
+		}
+
+		err = job.StartCopyWithPattern()
+		var unreadable *fscopy.UnreadableError
+		if errors.As(err, &unreadable) {
+			dcrlog.Warn(fmt.Sprintf("archiving the readable log files of %s only: %s", dir.Source, err))
+			err = nil
+		}
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+			dcrlog.Warn(fmt.Sprintf("skipping rotated log dir %s: %s", dir.Source, err))
+			manifest.skipDir(dir.Source, err)
+			continue
+		}
+		copied = append(copied, dir)
+	}
+	return copied, nil
+}
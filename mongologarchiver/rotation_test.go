@@ -0,0 +1,316 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongologarchiver
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dcrcli/archiver"
+	"dcrcli/budget"
+	"dcrcli/dcrlogger"
+	"dcrcli/dcroutdir"
+	"dcrcli/fscopy"
+	"dcrcli/timewindow"
+)
+
+func writeGzip(t *testing.T, path string, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	gzw.Write([]byte(content))
+	gzw.Close()
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDiscoverLogFilesWithPatternsAndDirs(t *testing.T) {
+	logDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(logDir, "archive"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"mongod.log", "mongod.log.1", "unrelated.txt"} {
+		if err := os.WriteFile(filepath.Join(logDir, name), []byte("x\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeGzip(t, filepath.Join(logDir, "archive", "mongod-20240301.log.gz"), "old\n")
+
+	rotation := LogRotation{
+		Patterns: []string{"{logname}.*", "mongod-*.log.gz"},
+		Dirs:     []string{"archive", "/does/not/exist"},
+	}
+	patterns := rotation.filePatterns("mongod.log")
+	dirs := rotation.searchDirs(logDir)
+	manifest := newLogManifest(logDir, "mongod.log", patterns, dirs, timewindow.Window{})
+
+	files, err := discoverLogFiles(dirs, patterns, "mongod.log", manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]logFile{}
+	for _, f := range files {
+		names[f.Name] = f
+	}
+	if len(files) != 3 || !names["archive/mongod-20240301.log.gz"].Compressed || !names["mongod.log"].Current {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	if files[len(files)-1].Name != "mongod.log" {
+		t.Fatalf("current log file is not last: %+v", files)
+	}
+	if len(manifest.SkippedDirs) != 1 || manifest.SkippedDirs[0].Path != "/does/not/exist" {
+		t.Fatalf("missing dir not recorded: %+v", manifest.SkippedDirs)
+	}
+}
+
+func TestLogRotationValidate(t *testing.T) {
+	if err := (LogRotation{Patterns: []string{"mongod.log.[0-9"}}).Validate(); err == nil {
+		t.Fatal("expected an error for a malformed pattern")
+	}
+	if err := DefaultLogRotation().Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchiveLogFileSetStoresCompressedFiles(t *testing.T) {
+	dir := t.TempDir()
+	current := filepath.Join(dir, "mongod.log")
+	content := `{"t":{"$date":"2024-03-09T10:00:00.000+00:00"},"msg":"new"}` + "\n"
+	if err := os.WriteFile(current, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rotatedPath := filepath.Join(dir, "mongod.log.1.gz")
+	rotated := writeGzip(t, rotatedPath, `{"t":{"$date":"2024-03-01T10:00:00.000+00:00"},"msg":"old"}`+"\n")
+
+	files := []logFile{
+		{Name: "mongod.log.1.gz", Path: rotatedPath, Source: rotatedPath, Compressed: true,
+			End: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)},
+		{Name: "mongod.log", Path: current, Source: current, Current: true,
+			Start: time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC), End: time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)},
+	}
+	window := timewindow.Window{Since: time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)}
+	manifest := newLogManifest(dir, "mongod.log", []string{"mongod.log*"}, nil, window)
+	dcrlog := dcrlogger.DCRLogger{OutputPrefix: t.TempDir() + "/", FileName: "rotation_test"}
+	if err := dcrlog.Create(); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := archiveLogFileSet(files, window, parseLogLineTime, nil, nil, &out, manifest, &dcrlog); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Included) != 1 || len(manifest.Skipped) != 1 || manifest.Skipped[0].Path != rotatedPath {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+
+	// without a window the compressed file is archived byte for byte
+	manifest = newLogManifest(dir, "mongod.log", []string{"mongod.log*"}, nil, timewindow.Window{})
+	out.Reset()
+	if err := archiveLogFileSet(files, timewindow.Window{}, parseLogLineTime, nil, nil, &out, manifest, &dcrlog); err != nil {
+		t.Fatal(err)
+	}
+	gzr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	header, err := tr.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(tr)
+	if header.Name != "mongod.log.1.gz" || !bytes.Equal(data, rotated) {
+		t.Fatalf("compressed entry %s was modified", header.Name)
+	}
+
+	if err := manifest.write(dir, LogManifestFileName); err != nil {
+		t.Fatal(err)
+	}
+	raw, _ := os.ReadFile(filepath.Join(dir, LogManifestFileName))
+	var decoded logManifest
+	if err := json.Unmarshal(raw, &decoded); err != nil || len(decoded.Included) != 2 || !decoded.Included[0].Compressed {
+		t.Fatalf("unexpected manifest file: %s (%v)", raw, err)
+	}
+}
+
+func TestArchiveLogFileSetKeepsNewestWithinBudget(t *testing.T) {
+	dir := t.TempDir()
+	var files []logFile
+	for i, name := range []string{"mongod.log.2", "mongod.log.1", "mongod.log"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, bytes.Repeat([]byte("x"), 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, logFile{Name: name, Path: path, Source: path, Size: 100,
+			End: time.Date(2024, 3, 1+i, 0, 0, 0, 0, time.UTC), Current: name == "mongod.log"})
+	}
+	manifest := newLogManifest(dir, "mongod.log", []string{"mongod.log*"}, nil, timewindow.Window{})
+	dcrlog := dcrlogger.DCRLogger{OutputPrefix: t.TempDir() + "/", FileName: "rotation_test"}
+	if err := dcrlog.Create(); err != nil {
+		t.Fatal(err)
+	}
+	record := &budget.Record{}
+	sizes := &budget.Set{
+		Kind:    "logs",
+		Budgets: []*budget.Budget{{Name: "logs_per_node", Limit: 250}},
+		Record:  record,
+	}
+
+	var out bytes.Buffer
+	if err := archiveLogFileSet(files, timewindow.Window{}, parseLogLineTime, nil, sizes, &out, manifest, &dcrlog); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Included) != 2 || manifest.Included[0].Name != "mongod.log.1" || manifest.Included[1].Name != "mongod.log" {
+		t.Fatalf("expected the two newest files, got %+v", manifest.Included)
+	}
+	if len(record.Dropped) != 1 || record.Dropped[0].Name != files[0].Source || record.Dropped[0].Budget != "logs_per_node" {
+		t.Fatalf("unexpected record: %+v", record.Dropped)
+	}
+}
+
+func TestStreamLogFileSet(t *testing.T) {
+	logDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(logDir, "archive"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeGzip(t, filepath.Join(logDir, "archive", "mongod.log.1.gz"), "old\n")
+	for _, name := range []string{"mongod.log.2", "mongod.log"} {
+		if err := os.WriteFile(filepath.Join(logDir, name), []byte(name+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dcrlog := dcrlogger.DCRLogger{OutputPrefix: t.TempDir() + "/", FileName: "rotation_test"}
+	if err := dcrlog.Create(); err != nil {
+		t.Fatal(err)
+	}
+	job := &fscopy.FSCopyJob{Src: fscopy.SourceDir{IsLocal: true}, Dcrlog: &dcrlog}
+
+	rotation := LogRotation{Patterns: []string{"{logname}.*"}, Dirs: []string{"archive"}}
+	patterns := rotation.filePatterns("mongod.log")
+	dirs := rotation.searchDirs(logDir)
+	manifest := newLogManifest(logDir, "mongod.log", patterns, dirs, timewindow.Window{})
+	files, err := discoverRemoteLogFiles(job, dirs, patterns, "mongod.log", manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || files[2].Name != "mongod.log" || files[2].Path != "" {
+		t.Fatalf("unexpected files: %+v", files)
+	}
+	// removed on the node after it was listed
+	os.Remove(filepath.Join(logDir, "mongod.log.2"))
+
+	var out bytes.Buffer
+	if err := streamLogFileSet(job, files, timewindow.Window{}, nil, t.TempDir(), &out, manifest, &dcrlog); err != nil {
+		t.Fatal(err)
+	}
+	gzr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gzr)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	if len(names) != 2 || names[0] != "archive/mongod.log.1.gz" || names[1] != "mongod.log" {
+		t.Fatalf("unexpected archive entries %v", names)
+	}
+	if len(manifest.Included) != 2 || len(manifest.Skipped) != 1 || manifest.Skipped[0].Reason != "could not be read on the node" {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+}
+
+func TestFailedLogCopyLeavesNoArchive(t *testing.T) {
+	dcrlog := dcrlogger.DCRLogger{OutputPrefix: t.TempDir() + "/", FileName: "rotation_test"}
+	if err := dcrlog.Create(); err != nil {
+		t.Fatal(err)
+	}
+	newDir := func() *dcroutdir.DCROutputDir {
+		d := &dcroutdir.DCROutputDir{OutputPrefix: t.TempDir() + "/", Hostname: "db1", Port: "27017"}
+		if err := d.CreateDCROutputDir(); err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	// the log dir cannot be copied, as when mongod logs inside a container
+	logDir := filepath.Join(t.TempDir(), "missing")
+	newJob := func() *fscopy.FSCopyJobWithPattern {
+		job := &fscopy.FSCopyJob{Src: fscopy.SourceDir{IsLocal: true}, Dcrlog: &dcrlog}
+		return &fscopy.FSCopyJobWithPattern{CopyJobDetails: job, Dcrlog: &dcrlog}
+	}
+
+	local := MongoDLogarchive{
+		LogDir:             logDir,
+		CurrentLogFileName: "mongod.log",
+		Outputdir:          newDir(),
+		TempOutputdir:      newDir(),
+		CopyJob:            newJob(),
+		Dcrlog:             &dcrlog,
+	}
+	remote := RemoteMongoDLogarchive{
+		LogDir:             logDir,
+		CurrentLogFileName: "mongod.log",
+		Outputdir:          newDir(),
+		TempOutputdir:      newDir(),
+		RemoteCopyJob:      newJob(),
+		Dcrlog:             &dcrlog,
+	}
+	archivers := map[string]struct {
+		archive func() error
+		out     *dcroutdir.DCROutputDir
+	}{
+		"local":  {local.archiveLogFiles, local.Outputdir},
+		"remote": {remote.archiveLogFiles, remote.Outputdir},
+	}
+	for name, tt := range archivers {
+		if err := tt.archive(); err == nil {
+			t.Fatalf("%s: copying a missing log dir should fail", name)
+		}
+		if _, err := os.Stat(filepath.Join(tt.out.Path(), "logarchive.tar.gz")); err == nil {
+			t.Errorf("%s: a failed copy left logarchive.tar.gz behind", name)
+		}
+	}
+
+	if err := os.Mkdir(logDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(logDir, "mongod.log"), []byte("current\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for name, tt := range archivers {
+		if err := tt.archive(); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if n, err := archiver.Verify(filepath.Join(tt.out.Path(), "logarchive.tar.gz")); err != nil || n != 1 {
+			t.Errorf("%s: logarchive.tar.gz holds %d entries: %v", name, n, err)
+		}
+	}
+}
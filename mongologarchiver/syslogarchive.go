@@ -0,0 +1,291 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongologarchiver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"dcrcli/archiver"
+	"dcrcli/dcrlogger"
+	"dcrcli/dcroutdir"
+	"dcrcli/fscopy"
+	"dcrcli/mongosh"
+	"dcrcli/redactor"
+)
+
+// ErrSyslogDestination is returned by the file based log archivers when mongod logs to
+// syslog. Callers can collect the log with a SyslogArchive instead.
+var ErrSyslogDestination = errors.New("mongod log destination is syslog")
+
+// DefaultSyslogLookback is how far back journald and syslog files are searched when no
+// start of the time window is given.
+const DefaultSyslogLookback = 24 * time.Hour
+
+// DefaultSyslogFiles are searched, in order, when journald has no entries for the node.
+var DefaultSyslogFiles = []string{"/var/log/syslog", "/var/log/messages"}
+
+// SyslogArchive extracts the mongod entries from journald, or from the syslog files when
+// journald is not available, and archives them into logarchive.tar.gz.
+type SyslogArchive struct {
+	Mongo          mongosh.CaptureGetMongoData
+	CommandJob     *fscopy.FSCopyJob  // runs journalctl or grep on the node, locally or over ssh
+	Identifier     string             // syslog identifier; discovered from argv[0] when empty
+	SyslogFiles    []string           // defaults to DefaultSyslogFiles
+	Since          time.Time          // zero means DefaultSyslogLookback before Until
+	Until          time.Time          // zero means now
+	Redactor       *redactor.Redactor // nil archives the entries unredacted
+	LogArchiveFile *os.File
+	Outputdir      *dcroutdir.DCROutputDir
+	Dcrlog         *dcrlogger.DCRLogger
+}
+
+func (sa *SyslogArchive) resolveIdentifier() error {
+	if sa.Identifier != "" {
+		return nil
+	}
+	err := sa.Mongo.RunGetSyslogIdentifier()
+	if err != nil {
+		return err
+	}
+	sa.Identifier = trimQuote(sa.Mongo.Getparsedjsonoutput.String())
+	if sa.Identifier == "" {
+		sa.Identifier = "mongod"
+	}
+	sa.Dcrlog.Debug(fmt.Sprintf("syslog identifier: %s", sa.Identifier))
+	return nil
+}
+
+func (sa *SyslogArchive) resolveWindow() {
+	if sa.Until.IsZero() {
+		sa.Until = time.Now()
+	}
+	if sa.Since.IsZero() {
+		sa.Since = sa.Until.Add(-DefaultSyslogLookback)
+	}
+}
+
+func journaldTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05") + " UTC"
+}
+
+func (sa *SyslogArchive) journalctlArgs() []string {
+	return []string{
+		"journalctl",
+		"--no-pager",
+		"--quiet",
+		"--output=short-iso-precise",
+		"--identifier=" + sa.Identifier,
+		"--since=" + journaldTime(sa.Since),
+		"--until=" + journaldTime(sa.Until),
+	}
+}
+
+func (sa *SyslogArchive) syslogFiles() []string {
+	if len(sa.SyslogFiles) == 0 {
+		return DefaultSyslogFiles
+	}
+	return sa.SyslogFiles
+}
+
+func (sa *SyslogArchive) grepArgs() []string {
+	// matches "mongod[1234]:" and "mongod:" as written by syslog(3)
+	pattern := `(^|[[:space:]])` + regexp.QuoteMeta(sa.Identifier) + `(\[[0-9]+\])?:`
+	args := []string{"grep", "--no-filename", "--no-messages", "-E", "-e", pattern, "--"}
+	return append(args, sa.syslogFiles()...)
+}
+
+// collectFromJournald writes the journald entries for the identifier into out and
+// reports whether journald returned any.
+func (sa *SyslogArchive) collectFromJournald(out *os.File) (bool, error) {
+	err := sa.CommandJob.RunCommand(sa.journalctlArgs(), out)
+	if err != nil {
+		return false, err
+	}
+	fi, err := out.Stat()
+	if err != nil {
+		return false, err
+	}
+	return fi.Size() > 0, nil
+}
+
+// collectFromSyslogFiles greps the syslog files for the identifier and keeps the lines
+// inside the time window.
+func (sa *SyslogArchive) collectFromSyslogFiles(out *os.File) (bool, error) {
+	filter := &syslogWindowWriter{Out: out, Since: sa.Since, Until: sa.Until}
+	err := sa.CommandJob.RunCommand(sa.grepArgs(), filter)
+	if err != nil {
+		var cmdErr *fscopy.CommandError
+		// grep exits 1 for no matches and 2 when one of the files is missing
+		if !errors.As(err, &cmdErr) || (cmdErr.ExitCode() != 1 && cmdErr.ExitCode() != 2) {
+			return false, err
+		}
+	}
+	if err := filter.Flush(); err != nil {
+		return false, err
+	}
+	return filter.Kept > 0, nil
+}
+
+func (sa *SyslogArchive) createMongodTarArchiveFile() error {
+	var err error
+	sa.LogArchiveFile, err = os.Create(sa.Outputdir.Path() + "/logarchive.tar.gz")
+	if err != nil {
+		return fmt.Errorf("error creating archive file in outputs folder %w", err)
+	}
+	return nil
+}
+
+func (sa *SyslogArchive) Start() error {
+	err := sa.resolveIdentifier()
+	if err != nil {
+		return fmt.Errorf("error in SyslogArchive resolving syslog identifier: %w", err)
+	}
+	sa.resolveWindow()
+
+	extracted, err := os.CreateTemp("", "dcrcli-syslog-*.log")
+	if err != nil {
+		return fmt.Errorf("error in SyslogArchive: %w", err)
+	}
+	defer os.Remove(extracted.Name())
+	defer extracted.Close()
+
+	source := "journald"
+	found, err := sa.collectFromJournald(extracted)
+	if err != nil {
+		sa.Dcrlog.Debug(fmt.Sprintf("journald extraction failed, trying syslog files: %v", err))
+	}
+	if !found {
+		source = "syslog"
+		if err := extracted.Truncate(0); err != nil {
+			return fmt.Errorf("error in SyslogArchive: %w", err)
+		}
+		if _, err := extracted.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("error in SyslogArchive: %w", err)
+		}
+		found, err = sa.collectFromSyslogFiles(extracted)
+		if err != nil {
+			return fmt.Errorf("error in SyslogArchive reading syslog files: %w", err)
+		}
+	}
+	if !found {
+		return fmt.Errorf(
+			"error in SyslogArchive: no %s entries between %s and %s in journald or %s",
+			sa.Identifier,
+			sa.Since.Format(time.RFC3339),
+			sa.Until.Format(time.RFC3339),
+			strings.Join(sa.syslogFiles(), ", "),
+		)
+	}
+	sa.Dcrlog.Info(fmt.Sprintf("extracted %s entries for %s from %s", sa.Identifier, sa.Outputdir.Path(), source))
+
+	path := extracted.Name()
+	if sa.Redactor != nil {
+		path, err = sa.Redactor.RedactLogFile(extracted.Name())
+		if err != nil {
+			return fmt.Errorf("error in SyslogArchive redacting entries: %w", err)
+		}
+		defer os.Remove(path)
+	}
+
+	err = sa.createMongodTarArchiveFile()
+	if err != nil {
+		return err
+	}
+	defer sa.LogArchiveFile.Close()
+
+	return archiver.TarFiles(
+		[]archiver.FileEntry{{Name: sa.Identifier + "." + source + ".log", Path: path}},
+		sa.LogArchiveFile,
+	)
+}
+
+// syslogWindowWriter is an io.Writer that keeps only the syslog lines inside [Since, Until].
+// Lines without a recognisable timestamp follow the decision for the previous line.
+type syslogWindowWriter struct {
+	Out     io.Writer
+	Since   time.Time
+	Until   time.Time
+	Kept    int
+	partial []byte
+	keep    bool
+}
+
+func (sw *syslogWindowWriter) Write(p []byte) (int, error) {
+	data := append(sw.partial, p...)
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		if err := sw.writeLine(data[:i+1]); err != nil {
+			return 0, err
+		}
+		data = data[i+1:]
+	}
+	sw.partial = append([]byte(nil), data...)
+	return len(p), nil
+}
+
+// Flush writes out a trailing line that had no newline.
+func (sw *syslogWindowWriter) Flush() error {
+	if len(sw.partial) == 0 {
+		return nil
+	}
+	line := append(sw.partial, '\n')
+	sw.partial = nil
+	return sw.writeLine(line)
+}
+
+func (sw *syslogWindowWriter) writeLine(line []byte) error {
+	if t, ok := parseSyslogTime(string(line), sw.Until); ok {
+		sw.keep = !t.Before(sw.Since) && !t.After(sw.Until)
+	}
+	if !sw.keep {
+		return nil
+	}
+	sw.Kept++
+	_, err := sw.Out.Write(line)
+	return err
+}
+
+// parseSyslogTime reads the timestamp at the start of a syslog line. Both the RFC 3339
+// format of high precision rsyslog templates and the traditional "Jan _2 15:04:05" format
+// are understood; the latter has no year, so the year closest before ref is assumed.
+func parseSyslogTime(line string, ref time.Time) (time.Time, bool) {
+	if i := strings.IndexByte(line, ' '); i > 0 {
+		if t, err := time.Parse(time.RFC3339Nano, line[:i]); err == nil {
+			return t, true
+		}
+	}
+	if len(line) < len(time.Stamp) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(time.Stamp, line[:len(time.Stamp)], ref.Location())
+	if err != nil {
+		return time.Time{}, false
+	}
+	t = t.AddDate(ref.Year(), 0, 0)
+	if t.After(ref.Add(24 * time.Hour)) {
+		t = t.AddDate(-1, 0, 0)
+	}
+	return t, true
+}
@@ -0,0 +1,81 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mongologarchiver
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseSyslogTimeFormats(t *testing.T) {
+	ref := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	got, ok := parseSyslogTime("2024-03-09T08:15:00.123456+00:00 db1 mongod[42]: msg", ref)
+	if !ok || !got.Equal(time.Date(2024, 3, 9, 8, 15, 0, 123456000, time.UTC)) {
+		t.Fatalf("RFC3339 syslog time parsed as %v, %v", got, ok)
+	}
+
+	got, ok = parseSyslogTime("Mar  9 08:15:00 db1 mongod[42]: msg", ref)
+	if !ok || !got.Equal(time.Date(2024, 3, 9, 8, 15, 0, 0, time.UTC)) {
+		t.Fatalf("traditional syslog time parsed as %v, %v", got, ok)
+	}
+
+	// December entries read in January belong to the previous year
+	got, ok = parseSyslogTime("Dec 31 23:59:00 db1 mongod[42]: msg", time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC))
+	if !ok || got.Year() != 2023 {
+		t.Fatalf("year rollover parsed as %v, %v", got, ok)
+	}
+
+	if _, ok := parseSyslogTime("    continuation", ref); ok {
+		t.Fatal("line without timestamp should not parse")
+	}
+}
+
+func TestSyslogWindowWriterKeepsLinesInWindow(t *testing.T) {
+	var out bytes.Buffer
+	sw := &syslogWindowWriter{
+		Out:   &out,
+		Since: time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC),
+		Until: time.Date(2024, 3, 9, 23, 59, 59, 0, time.UTC),
+	}
+
+	input := "Mar  8 10:00:00 db1 mongod[1]: too early\n" +
+		"Mar  9 10:00:00 db1 mongod[1]: inside\n" +
+		"  continuation of inside\n" +
+		"Mar 10 10:00:00 db1 mongod[1]: too late\n" +
+		"Mar  9 11:00:00 db1 mongod[1]: no trailing newline"
+
+	// split writes across a line boundary to exercise buffering
+	if _, err := sw.Write([]byte(input[:50])); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sw.Write([]byte(input[50:])); err != nil {
+		t.Fatal(err)
+	}
+	if err := sw.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Mar  9 10:00:00 db1 mongod[1]: inside\n" +
+		"  continuation of inside\n" +
+		"Mar  9 11:00:00 db1 mongod[1]: no trailing newline\n"
+	if out.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+	if sw.Kept != 3 {
+		t.Fatalf("Kept = %d want 3", sw.Kept)
+	}
+}
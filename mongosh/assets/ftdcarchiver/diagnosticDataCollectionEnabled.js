@@ -0,0 +1 @@
+db.adminCommand({getParameter: 1, "diagnosticDataCollectionEnabled" : 1 }).diagnosticDataCollectionEnabled
@@ -0,0 +1,108 @@
+// Polls serverStatus and replSetGetStatus _sampleCount times, _sampleIntervalMS apart.
+// Both variables are prepended by dcrcli before evaluation.
+// Every sample is a flat document of metric name to number (or null when not reported),
+// returned as a JSON string so mongo and mongosh print it the same way.
+(function () {
+  function num(v) {
+    if (v === undefined || v === null) {
+      return null;
+    }
+    if (typeof v === "number") {
+      return v;
+    }
+    if (typeof v.toNumber === "function") {
+      return v.toNumber();
+    }
+    var n = Number(v);
+    return isNaN(n) ? null : n;
+  }
+
+  function get(doc, path) {
+    var cur = doc;
+    for (var i = 0; i < path.length; i++) {
+      if (cur === undefined || cur === null) {
+        return null;
+      }
+      cur = cur[path[i]];
+    }
+    return num(cur);
+  }
+
+  function sampleServerStatus(s) {
+    var ss = db.adminCommand({ serverStatus: 1, metrics: 0, locks: 0 });
+    if (!ss.ok) {
+      return;
+    }
+    s.ts = ss.localTime ? new Date(ss.localTime).getTime() : new Date().getTime();
+    s["uptime"] = num(ss.uptime);
+    ["insert", "query", "update", "delete", "getmore", "command"].forEach(function (op) {
+      s["opcounters." + op] = get(ss, ["opcounters", op]);
+    });
+    s["connections.current"] = get(ss, ["connections", "current"]);
+    s["connections.available"] = get(ss, ["connections", "available"]);
+    s["connections.totalCreated"] = get(ss, ["connections", "totalCreated"]);
+    s["mem.resident"] = get(ss, ["mem", "resident"]);
+    s["mem.virtual"] = get(ss, ["mem", "virtual"]);
+    s["network.bytesIn"] = get(ss, ["network", "bytesIn"]);
+    s["network.bytesOut"] = get(ss, ["network", "bytesOut"]);
+    s["globalLock.currentQueue.readers"] = get(ss, ["globalLock", "currentQueue", "readers"]);
+    s["globalLock.currentQueue.writers"] = get(ss, ["globalLock", "currentQueue", "writers"]);
+    s["wiredTiger.cache.bytesInCache"] = get(ss, ["wiredTiger", "cache", "bytes currently in the cache"]);
+    s["wiredTiger.cache.maxBytesConfigured"] = get(ss, ["wiredTiger", "cache", "maximum bytes configured"]);
+    s["wiredTiger.cache.trackedDirtyBytes"] = get(ss, ["wiredTiger", "cache", "tracked dirty bytes in the cache"]);
+    // tickets moved from wiredTiger.concurrentTransactions to queues.execution in 7.0
+    ["read", "write"].forEach(function (rw) {
+      var out = get(ss, ["wiredTiger", "concurrentTransactions", rw, "out"]);
+      var available = get(ss, ["wiredTiger", "concurrentTransactions", rw, "available"]);
+      if (out === null) {
+        out = get(ss, ["queues", "execution", rw, "out"]);
+        available = get(ss, ["queues", "execution", rw, "available"]);
+      }
+      s["tickets." + rw + ".out"] = out;
+      s["tickets." + rw + ".available"] = available;
+    });
+  }
+
+  function sampleReplSetStatus(s) {
+    var rs;
+    try {
+      rs = db.adminCommand({ replSetGetStatus: 1 });
+    } catch (e) {
+      return;
+    }
+    if (!rs || !rs.ok || !rs.members) {
+      return;
+    }
+    var self = null;
+    var primary = null;
+    rs.members.forEach(function (m) {
+      if (m.self) {
+        self = m;
+      }
+      if (m.state === 1) {
+        primary = m;
+      }
+    });
+    if (self !== null) {
+      s["repl.state"] = num(self.state);
+    }
+    if (self !== null && primary !== null && self.optimeDate && primary.optimeDate) {
+      s["repl.lagSeconds"] = (new Date(primary.optimeDate).getTime() - new Date(self.optimeDate).getTime()) / 1000;
+    }
+  }
+
+  var samples = [];
+  for (var i = 0; i < _sampleCount; i++) {
+    if (i > 0) {
+      sleep(_sampleIntervalMS);
+    }
+    var s = {};
+    sampleServerStatus(s);
+    sampleReplSetStatus(s);
+    if (s.ts === undefined) {
+      s.ts = new Date().getTime();
+    }
+    samples.push(s);
+  }
+  return JSON.stringify(samples);
+})()
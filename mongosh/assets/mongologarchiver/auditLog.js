@@ -0,0 +1,6 @@
+// auditLog options of Enterprise nodes, {} when auditing is not enabled.
+// Returned as a JSON string so mongo and mongosh print it the same way.
+(function () {
+  var opts = db.adminCommand({ getCmdLineOpts: 1 });
+  return JSON.stringify((opts.parsed && opts.parsed.auditLog) || {});
+})()
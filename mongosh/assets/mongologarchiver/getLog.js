@@ -0,0 +1,19 @@
+// Reads the in-memory RAM log buffers. Used when the mongod log file cannot be copied.
+// Returned as a JSON string so mongo and mongosh print it the same way.
+(function () {
+  function getLog(name) {
+    try {
+      var res = db.adminCommand({ getLog: name });
+      return {
+        lines: res.log || [],
+        totalLinesWritten: res.totalLinesWritten !== undefined ? Number(res.totalLinesWritten) : null,
+      };
+    } catch (e) {
+      return { lines: [], error: String(e.message || e) };
+    }
+  }
+  return JSON.stringify({
+    global: getLog("global"),
+    startupWarnings: getLog("startupWarnings"),
+  });
+})()
@@ -0,0 +1,6 @@
+// mongod/mongos open syslog with the binary name from argv[0] as the identifier
+(function () {
+  var opts = db.adminCommand({ getCmdLineOpts: 1 });
+  var argv0 = opts.argv && opts.argv.length > 0 ? String(opts.argv[0]) : "mongod";
+  return argv0.split("/").pop();
+})()
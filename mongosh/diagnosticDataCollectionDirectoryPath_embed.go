@@ -20,3 +20,6 @@ import (
 
 //go:embed assets/ftdcarchiver/diagnosticDataCollectionDirectoryPath.js
 var GetCommandDiagnosticDataCollectionDirectoryPath string
+
+//go:embed assets/ftdcarchiver/diagnosticDataCollectionEnabled.js
+var GetCommandDiagnosticDataCollectionEnabled string
@@ -18,10 +18,12 @@ import (
 	"bytes"
 	"dcrcli/dcroutdir"
 	"dcrcli/mongocredentials"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 )
 
 func binPath() string {
@@ -399,6 +401,44 @@ func (cgm *CaptureGetMongoData) RunGetMongoDLogDetails() error {
 	return nil
 }
 
+func (cgm *CaptureGetMongoData) RunGetSyslogIdentifier() error {
+	cgm.Getparsedjsonoutput = &bytes.Buffer{}
+	cgm.CurrentCommand = &GetSyslogIdentifierCommand
+
+	err := cgm.RunCurrentDBCommand()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RunGetAuditLogOptions captures the auditLog options as a JSON string; see UnwrapJSONString.
+func (cgm *CaptureGetMongoData) RunGetAuditLogOptions() error {
+	cgm.Getparsedjsonoutput = &bytes.Buffer{}
+	cgm.CurrentCommand = &GetAuditLogOptionsCommand
+
+	err := cgm.RunCurrentDBCommand()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RunGetLogWithEval captures getLog "global" and "startupWarnings" as a JSON string; see UnwrapJSONString.
+func (cgm *CaptureGetMongoData) RunGetLogWithEval() error {
+	cgm.Getparsedjsonoutput = &bytes.Buffer{}
+	cgm.CurrentCommand = &GetLogScriptCode
+
+	err := cgm.RunCurrentDBCommand()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (cgm *CaptureGetMongoData) RunGetCommandDiagnosticDataCollectionDirectoryPath() error {
 	cgm.Getparsedjsonoutput = &bytes.Buffer{}
 	cgm.Getparsedjsonoutput.Reset()
@@ -411,3 +451,50 @@ func (cgm *CaptureGetMongoData) RunGetCommandDiagnosticDataCollectionDirectoryPa
 
 	return nil
 }
+
+func (cgm *CaptureGetMongoData) RunGetCommandDiagnosticDataCollectionEnabled() error {
+	cgm.Getparsedjsonoutput = &bytes.Buffer{}
+	cgm.CurrentCommand = &GetCommandDiagnosticDataCollectionEnabled
+
+	err := cgm.RunCurrentDBCommand()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// RunMetricsSamplerWithEval polls serverStatus/replSetGetStatus count times, interval apart,
+// in a single shell session. The output is a JSON array string; see UnwrapJSONString.
+func (cgm *CaptureGetMongoData) RunMetricsSamplerWithEval(interval time.Duration, count int) error {
+	cgm.Getparsedjsonoutput = &bytes.Buffer{}
+	command := fmt.Sprintf(
+		"var _sampleIntervalMS = %d; var _sampleCount = %d;\n%s",
+		interval.Milliseconds(),
+		count,
+		MetricsSamplerScriptCode,
+	)
+	cgm.CurrentCommand = &command
+
+	err := cgm.RunCurrentDBCommand()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UnwrapJSONString returns the JSON document produced by scripts that end in JSON.stringify(...).
+// mongosh --json=canonical prints such a result as a quoted JSON string while the legacy mongo
+// shell prints it raw, so both forms are accepted.
+func UnwrapJSONString(b []byte) ([]byte, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || b[0] != '"' {
+		return b, nil
+	}
+	var s string
+	if err := json.NewDecoder(bytes.NewReader(b)).Decode(&s); err != nil {
+		return nil, fmt.Errorf("unable to decode shell output as JSON string: %w", err)
+	}
+	return []byte(s), nil
+}
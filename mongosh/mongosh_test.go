@@ -329,3 +329,23 @@ func TestFormatMongoShellErrorIncludesShellOutputAndHint(t *testing.T) {
 // ### START TEST RunShell
 // All other sub functions covered and no addtional logic here so can be skipped
 // ### END TEST RunShell
+
+func TestUnwrapJSONStringQuotedAndRaw(t *testing.T) {
+	quoted := []byte("\"[{\\\"ts\\\":1}]\"\n")
+	got, err := UnwrapJSONString(quoted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `[{"ts":1}]` {
+		t.Fatalf("unexpected unwrapped output: %s", got)
+	}
+
+	raw := []byte(" [{\"ts\":1}]\n")
+	got, err = UnwrapJSONString(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != `[{"ts":1}]` {
+		t.Fatalf("raw output should be returned as-is: %s", got)
+	}
+}
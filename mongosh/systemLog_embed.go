@@ -20,3 +20,12 @@ import (
 
 //go:embed assets/mongologarchiver/systemLog.js
 var GetSystemLogDBCommand string
+
+//go:embed assets/mongologarchiver/getLog.js
+var GetLogScriptCode string
+
+//go:embed assets/mongologarchiver/syslogIdentifier.js
+var GetSyslogIdentifierCommand string
+
+//go:embed assets/mongologarchiver/auditLog.js
+var GetAuditLogOptionsCommand string
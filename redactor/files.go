@@ -0,0 +1,203 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redactor
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RecordFileName is written to the node output directory when redaction is applied.
+const RecordFileName = "redaction.json"
+
+// Artifact names used in the redaction record.
+const (
+	ArtifactLogs         = "mongod logs"
+	ArtifactGetMongoData = "getMongoData.json"
+)
+
+// RedactLines copies r to w line by line, redacting each line, and returns the number of
+// lines changed.
+func (r *Redactor) RedactLines(in io.Reader, out io.Writer) (int64, error) {
+	reader := bufio.NewReaderSize(in, 1024*1024)
+	writer := bufio.NewWriter(out)
+	var changed int64
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			redacted, ok := r.RedactLine(line)
+			if ok {
+				changed++
+			}
+			if _, werr := writer.Write(redacted); werr != nil {
+				return changed, werr
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return changed, err
+		}
+	}
+	r.count(ArtifactLogs, changed)
+	return changed, writer.Flush()
+}
+
+// RedactLogFile writes a redacted copy of the log file src to a new temporary file and
+// returns its path. Gzip compressed files stay compressed.
+func (r *Redactor) RedactLogFile(src string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp("", "dcrcli-redacted-*")
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	var reader io.Reader = in
+	var writer io.Writer = out
+	var gzw *gzip.Writer
+	if strings.HasSuffix(src, ".gz") {
+		gzr, err := gzip.NewReader(in)
+		if err != nil {
+			os.Remove(out.Name())
+			return "", fmt.Errorf("error reading compressed log %s: %w", src, err)
+		}
+		defer gzr.Close()
+		reader = gzr
+		gzw = gzip.NewWriter(out)
+		writer = gzw
+	}
+
+	_, err = r.RedactLines(reader, writer)
+	if err == nil && gzw != nil {
+		err = gzw.Close()
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		r.recordError(fmt.Errorf("%s: %w", filepath.Base(src), err))
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// RedactGetMongoDataFile rewrites the getMongoData output at path in place. The file is a
+// JSON array of section documents; rules with a Section only apply to that subsection.
+// A file that cannot be parsed is removed so unredacted data is never archived.
+func (r *Redactor) RedactGetMongoDataFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	doc, err := parseJSON(data)
+	if err == nil {
+		if _, ok := doc.([]any); !ok {
+			err = fmt.Errorf("expected a JSON array")
+		}
+	}
+	if err != nil {
+		r.recordError(fmt.Errorf("%s could not be parsed and was removed: %w", filepath.Base(path), err))
+		if rmErr := os.Remove(path); rmErr != nil {
+			return fmt.Errorf("error removing unredactable %s: %w", path, rmErr)
+		}
+		return fmt.Errorf("error parsing %s for redaction, file removed: %w", path, err)
+	}
+
+	var total int64
+	for i, section := range doc.([]any) {
+		rules := make([]compiledRule, 0, len(r.gmdRules))
+		for _, rule := range r.gmdRules {
+			if rule.Section == "" || rule.Section == subsectionOf(section) {
+				rules = append(rules, rule)
+			}
+		}
+		var n int
+		doc.([]any)[i], n = applyRules(section, rules)
+		total += int64(n)
+	}
+	r.count(ArtifactGetMongoData, total)
+
+	var buf bytes.Buffer
+	encodeJSON(&buf, doc, "    ")
+	buf.WriteByte('\n')
+	return os.WriteFile(path, buf.Bytes(), 0666)
+}
+
+func subsectionOf(section any) string {
+	obj, ok := section.(jsonObject)
+	if !ok {
+		return ""
+	}
+	for _, m := range obj {
+		if m.Key == "subsection" {
+			s, _ := m.Value.(string)
+			return s
+		}
+	}
+	return ""
+}
+
+// record is the content of redaction.json.
+type record struct {
+	RedactionApplied bool             `json:"redactionApplied"`
+	Replacement      string           `json:"replacement"`
+	Time             string           `json:"time"`
+	Rules            []Rule           `json:"rules"`
+	TextPatterns     []string         `json:"textPatterns"`
+	Redacted         map[string]int64 `json:"redacted"`
+	Errors           []string         `json:"errors,omitempty"`
+}
+
+// WriteRecord writes redaction.json into dir, recording that redaction was applied, the
+// rules used and how many lines and fields were changed, then resets the counters for the
+// next node.
+func (r *Redactor) WriteRecord(dir string) error {
+	r.mu.Lock()
+	rec := record{
+		RedactionApplied: true,
+		Replacement:      Replacement,
+		Time:             time.Now().UTC().Format(time.RFC3339),
+		Rules:            r.config.Rules,
+		TextPatterns:     r.config.TextPatterns,
+		Redacted:         r.counts,
+		Errors:           r.errors,
+	}
+	sort.Strings(rec.Errors)
+	r.counts = make(map[string]int64)
+	r.errors = nil
+	r.mu.Unlock()
+
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, RecordFileName), append(data, '\n'), 0644)
+}
@@ -0,0 +1,166 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redactor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// jsonObject keeps the members of a JSON object in their original order, so rewritten
+// log lines and getMongoData sections read the same as the originals. Values are
+// jsonObject, []any, string, json.Number, bool or nil.
+type jsonObject []jsonMember
+
+type jsonMember struct {
+	Key   string
+	Value any
+}
+
+// parseJSON decodes a single JSON value from data.
+func parseJSON(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected data after JSON value")
+	}
+	return v, nil
+}
+
+func decodeValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		return tok, nil
+	}
+	switch delim {
+	case '{':
+		obj := jsonObject{}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := keyTok.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected object key %v", keyTok)
+			}
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj = append(obj, jsonMember{Key: key, Value: value})
+		}
+		_, err = dec.Token()
+		return obj, err
+	case '[':
+		arr := []any{}
+		for dec.More() {
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		_, err = dec.Token()
+		return arr, err
+	}
+	return nil, fmt.Errorf("unexpected delimiter %v", delim)
+}
+
+// encodeJSON writes v compactly when indent is empty, otherwise one member per line.
+func encodeJSON(buf *bytes.Buffer, v any, indent string) {
+	encodeValue(buf, v, indent, 0)
+}
+
+func encodeValue(buf *bytes.Buffer, v any, indent string, level int) {
+	switch val := v.(type) {
+	case jsonObject:
+		if len(val) == 0 {
+			buf.WriteString("{}")
+			return
+		}
+		buf.WriteByte('{')
+		for i, m := range val {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			newline(buf, indent, level+1)
+			encodeString(buf, m.Key)
+			buf.WriteByte(':')
+			if indent != "" {
+				buf.WriteByte(' ')
+			}
+			encodeValue(buf, m.Value, indent, level+1)
+		}
+		newline(buf, indent, level)
+		buf.WriteByte('}')
+	case []any:
+		if len(val) == 0 {
+			buf.WriteString("[]")
+			return
+		}
+		buf.WriteByte('[')
+		for i, e := range val {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			newline(buf, indent, level+1)
+			encodeValue(buf, e, indent, level+1)
+		}
+		newline(buf, indent, level)
+		buf.WriteByte(']')
+	case string:
+		encodeString(buf, val)
+	case json.Number:
+		buf.WriteString(val.String())
+	case bool:
+		if val {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case nil:
+		buf.WriteString("null")
+	default:
+		encodeString(buf, fmt.Sprint(val))
+	}
+}
+
+func newline(buf *bytes.Buffer, indent string, level int) {
+	if indent == "" {
+		return
+	}
+	buf.WriteByte('\n')
+	buf.WriteString(strings.Repeat(indent, level))
+}
+
+func encodeString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	// Encode appends a newline that is not part of the value
+	_ = enc.Encode(s)
+	buf.Truncate(buf.Len() - 1)
+}
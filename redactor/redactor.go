@@ -0,0 +1,302 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redactor removes personal data such as query values, client addresses and user
+// names from mongod logs and getMongoData output before they are archived.
+package redactor
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Replacement is written in place of redacted values. It is the same marker mongod uses
+// for redactClientLogData.
+const Replacement = "###"
+
+// Rule targets
+const (
+	TargetLog          = "log"
+	TargetGetMongoData = "getmongodata"
+)
+
+// Rule actions
+const (
+	// ActionMask keeps the structure of the value and replaces every scalar inside it,
+	// so a query filter keeps its field names and operators but loses its values.
+	ActionMask = "mask"
+	// ActionReplace replaces the whole value.
+	ActionReplace = "replace"
+	// ActionRemove deletes the field.
+	ActionRemove = "remove"
+)
+
+// Rule selects fields to redact by a dotted path.
+//
+// For logs the path starts at the top of a structured (4.4+) log line, e.g. "attr.remote".
+// For getMongoData it starts at each section document, e.g. "output.parsed.net.bindIp";
+// Section limits the rule to one subsection such as "command_line_info".
+// A "*" segment matches any one field and "**" matches any number of levels. Arrays are
+// searched element by element.
+type Rule struct {
+	Target  string `json:"target"`
+	Section string `json:"section,omitempty"`
+	Path    string `json:"path"`
+	Action  string `json:"action"`
+}
+
+// Config is the set of redaction rules. TextPatterns are regular expressions replaced in
+// log lines that are not structured JSON, such as the legacy text format.
+type Config struct {
+	Rules        []Rule   `json:"rules,omitempty"`
+	TextPatterns []string `json:"text_patterns,omitempty"`
+}
+
+// DefaultConfig redacts query predicates, document contents, client addresses and user
+// names from logs, command parameters from JSON audit logs, and bind addresses, LDAP
+// settings and query predicates from getMongoData.
+func DefaultConfig() Config {
+	return Config{
+		Rules: []Rule{
+			{Target: TargetLog, Path: "attr.**.filter", Action: ActionMask},
+			{Target: TargetLog, Path: "attr.**.query", Action: ActionMask},
+			{Target: TargetLog, Path: "attr.**.q", Action: ActionMask},
+			{Target: TargetLog, Path: "attr.**.u", Action: ActionMask},
+			{Target: TargetLog, Path: "attr.**.pipeline", Action: ActionMask},
+			{Target: TargetLog, Path: "attr.**.documents", Action: ActionMask},
+			{Target: TargetLog, Path: "attr.**.keyValue", Action: ActionMask},
+			{Target: TargetLog, Path: "attr.**.remote", Action: ActionReplace},
+			{Target: TargetLog, Path: "attr.**.user", Action: ActionReplace},
+			{Target: TargetLog, Path: "attr.**.principalName", Action: ActionReplace},
+			// JSON audit log lines have no attr, these fields are at the top
+			{Target: TargetLog, Path: "remote", Action: ActionReplace},
+			{Target: TargetLog, Path: "users.user", Action: ActionReplace},
+			{Target: TargetLog, Path: "param", Action: ActionMask},
+			{Target: TargetGetMongoData, Path: "output.**.filter", Action: ActionMask},
+			{Target: TargetGetMongoData, Path: "output.**.query", Action: ActionMask},
+			{Target: TargetGetMongoData, Section: "command_line_info", Path: "output.parsed.net.bindIp", Action: ActionReplace},
+			{Target: TargetGetMongoData, Section: "server_parameters", Path: "output.ldapServers", Action: ActionReplace},
+			{Target: TargetGetMongoData, Section: "server_parameters", Path: "output.ldapQueryUser", Action: ActionReplace},
+		},
+		TextPatterns: []string{
+			// IPv4 client addresses in legacy "connection accepted from 10.0.0.1:5000" lines
+			`\b(?:\d{1,3}\.){3}\d{1,3}\b`,
+		},
+	}
+}
+
+type compiledRule struct {
+	Rule
+	segments []string
+}
+
+// Redactor applies a Config and counts what it changed for the redaction record.
+type Redactor struct {
+	config       Config
+	logRules     []compiledRule
+	gmdRules     []compiledRule
+	textPatterns []*regexp.Regexp
+
+	mu     sync.Mutex
+	counts map[string]int64
+	errors []string
+}
+
+// New validates cfg and prepares it for use.
+func New(cfg Config) (*Redactor, error) {
+	r := &Redactor{config: cfg, counts: make(map[string]int64)}
+	for i, rule := range cfg.Rules {
+		cr, err := compileRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("redaction rule %d: %w", i+1, err)
+		}
+		switch rule.Target {
+		case TargetLog:
+			r.logRules = append(r.logRules, cr)
+		case TargetGetMongoData:
+			r.gmdRules = append(r.gmdRules, cr)
+		}
+	}
+	for _, pattern := range cfg.TextPatterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("redaction text pattern %q: %w", pattern, err)
+		}
+		r.textPatterns = append(r.textPatterns, re)
+	}
+	return r, nil
+}
+
+func compileRule(rule Rule) (compiledRule, error) {
+	if rule.Target != TargetLog && rule.Target != TargetGetMongoData {
+		return compiledRule{}, fmt.Errorf("target must be %q or %q, got %q", TargetLog, TargetGetMongoData, rule.Target)
+	}
+	switch rule.Action {
+	case ActionMask, ActionReplace, ActionRemove:
+	default:
+		return compiledRule{}, fmt.Errorf(
+			"action must be %q, %q or %q, got %q", ActionMask, ActionReplace, ActionRemove, rule.Action,
+		)
+	}
+	segments := strings.Split(rule.Path, ".")
+	for _, s := range segments {
+		if s == "" {
+			return compiledRule{}, fmt.Errorf("invalid path %q", rule.Path)
+		}
+	}
+	if segments[len(segments)-1] == "**" {
+		return compiledRule{}, fmt.Errorf("path %q must not end with **", rule.Path)
+	}
+	return compiledRule{Rule: rule, segments: segments}, nil
+}
+
+// Config returns the rules in use, for the redaction record.
+func (r *Redactor) Config() Config {
+	return r.config
+}
+
+func (r *Redactor) count(artifact string, n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts[artifact] += n
+}
+
+func (r *Redactor) recordError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.errors = append(r.errors, err.Error())
+}
+
+// RedactLine redacts one log line and reports whether it changed. Structured lines are
+// rewritten field by field; a JSON object after a syslog prefix is found too. Other lines
+// have the text patterns replaced.
+func (r *Redactor) RedactLine(line []byte) ([]byte, bool) {
+	body := bytes.TrimRight(line, "\r\n")
+	eol := line[len(body):]
+
+	if start := bytes.IndexByte(body, '{'); start >= 0 && bytes.HasSuffix(body, []byte("}")) {
+		if v, err := parseJSON(body[start:]); err == nil {
+			if obj, ok := v.(jsonObject); ok {
+				redacted, n := applyRules(obj, r.logRules)
+				if n == 0 {
+					return line, false
+				}
+				var buf bytes.Buffer
+				buf.Write(body[:start])
+				encodeJSON(&buf, redacted, "")
+				buf.Write(eol)
+				return buf.Bytes(), true
+			}
+		}
+	}
+
+	changed := false
+	for _, re := range r.textPatterns {
+		if re.Match(body) {
+			body = re.ReplaceAll(body, []byte(Replacement))
+			changed = true
+		}
+	}
+	if !changed {
+		return line, false
+	}
+	return append(append([]byte{}, body...), eol...), true
+}
+
+// applyRules runs rules over a parsed document and returns it with the number of fields
+// that were redacted.
+func applyRules(doc any, rules []compiledRule) (any, int) {
+	total := 0
+	for _, rule := range rules {
+		var n int
+		doc, n, _ = applyPath(doc, rule.segments, rule.Action)
+		total += n
+	}
+	return doc, total
+}
+
+// applyPath walks segments through v and applies action at the end of the path.
+// The returned bool asks the parent to remove the field.
+func applyPath(v any, segments []string, action string) (any, int, bool) {
+	if len(segments) == 0 {
+		switch action {
+		case ActionRemove:
+			return nil, 1, true
+		case ActionReplace:
+			return Replacement, 1, false
+		default:
+			return mask(v), 1, false
+		}
+	}
+
+	switch val := v.(type) {
+	case []any:
+		total := 0
+		for i := range val {
+			var n int
+			val[i], n, _ = applyPath(val[i], segments, action)
+			total += n
+		}
+		return val, total, false
+	case jsonObject:
+		segment := segments[0]
+		total := 0
+		if segment == "**" {
+			// "**" matches zero levels here, and any number of levels below each member
+			var n int
+			v, n, _ = applyPath(val, segments[1:], action)
+			total += n
+			val = v.(jsonObject)
+		}
+		kept := val[:0]
+		for _, m := range val {
+			var n int
+			var remove bool
+			switch {
+			case segment == "**":
+				m.Value, n, _ = applyPath(m.Value, segments, action)
+			case segment == "*" || segment == m.Key:
+				m.Value, n, remove = applyPath(m.Value, segments[1:], action)
+			}
+			total += n
+			if !remove {
+				kept = append(kept, m)
+			}
+		}
+		return kept, total, false
+	}
+	return v, 0, false
+}
+
+// mask replaces every scalar inside v and keeps objects and arrays.
+func mask(v any) any {
+	switch val := v.(type) {
+	case jsonObject:
+		for i := range val {
+			val[i].Value = mask(val[i].Value)
+		}
+		return val
+	case []any:
+		for i := range val {
+			val[i] = mask(val[i])
+		}
+		return val
+	case nil:
+		return nil
+	}
+	return Replacement
+}
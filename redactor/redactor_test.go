@@ -0,0 +1,132 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redactor
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newDefaultRedactor(t *testing.T) *Redactor {
+	t.Helper()
+	r, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRedactLineSlowQuery(t *testing.T) {
+	r := newDefaultRedactor(t)
+	line := `{"t":{"$date":"2024-03-09T10:00:00.000+00:00"},"s":"I","c":"COMMAND","id":51803,"msg":"Slow query",` +
+		`"attr":{"type":"command","ns":"shop.users","command":{"find":"users","filter":{"email":"a@b.com","age":{"$gt":30}}},` +
+		`"remote":"10.1.2.3:51234","durationMillis":120}}` + "\n"
+
+	got, changed := r.RedactLine([]byte(line))
+	if !changed {
+		t.Fatal("slow query line should be redacted")
+	}
+	want := `{"t":{"$date":"2024-03-09T10:00:00.000+00:00"},"s":"I","c":"COMMAND","id":51803,"msg":"Slow query",` +
+		`"attr":{"type":"command","ns":"shop.users","command":{"find":"users","filter":{"email":"###","age":{"$gt":"###"}}},` +
+		`"remote":"###","durationMillis":120}}` + "\n"
+	if string(got) != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestRedactLineAuditLog(t *testing.T) {
+	r := newDefaultRedactor(t)
+	line := `{"atype":"authCheck","ts":{"$date":"2024-03-09T10:00:00.000+00:00"},"local":{"ip":"10.0.0.1","port":27017},` +
+		`"remote":{"ip":"10.1.2.3","port":51234},"users":[{"user":"alice","db":"admin"}],` +
+		`"param":{"command":"find","ns":"shop.users","args":{"filter":{"email":"a@b.com"}}},"result":0}` + "\n"
+
+	got, changed := r.RedactLine([]byte(line))
+	if !changed {
+		t.Fatal("audit line should be redacted")
+	}
+	want := `{"atype":"authCheck","ts":{"$date":"2024-03-09T10:00:00.000+00:00"},"local":{"ip":"10.0.0.1","port":27017},` +
+		`"remote":"###","users":[{"user":"###","db":"admin"}],` +
+		`"param":{"command":"###","ns":"###","args":{"filter":{"email":"###"}}},"result":0}` + "\n"
+	if string(got) != want {
+		t.Fatalf("got  %s\nwant %s", got, want)
+	}
+}
+
+func TestRedactLineLegacyTextAndUnchanged(t *testing.T) {
+	r := newDefaultRedactor(t)
+
+	got, changed := r.RedactLine([]byte("2019-03-09T10:00:00.000+0000 I NETWORK  [listener] connection accepted from 10.0.0.5:5000 #1\n"))
+	if !changed || strings.Contains(string(got), "10.0.0.5") || !strings.HasSuffix(string(got), "\n") {
+		t.Fatalf("legacy line not redacted: %q", got)
+	}
+
+	plain := `{"t":{"$date":"2024-03-09T10:00:00.000+00:00"},"s":"I","c":"CONTROL","msg":"startup"}` + "\n"
+	got, changed = r.RedactLine([]byte(plain))
+	if changed || string(got) != plain {
+		t.Fatalf("line without matching fields changed: %q", got)
+	}
+}
+
+func TestRemoveActionAndSection(t *testing.T) {
+	r, err := New(Config{Rules: []Rule{
+		{Target: TargetGetMongoData, Section: "command_line_info", Path: "output.parsed.net.bindIp", Action: ActionRemove},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "getMongoData.json")
+	input := `[{"subsection":"command_line_info","output":{"parsed":{"net":{"bindIp":"10.0.0.1","port":27017}}}},` +
+		`{"subsection":"other","output":{"parsed":{"net":{"bindIp":"10.0.0.2"}}}}]`
+	if err := os.WriteFile(path, []byte(input), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.RedactGetMongoDataFile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "10.0.0.1") || !strings.Contains(string(data), "10.0.0.2") {
+		t.Fatalf("section rule applied incorrectly: %s", data)
+	}
+
+	if err := r.WriteRecord(filepath.Dir(path)); err != nil {
+		t.Fatal(err)
+	}
+	var rec record
+	data, _ = os.ReadFile(filepath.Join(filepath.Dir(path), RecordFileName))
+	if err := json.Unmarshal(data, &rec); err != nil || !rec.RedactionApplied || rec.Redacted[ArtifactGetMongoData] != 1 {
+		t.Fatalf("unexpected record %s (%v)", data, err)
+	}
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	bad := []Rule{
+		{Target: "ftdc", Path: "a", Action: ActionMask},
+		{Target: TargetLog, Path: "a", Action: "hash"},
+		{Target: TargetLog, Path: "attr.**", Action: ActionMask},
+		{Target: TargetLog, Path: "attr..x", Action: ActionMask},
+	}
+	for _, rule := range bad {
+		if _, err := New(Config{Rules: []Rule{rule}}); err == nil {
+			t.Errorf("rule %+v should be rejected", rule)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package timewindow holds the -since/-until incident window used to limit collected data.
+package timewindow
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Window is a closed time interval. A zero Since or Until leaves that side unbounded,
+// so the zero Window matches everything.
+type Window struct {
+	Since time.Time
+	Until time.Time
+}
+
+// layouts accepted by Parse. Values without an offset are read as UTC.
+var layouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ParseTime reads an absolute timestamp such as "2024-03-09T10:00:00Z" or "2024-03-09",
+// or a duration such as "36h" meaning that long before now. An empty value is the zero time.
+func ParseTime(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d < 0 {
+			return time.Time{}, fmt.Errorf("duration %q must not be negative", value)
+		}
+		return now.Add(-d), nil
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, time.UTC); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf(
+		"invalid time %q (want RFC3339 like 2024-03-09T10:00:00Z, a date like 2024-03-09, or a duration before now like 24h)",
+		value,
+	)
+}
+
+// Parse builds a Window from -since/-until style values.
+func Parse(since string, until string, now time.Time) (Window, error) {
+	var w Window
+	var err error
+	w.Since, err = ParseTime(since, now)
+	if err != nil {
+		return Window{}, fmt.Errorf("since: %w", err)
+	}
+	w.Until, err = ParseTime(until, now)
+	if err != nil {
+		return Window{}, fmt.Errorf("until: %w", err)
+	}
+	if !w.Since.IsZero() && !w.Until.IsZero() && w.Until.Before(w.Since) {
+		return Window{}, fmt.Errorf("until (%s) is before since (%s)", w.Until.Format(time.RFC3339), w.Since.Format(time.RFC3339))
+	}
+	return w, nil
+}
+
+// IsZero reports whether the window is unbounded on both sides.
+func (w Window) IsZero() bool {
+	return w.Since.IsZero() && w.Until.IsZero()
+}
+
+// Contains reports whether t is inside the window.
+func (w Window) Contains(t time.Time) bool {
+	if !w.Since.IsZero() && t.Before(w.Since) {
+		return false
+	}
+	if !w.Until.IsZero() && t.After(w.Until) {
+		return false
+	}
+	return true
+}
+
+// Overlaps reports whether the data range [start, end] intersects the window.
+// A zero start or end leaves that side of the range unbounded.
+func (w Window) Overlaps(start time.Time, end time.Time) bool {
+	if !w.Until.IsZero() && !start.IsZero() && start.After(w.Until) {
+		return false
+	}
+	if !w.Since.IsZero() && !end.IsZero() && end.Before(w.Since) {
+		return false
+	}
+	return true
+}
+
+// Covers reports whether the data range [start, end] lies entirely inside the window,
+// i.e. nothing would be lost by taking the whole range without trimming.
+func (w Window) Covers(start time.Time, end time.Time) bool {
+	if !w.Since.IsZero() && (start.IsZero() || start.Before(w.Since)) {
+		return false
+	}
+	if !w.Until.IsZero() && (end.IsZero() || end.After(w.Until)) {
+		return false
+	}
+	return true
+}
+
+// String formats the window for log and console messages.
+func (w Window) String() string {
+	since, until := "-", "-"
+	if !w.Since.IsZero() {
+		since = w.Since.Format(time.RFC3339)
+	}
+	if !w.Until.IsZero() {
+		until = w.Until.Format(time.RFC3339)
+	}
+	return "[" + since + ", " + until + "]"
+}
@@ -0,0 +1,83 @@
+// Copyright 2023 MongoDB Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package timewindow
+
+import (
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+func TestParseTimeFormats(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want time.Time
+	}{
+		{"", time.Time{}},
+		{"2024-03-09T10:00:00Z", time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC)},
+		{"2024-03-09T10:00:00+02:00", time.Date(2024, 3, 9, 8, 0, 0, 0, time.UTC)},
+		{"2024-03-09T10:00", time.Date(2024, 3, 9, 10, 0, 0, 0, time.UTC)},
+		{"2024-03-09", time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)},
+		{"36h", time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC)},
+	} {
+		got, err := ParseTime(tc.in, now)
+		if err != nil {
+			t.Fatalf("ParseTime(%q): %v", tc.in, err)
+		}
+		if !got.Equal(tc.want) {
+			t.Fatalf("ParseTime(%q) = %v want %v", tc.in, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"yesterday", "-5h", "2024-13-01"} {
+		if _, err := ParseTime(bad, now); err == nil {
+			t.Fatalf("ParseTime(%q) wanted error", bad)
+		}
+	}
+}
+
+func TestParseRejectsInvertedWindow(t *testing.T) {
+	if _, err := Parse("2024-03-09", "2024-03-08", now); err == nil {
+		t.Fatal("until before since should be rejected")
+	}
+}
+
+func TestWindowOverlapsAndCovers(t *testing.T) {
+	w := Window{
+		Since: time.Date(2024, 3, 9, 0, 0, 0, 0, time.UTC),
+		Until: time.Date(2024, 3, 9, 23, 0, 0, 0, time.UTC),
+	}
+	day := func(d, h int) time.Time { return time.Date(2024, 3, d, h, 0, 0, 0, time.UTC) }
+
+	if w.Overlaps(day(7, 0), day(8, 0)) {
+		t.Fatal("range before window should not overlap")
+	}
+	if w.Overlaps(day(10, 0), day(10, 5)) {
+		t.Fatal("range after window should not overlap")
+	}
+	if !w.Overlaps(day(8, 0), day(9, 5)) || w.Covers(day(8, 0), day(9, 5)) {
+		t.Fatal("range straddling since should overlap but not be covered")
+	}
+	if !w.Overlaps(time.Time{}, day(9, 1)) {
+		t.Fatal("open start range reaching the window should overlap")
+	}
+	if !w.Covers(day(9, 1), day(9, 2)) {
+		t.Fatal("range inside the window should be covered")
+	}
+	if !(Window{}).Covers(time.Time{}, time.Time{}) {
+		t.Fatal("zero window covers everything")
+	}
+}